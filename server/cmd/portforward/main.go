@@ -0,0 +1,152 @@
+/*
+@File    :   main.go
+@Desc    :   port-forward命令行客户端，将k8s-server的port-forward websocket暴露为本地TCP端口
+
+用法:
+
+	go run ./cmd/portforward -server ws://127.0.0.1:9090 -token <token> \
+		-namespace default -pod nginx-7b4f9c8d5-abcde -port 8080 -local 18080
+
+之后访问本地的 127.0.0.1:18080 即可访问pod的8080端口，每个本地TCP连接对应一个websocket连接
+*/
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/gorilla/websocket"
+)
+
+// 与服务端约定的子协议
+const portForwardSubprotocol = "k8s-portforward"
+
+func main() {
+	server := flag.String("server", "ws://127.0.0.1:9090", "k8s-server地址, 例如 ws://127.0.0.1:9090 或 wss://k8s-admin.example.com")
+	token := flag.String("token", os.Getenv("K8S_SERVER_TOKEN"), "登录接口返回的token, 也可以通过环境变量K8S_SERVER_TOKEN设置")
+	namespace := flag.String("namespace", "default", "pod所在的namespace")
+	pod := flag.String("pod", "", "pod名")
+	port := flag.Int("port", 0, "pod端口")
+	localAddr := flag.String("local", "", "本地监听地址, 例如 18080 或 127.0.0.1:18080, 默认与pod端口相同")
+	flag.Parse()
+
+	if *pod == "" || *port == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *localAddr == "" {
+		*localAddr = strconv.Itoa(*port)
+	}
+	if _, err := strconv.Atoi(*localAddr); err == nil {
+		*localAddr = "127.0.0.1:" + *localAddr
+	}
+
+	u, err := url.Parse(*server)
+	if err != nil {
+		log.Fatalf("server地址不合法: %v", err)
+	}
+	u.Path = "/api/k8s/pod/portforward"
+	u.RawQuery = url.Values{
+		"namespace": {*namespace},
+		"pod_name":  {*pod},
+		"port":      {strconv.Itoa(*port)},
+	}.Encode()
+
+	listener, err := net.Listen("tcp", *localAddr)
+	if err != nil {
+		log.Fatalf("监听本地端口失败: %v", err)
+	}
+	log.Printf("Forwarding from %s -> %s/%s:%d", listener.Addr(), *namespace, *pod, *port)
+
+	//ctrl+c退出
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		listener.Close()
+	}()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go handle(conn, u.String(), *token)
+	}
+}
+
+// 为每个本地连接建立一个websocket连接，并双向转发数据
+func handle(conn net.Conn, wsURL, token string) {
+	defer conn.Close()
+
+	header := http.Header{}
+	if token != "" {
+		header.Set("Authorization", token)
+	}
+	dialer := websocket.Dialer{Subprotocols: []string{portForwardSubprotocol}}
+	wsConn, resp, err := dialer.Dial(wsURL, header)
+	if err != nil {
+		if resp != nil {
+			body, _ := io.ReadAll(resp.Body)
+			err = fmt.Errorf("%v: %s", err, body)
+		}
+		log.Printf("建立websocket连接失败: %v", err)
+		return
+	}
+	defer wsConn.Close()
+	log.Printf("Handling connection from %s", conn.RemoteAddr())
+
+	var once sync.Once
+	done := make(chan struct{})
+	finish := func() { once.Do(func() { close(done) }) }
+
+	//本地 -> websocket
+	go func() {
+		defer finish()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := conn.Read(buf)
+			if n > 0 {
+				if werr := wsConn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	//websocket -> 本地
+	go func() {
+		defer finish()
+		for {
+			messageType, data, err := wsConn.ReadMessage()
+			if err != nil {
+				if ce, ok := err.(*websocket.CloseError); ok && ce.Code != websocket.CloseNormalClosure {
+					log.Printf("连接被服务端关闭: %v", ce)
+				}
+				return
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			if _, err := conn.Write(data); err != nil {
+				return
+			}
+		}
+	}()
+
+	<-done
+}
@@ -0,0 +1,170 @@
+package controller
+
+import (
+	"k8s-server/response"
+	"k8s-server/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonderivan/logger"
+)
+
+var Change change
+
+type change struct{}
+
+// 获取变更申请列表
+func (c *change) GetList(ctx *gin.Context) {
+	params := new(struct {
+		Status string `form:"status"`
+		Page   int    `form:"page"`
+		Limit  int    `form:"limit"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.ChangeRequest.GetList(ctx.Request.Context(), params.Status, params.Page, params.Limit)
+	if err != nil {
+		logger.Error("获取变更申请列表失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取变更申请列表成功", data)
+}
+
+// 获取变更申请详情，包含原始请求和执行结果
+func (c *change) GetDetail(ctx *gin.Context) {
+	params := new(struct {
+		ID int `form:"id"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.ChangeRequest.GetDetail(ctx.Request.Context(), params.ID)
+	if err != nil {
+		logger.Error("获取变更申请详情失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取变更申请详情成功", data)
+}
+
+// 审批通过并执行变更申请
+func (c *change) Approve(ctx *gin.Context) {
+	params := new(struct {
+		ID int `json:"id"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.ChangeRequest.Approve(ctx.Request.Context(), params.ID)
+	if err != nil {
+		logger.Error("审批变更申请失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "审批变更申请成功", data)
+}
+
+// 拒绝变更申请
+func (c *change) Reject(ctx *gin.Context) {
+	params := new(struct {
+		ID     int    `json:"id"`
+		Reason string `json:"reason"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := service.ChangeRequest.Reject(ctx.Request.Context(), params.ID, params.Reason); err != nil {
+		logger.Error("拒绝变更申请失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "拒绝变更申请成功", nil)
+}
+
+// 获取所有审批策略
+func (c *change) GetPolicies(ctx *gin.Context) {
+	data, err := service.ChangePolicy.GetList(ctx.Request.Context())
+	if err != nil {
+		logger.Error("获取审批策略列表失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取审批策略列表成功", data)
+}
+
+// 新增审批策略
+func (c *change) CreatePolicy(ctx *gin.Context) {
+	params := new(service.ChangePolicyCreate)
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.ChangePolicy.Create(ctx.Request.Context(), params)
+	if err != nil {
+		logger.Error("新增审批策略失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "新增审批策略成功", data)
+}
+
+// 修改审批策略
+func (c *change) UpdatePolicy(ctx *gin.Context) {
+	params := new(service.ChangePolicyCreate)
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.ChangePolicy.Update(ctx.Request.Context(), params)
+	if err != nil {
+		logger.Error("修改审批策略失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "修改审批策略成功", data)
+}
+
+// 删除审批策略
+func (c *change) DeletePolicy(ctx *gin.Context) {
+	params := new(struct {
+		ID int `json:"id"`
+	})
+	//DELETE请求，绑定参数方法改为ctx.ShouldBindJSON
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := service.ChangePolicy.Delete(ctx.Request.Context(), params.ID); err != nil {
+		logger.Error("删除审批策略失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "删除审批策略成功", nil)
+}
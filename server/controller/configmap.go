@@ -3,8 +3,8 @@ package controller
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/wonderivan/logger"
+	"k8s-server/response"
 	"k8s-server/service"
-	"net/http"
 )
 
 var ConfigMap configMap
@@ -20,26 +20,17 @@ func(c *configMap) GetConfigMaps(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg": err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.ConfigMap.GetConfigMaps(params.FilterName, params.Namespace, params.Limit, params.Page)
+	data, err := service.ConfigMap.GetConfigMaps(ctx.Request.Context(), params.FilterName, params.Namespace, params.Limit, params.Page)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg": err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg": "获取ConfigMap列表成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取ConfigMap列表成功", data)
 }
 
 //获取configmap详情
@@ -50,26 +41,17 @@ func(c *configMap) GetConfigMapDetail(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg": err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.ConfigMap.GetConfigMapDetail(params.ConfigMapName, params.Namespace)
+	data, err := service.ConfigMap.GetConfigMapDetail(ctx.Request.Context(), params.ConfigMapName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg": err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg": "获取ConfigMap详情成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取ConfigMap详情成功", data)
 }
 
 //删除configmap
@@ -81,25 +63,16 @@ func(c *configMap) DeleteConfigMap(ctx *gin.Context) {
 	//DELETE请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg": err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.ConfigMap.DeleteConfigMap(params.ConfigMapName, params.Namespace)
+	err := service.ConfigMap.DeleteConfigMap(ctx.Request.Context(), params.ConfigMapName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg": err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg": "删除ConfigMap成功",
-		"data": nil,
-	})
+	response.Success(ctx, "删除ConfigMap成功", nil)
 }
 
 //更新configmap
@@ -111,23 +84,14 @@ func(c *configMap) UpdateConfigMap(ctx *gin.Context) {
 	//PUT请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg": err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.ConfigMap.UpdateConfigMap(params.Namespace, params.Content)
+	err := service.ConfigMap.UpdateConfigMap(ctx.Request.Context(), params.Namespace, params.Content)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg": err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg": "更新ConfigMap成功",
-		"data": nil,
-	})
+	response.Success(ctx, "更新ConfigMap成功", nil)
 }
\ No newline at end of file
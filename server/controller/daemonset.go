@@ -1,8 +1,8 @@
 package controller
 
 import (
+	"k8s-server/response"
 	"k8s-server/service"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wonderivan/logger"
@@ -22,26 +22,17 @@ func (d *daemonSet) GetDaemonSets(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.DaemonSet.GetDaemonSets(params.FilterName, params.Namespace, params.Limit, params.Page)
+	data, err := service.DaemonSet.GetDaemonSets(ctx.Request.Context(), params.FilterName, params.Namespace, params.Limit, params.Page)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取DaemonSet列表成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取DaemonSet列表成功", data)
 }
 
 // 获取daemonset详情
@@ -52,26 +43,17 @@ func (d *daemonSet) GetDaemonSetDetail(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.DaemonSet.GetDaemonSetDetail(params.DaemonSetName, params.Namespace)
+	data, err := service.DaemonSet.GetDaemonSetDetail(ctx.Request.Context(), params.DaemonSetName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取DaemonSet详情成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取DaemonSet详情成功", data)
 }
 
 // 删除daemonset
@@ -83,25 +65,16 @@ func (d *daemonSet) DeleteDaemonSet(ctx *gin.Context) {
 	//DELETE请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.DaemonSet.DeleteDaemonSet(params.DaemonSetName, params.Namespace)
+	err := service.DaemonSet.DeleteDaemonSet(ctx.Request.Context(), params.DaemonSetName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "删除DaemonSet成功",
-		"data": nil,
-	})
+	response.Success(ctx, "删除DaemonSet成功", nil)
 }
 
 // 更新daemonset
@@ -113,23 +86,14 @@ func (d *daemonSet) UpdateDaemonSet(ctx *gin.Context) {
 	//PUT请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.DaemonSet.UpdateDaemonSet(params.Namespace, params.Content)
+	err := service.DaemonSet.UpdateDaemonSet(ctx.Request.Context(), params.Namespace, params.Content)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "更新DaemonSet成功",
-		"data": nil,
-	})
+	response.Success(ctx, "更新DaemonSet成功", nil)
 }
@@ -2,8 +2,8 @@ package controller
 
 import (
 	"fmt"
+	"k8s-server/response"
 	"k8s-server/service"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wonderivan/logger"
@@ -23,26 +23,17 @@ func (d *deployment) GetDeployments(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.Deployment.GetDeployments(params.FilterName, params.Namespace, params.Limit, params.Page)
+	data, err := service.Deployment.GetDeployments(ctx.Request.Context(), params.FilterName, params.Namespace, params.Limit, params.Page)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Deployment列表成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Deployment列表成功", data)
 }
 
 // 获取deployment详情
@@ -53,24 +44,15 @@ func (d *deployment) GetDeploymentDetail(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
-	data, err := service.Deployment.GetDeploymentDetail(params.DeploymentName, params.Namespace)
+	data, err := service.Deployment.GetDeploymentDetail(ctx.Request.Context(), params.DeploymentName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Deployment详情成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Deployment详情成功", data)
 }
 
 // 创建deployment
@@ -82,24 +64,15 @@ func (d *deployment) CreateDeployment(ctx *gin.Context) {
 
 	if err = ctx.ShouldBindJSON(deployCreate); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	if err = service.Deployment.CreateDeployment(deployCreate); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+	if err = service.Deployment.CreateDeployment(ctx.Request.Context(), deployCreate); err != nil {
+		response.Error(ctx, err)
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "创建Deployment成功",
-		"data": nil,
-	})
+	response.Success(ctx, "创建Deployment成功", nil)
 }
 
 // 设置deployment副本数
@@ -112,25 +85,16 @@ func (d *deployment) ScaleDeployment(ctx *gin.Context) {
 	//PUT请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.Deployment.ScaleDeployment(params.DeploymentName, params.Namespace, params.ScaleNum)
+	data, err := service.Deployment.ScaleDeployment(ctx.Request.Context(), params.DeploymentName, params.Namespace, params.ScaleNum)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "设置Deployment副本数成功",
-		"data": fmt.Sprintf("最新副本数: %d", data),
-	})
+	response.Success(ctx, "设置Deployment副本数成功", fmt.Sprintf("最新副本数: %d", data))
 }
 
 // 删除deployment
@@ -142,25 +106,16 @@ func (d *deployment) DeleteDeployment(ctx *gin.Context) {
 	//DELETE请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.Deployment.DeleteDeployment(params.DeploymentName, params.Namespace)
+	err := service.Deployment.DeleteDeployment(ctx.Request.Context(), params.DeploymentName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "删除Deployment成功",
-		"data": nil,
-	})
+	response.Success(ctx, "删除Deployment成功", nil)
 }
 
 // 重启deployment
@@ -172,25 +127,16 @@ func (d *deployment) RestartDeployment(ctx *gin.Context) {
 	//PUT请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.Deployment.RestartDeployment(params.DeploymentName, params.Namespace)
+	err := service.Deployment.RestartDeployment(ctx.Request.Context(), params.DeploymentName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "重启Deployment成功",
-		"data": nil,
-	})
+	response.Success(ctx, "重启Deployment成功", nil)
 }
 
 // 更新deployment
@@ -202,40 +148,25 @@ func (d *deployment) UpdateDeployment(ctx *gin.Context) {
 	//PUT请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.Deployment.UpdateDeployment(params.Namespace, params.Content)
+	err := service.Deployment.UpdateDeployment(ctx.Request.Context(), params.Namespace, params.Content)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "更新Deployment成功",
-		"data": nil,
-	})
+	response.Success(ctx, "更新Deployment成功", nil)
 }
 
 // 获取每个namespace的pod数量
 func (d *deployment) GetDeployNumPerNp(ctx *gin.Context) {
-	data, err := service.Deployment.GetDeployNumPerNp()
+	data, err := service.Deployment.GetDeployNumPerNp(ctx.Request.Context())
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取每个namespace的deployment数量成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取每个namespace的deployment数量成功", data)
 }
@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"k8s-server/response"
+	"k8s-server/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonderivan/logger"
+)
+
+var Environment environment
+
+type environment struct{}
+
+// 获取所有环境，按提升顺序排列
+func (e *environment) GetList(ctx *gin.Context) {
+	data, err := service.Environment.GetList(ctx.Request.Context())
+	if err != nil {
+		logger.Error("获取环境列表失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取环境列表成功", data)
+}
+
+// 新增环境
+func (e *environment) Create(ctx *gin.Context) {
+	params := new(service.EnvironmentCreate)
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.Environment.Create(ctx.Request.Context(), params)
+	if err != nil {
+		logger.Error("新增环境失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "新增环境成功", data)
+}
+
+// 修改环境
+func (e *environment) Update(ctx *gin.Context) {
+	params := new(service.EnvironmentCreate)
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.Environment.Update(ctx.Request.Context(), params)
+	if err != nil {
+		logger.Error("修改环境失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "修改环境成功", data)
+}
+
+// 删除环境
+func (e *environment) Delete(ctx *gin.Context) {
+	params := new(struct {
+		ID int `json:"id"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := service.Environment.Delete(ctx.Request.Context(), params.ID); err != nil {
+		logger.Error("删除环境失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "删除环境成功", nil)
+}
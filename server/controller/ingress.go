@@ -1,8 +1,8 @@
 package controller
 
 import (
+	"k8s-server/response"
 	"k8s-server/service"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wonderivan/logger"
@@ -22,26 +22,17 @@ func (i *ingress) GetIngresses(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.Ingress.GetIngresses(params.FilterName, params.Namespace, params.Limit, params.Page)
+	data, err := service.Ingress.GetIngresses(ctx.Request.Context(), params.FilterName, params.Namespace, params.Limit, params.Page)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Ingresst列表成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Ingresst列表成功", data)
 }
 
 // 获取ingress详情
@@ -52,26 +43,17 @@ func (i *ingress) GetIngressDetail(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.Ingress.GetIngresstDetail(params.IngressName, params.Namespace)
+	data, err := service.Ingress.GetIngresstDetail(ctx.Request.Context(), params.IngressName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Ingress详情成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Ingress详情成功", data)
 }
 
 // 删除ingress
@@ -83,25 +65,16 @@ func (i *ingress) DeleteIngress(ctx *gin.Context) {
 	//DELETE请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.Ingress.DeleteIngress(params.IngressName, params.Namespace)
+	err := service.Ingress.DeleteIngress(ctx.Request.Context(), params.IngressName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "删除Ingress成功",
-		"data": nil,
-	})
+	response.Success(ctx, "删除Ingress成功", nil)
 }
 
 // 创建ingress
@@ -113,25 +86,16 @@ func (i *ingress) CreateIngress(ctx *gin.Context) {
 
 	if err = ctx.ShouldBindJSON(ingressCreate); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	if err = service.Ingress.CreateIngress(ingressCreate); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+	if err = service.Ingress.CreateIngress(ctx.Request.Context(), ingressCreate); err != nil {
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "创建Ingress成功",
-		"data": nil,
-	})
+	response.Success(ctx, "创建Ingress成功", nil)
 }
 
 // 更新ingress
@@ -143,23 +107,14 @@ func (i *ingress) UpdateIngress(ctx *gin.Context) {
 	//PUT请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.Ingress.UpdateIngress(params.Namespace, params.Content)
+	err := service.Ingress.UpdateIngress(ctx.Request.Context(), params.Namespace, params.Content)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "更新Ingress成功",
-		"data": nil,
-	})
+	response.Success(ctx, "更新Ingress成功", nil)
 }
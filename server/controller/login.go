@@ -1,8 +1,8 @@
 package controller
 
 import (
+	"k8s-server/response"
 	"k8s-server/service"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wonderivan/logger"
@@ -20,24 +20,15 @@ func (l *login) Auth(ctx *gin.Context) {
 	})
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.Login.Auth(params.UserName, params.Password)
+	token, err := service.Login.Auth(params.UserName, params.Password)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "登录成功",
-		"data": nil,
-	})
+	response.Success(ctx, "登录成功", gin.H{"token": token})
 }
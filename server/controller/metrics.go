@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"k8s-server/response"
+	"k8s-server/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonderivan/logger"
+)
+
+var Metrics metrics
+
+type metrics struct{}
+
+// 获取pod、node或namespace最近一段时间的资源使用量，用于绘制图表
+func (m *metrics) GetHistory(ctx *gin.Context) {
+	params := new(struct {
+		Kind      string `form:"kind"`
+		Namespace string `form:"namespace"`
+		Name      string `form:"name"`
+		Range     string `form:"range"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.MetricsHistory.GetSeries(ctx.Request.Context(), params.Kind, params.Namespace, params.Name, params.Range)
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取资源使用量历史成功", data)
+}
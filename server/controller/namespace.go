@@ -1,8 +1,8 @@
 package controller
 
 import (
+	"k8s-server/response"
 	"k8s-server/service"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wonderivan/logger"
@@ -21,26 +21,17 @@ func (n *namespace) GetNamespaces(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.Namespace.GetNamespaces(params.FilterName, params.Limit, params.Page)
+	data, err := service.Namespace.GetNamespaces(ctx.Request.Context(), params.FilterName, params.Limit, params.Page)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Namespace列表成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Namespace列表成功", data)
 }
 
 // 获取namespace详情
@@ -50,26 +41,17 @@ func (n *namespace) GetNamespaceDetail(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.Namespace.GetNamespaceDetail(params.NamespaceName)
+	data, err := service.Namespace.GetNamespaceDetail(ctx.Request.Context(), params.NamespaceName)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Namespace详情成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Namespace详情成功", data)
 }
 
 // 删除namespace
@@ -80,23 +62,14 @@ func (n *namespace) DeleteNamespace(ctx *gin.Context) {
 	//DELETE请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.Namespace.DeleteNamespace(params.NamespaceName)
+	err := service.Namespace.DeleteNamespace(ctx.Request.Context(), params.NamespaceName)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "删除Namespace成功",
-		"data": nil,
-	})
+	response.Success(ctx, "删除Namespace成功", nil)
 }
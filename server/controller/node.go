@@ -1,8 +1,8 @@
 package controller
 
 import (
+	"k8s-server/response"
 	"k8s-server/service"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wonderivan/logger"
@@ -16,31 +16,23 @@ type node struct{}
 func (n *node) GetNodes(ctx *gin.Context) {
 	params := new(struct {
 		FilterName string `form:"filter_name"`
+		SortBy     string `form:"sort_by"`
 		Page       int    `form:"page"`
 		Limit      int    `form:"limit"`
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.Node.GetNodes(params.FilterName, params.Limit, params.Page)
+	data, err := service.Node.GetNodes(ctx.Request.Context(), params.FilterName, params.SortBy, params.Limit, params.Page)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Node列表成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Node列表成功", data)
 }
 
 // 获取node详情
@@ -50,24 +42,174 @@ func (n *node) GetNodeDetail(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.Node.GetNodeDetail(ctx.Request.Context(), params.NodeName)
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取Node详情成功", data)
+}
+
+// 设置node为不可调度
+func (n *node) CordonNode(ctx *gin.Context) {
+	n.cordon(ctx, true)
+}
+
+// 设置node为可调度
+func (n *node) UncordonNode(ctx *gin.Context) {
+	n.cordon(ctx, false)
+}
+
+func (n *node) cordon(ctx *gin.Context, unschedulable bool) {
+	params := new(struct {
+		NodeName string `json:"node_name"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	err := service.Node.CordonNode(ctx.Request.Context(), params.NodeName, unschedulable)
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	msg := "设置Node可调度成功"
+	if unschedulable {
+		msg = "设置Node不可调度成功"
+	}
+	response.Success(ctx, msg, nil)
+}
+
+// drain node，后台执行，返回操作ID，通过/operation/detail或/operation/stream查看进度
+func (n *node) DrainNode(ctx *gin.Context) {
+	params := &service.NodeDrain{GracePeriodSeconds: -1}
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.Node.GetNodeDetail(params.NodeName)
+	id, err := service.Node.DrainNode(ctx.Request.Context(), params)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "开始drain Node", gin.H{"operation_id": id})
+}
+
+// 修改node标签、注解的请求参数，添加和更新时使用Items，删除时使用Keys
+type nodeMetaParams struct {
+	NodeName string            `json:"node_name"`
+	Items    map[string]string `json:"items"`
+	Keys     []string          `json:"keys"`
+}
+
+// 添加node标签
+func (n *node) AddNodeLabels(ctx *gin.Context) {
+	n.patchMeta(ctx, "添加Node标签成功", func(p *nodeMetaParams) error {
+		return service.Node.AddNodeLabels(ctx.Request.Context(), p.NodeName, p.Items)
+	})
+}
+
+// 更新node标签
+func (n *node) UpdateNodeLabels(ctx *gin.Context) {
+	n.patchMeta(ctx, "更新Node标签成功", func(p *nodeMetaParams) error {
+		return service.Node.UpdateNodeLabels(ctx.Request.Context(), p.NodeName, p.Items)
+	})
+}
+
+// 删除node标签
+func (n *node) RemoveNodeLabels(ctx *gin.Context) {
+	n.patchMeta(ctx, "删除Node标签成功", func(p *nodeMetaParams) error {
+		return service.Node.RemoveNodeLabels(ctx.Request.Context(), p.NodeName, p.Keys)
+	})
+}
+
+// 添加node注解
+func (n *node) AddNodeAnnotations(ctx *gin.Context) {
+	n.patchMeta(ctx, "添加Node注解成功", func(p *nodeMetaParams) error {
+		return service.Node.AddNodeAnnotations(ctx.Request.Context(), p.NodeName, p.Items)
+	})
+}
+
+// 更新node注解
+func (n *node) UpdateNodeAnnotations(ctx *gin.Context) {
+	n.patchMeta(ctx, "更新Node注解成功", func(p *nodeMetaParams) error {
+		return service.Node.UpdateNodeAnnotations(ctx.Request.Context(), p.NodeName, p.Items)
+	})
+}
+
+// 删除node注解
+func (n *node) RemoveNodeAnnotations(ctx *gin.Context) {
+	n.patchMeta(ctx, "删除Node注解成功", func(p *nodeMetaParams) error {
+		return service.Node.RemoveNodeAnnotations(ctx.Request.Context(), p.NodeName, p.Keys)
+	})
+}
+
+func (n *node) patchMeta(ctx *gin.Context, msg string, patch func(p *nodeMetaParams) error) {
+	params := new(nodeMetaParams)
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := patch(params); err != nil {
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Node详情成功",
-		"data": data,
+	response.Success(ctx, msg, nil)
+}
+
+// 修改node污点的请求参数
+type nodeTaintParams struct {
+	NodeName string `json:"node_name"`
+	service.NodeTaint
+}
+
+// 添加node污点
+func (n *node) AddNodeTaint(ctx *gin.Context) {
+	n.patchTaint(ctx, "添加Node污点成功", func(p *nodeTaintParams) error {
+		return service.Node.AddNodeTaint(ctx.Request.Context(), p.NodeName, &p.NodeTaint)
+	})
+}
+
+// 更新node污点
+func (n *node) UpdateNodeTaint(ctx *gin.Context) {
+	n.patchTaint(ctx, "更新Node污点成功", func(p *nodeTaintParams) error {
+		return service.Node.UpdateNodeTaint(ctx.Request.Context(), p.NodeName, &p.NodeTaint)
+	})
+}
+
+// 删除node污点
+func (n *node) RemoveNodeTaint(ctx *gin.Context) {
+	n.patchTaint(ctx, "删除Node污点成功", func(p *nodeTaintParams) error {
+		return service.Node.RemoveNodeTaint(ctx.Request.Context(), p.NodeName, &p.NodeTaint)
 	})
 }
+
+func (n *node) patchTaint(ctx *gin.Context, msg string, patch func(p *nodeTaintParams) error) {
+	params := new(nodeTaintParams)
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := patch(params); err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, msg, nil)
+}
@@ -0,0 +1,88 @@
+package controller
+
+import (
+	"io"
+	"k8s-server/response"
+	"k8s-server/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonderivan/logger"
+)
+
+var Operation operation
+
+type operation struct{}
+
+// 获取长时间运行的操作列表
+func (o *operation) GetOperations(ctx *gin.Context) {
+	params := new(struct {
+		Type string `form:"type"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取操作列表成功", service.Operation.List(params.Type))
+}
+
+// 获取操作详情，用于轮询进度
+func (o *operation) GetOperationDetail(ctx *gin.Context) {
+	params := new(struct {
+		ID string `form:"id"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.Operation.Get(params.ID)
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取操作详情成功", data)
+}
+
+// 以Server-Sent Events的方式推送操作进度，每次进度变化推送一次完整状态，操作结束后关闭连接
+func (o *operation) StreamOperation(ctx *gin.Context) {
+	params := new(struct {
+		ID string `form:"id"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	changed, cancel, err := service.Operation.Watch(params.ID)
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+	defer cancel()
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-cache")
+	//先推送一次当前状态
+	first := true
+	ctx.Stream(func(w io.Writer) bool {
+		if !first {
+			select {
+			case <-changed:
+			case <-ctx.Request.Context().Done():
+				return false
+			}
+		}
+		first = false
+		data, err := service.Operation.Get(params.ID)
+		if err != nil {
+			return false
+		}
+		ctx.SSEvent("operation", data)
+		return data.FinishedAt == nil
+	})
+}
@@ -0,0 +1,23 @@
+package controller
+
+import (
+	"k8s-server/response"
+	"k8s-server/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+var Overview overview
+
+type overview struct{}
+
+// 获取集群概览，包括各namespace的资源数量、pod状态、node就绪情况、资源分配、Warning事件和不健康的工作负载
+func (o *overview) GetOverview(ctx *gin.Context) {
+	data, err := service.Overview.GetOverview(ctx.Request.Context())
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取集群概览成功", data)
+}
@@ -8,9 +8,9 @@
 package controller
 
 import (
+	"k8s-server/response"
 	"k8s-server/service"
 	"k8s-server/utils"
-	"net/http"
 
 	"github.com/pkg/errors"
 
@@ -30,34 +30,26 @@ func (p *pod) GetPods(ctx *gin.Context) {
 	params := new(struct {
 		FilterName string `form:"filter_name"`
 		Namespace  string `form:"namespace"`
+		SortBy     string `form:"sort_by"`
 		Page       int    `form:"page"`
 		Limit      int    `form:"limit"`
 	})
 	//绑定参数，给匿名结构体中的属性赋值，值是入参
 	//form格式使用ctx.Bind方法，json格式使用ctx.ShouldBindJSON方法
 	if err := ctx.Bind(params); err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("Bind请求参数失败")).Msg("err.Error()")
-		//ctx.JSON方法用于返回响应内容，入参是状态码和响应内容，响应内容放入gin.H的map中
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		utils.Log(ctx.Request.Context()).Error().Stack().Err(errors.New("Bind请求参数失败")).Msg("err.Error()")
+		//response包统一响应格式，BindError返回400和参数错误的错误码
+		response.BindError(ctx, err)
 		return
 	}
 	//service中的的方法通过 包名.结构体变量名.方法名 使用，serivce.Pod.GetPods()
-	data, err := service.Pod.GetPods(params.FilterName, params.Namespace, params.Limit, params.Page)
+	data, err := service.Pod.GetPods(ctx.Request.Context(), params.FilterName, params.Namespace, params.SortBy, params.Limit, params.Page)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Pod列表成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Pod列表成功", data)
 }
 
 // 获取pod详情
@@ -67,25 +59,16 @@ func (p *pod) GetPodDetail(ctx *gin.Context) {
 		Namespace string `form:"namespace"`
 	})
 	if err := ctx.Bind(params); err != nil {
-		utils.Logger.Error().Err(errors.New("Bind请求参数失败")).Stack().Msg("err.Error()")
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		utils.Log(ctx.Request.Context()).Error().Err(errors.New("Bind请求参数失败")).Stack().Msg("err.Error()")
+		response.BindError(ctx, err)
 		return
 	}
-	data, err := service.Pod.GetPodDetail(params.PodName, params.Namespace)
+	data, err := service.Pod.GetPodDetail(ctx.Request.Context(), params.PodName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Pod详情成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Pod详情成功", data)
 }
 
 // 删除pod
@@ -96,25 +79,16 @@ func (p *pod) DeletePod(ctx *gin.Context) {
 	})
 	//PUT请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
-		utils.Logger.Error().Err(errors.New("Bind请求参数失败")).Stack().Msg("err.Error()")
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		utils.Log(ctx.Request.Context()).Error().Err(errors.New("Bind请求参数失败")).Stack().Msg("err.Error()")
+		response.BindError(ctx, err)
 		return
 	}
-	err := service.Pod.DeletePod(params.PodName, params.Namespace)
+	err := service.Pod.DeletePod(ctx.Request.Context(), params.PodName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "删除Pod成功",
-		"data": nil,
-	})
+	response.Success(ctx, "删除Pod成功", nil)
 }
 
 // 更新pod
@@ -126,25 +100,16 @@ func (p *pod) UpdatePod(ctx *gin.Context) {
 	})
 	//PUT请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
-		utils.Logger.Error().Err(errors.New("Bind请求参数失败")).Stack().Msg("err.Error()")
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		utils.Log(ctx.Request.Context()).Error().Err(errors.New("Bind请求参数失败")).Stack().Msg("err.Error()")
+		response.BindError(ctx, err)
 		return
 	}
-	err := service.Pod.UpdatePod(params.PodName, params.Namespace, params.Content)
+	err := service.Pod.UpdatePod(ctx.Request.Context(), params.PodName, params.Namespace, params.Content)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "更新Pod成功",
-		"data": nil,
-	})
+	response.Success(ctx, "更新Pod成功", nil)
 }
 
 // 获取pod容器
@@ -155,25 +120,16 @@ func (p *pod) GetPodContainer(ctx *gin.Context) {
 	})
 	//GET请求，绑定参数方法改为ctx.Bind
 	if err := ctx.Bind(params); err != nil {
-		utils.Logger.Error().Err(errors.New("Bind请求参数失败")).Stack().Msg("err.Error()")
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		utils.Log(ctx.Request.Context()).Error().Err(errors.New("Bind请求参数失败")).Stack().Msg("err.Error()")
+		response.BindError(ctx, err)
 		return
 	}
-	data, err := service.Pod.GetPodContainer(params.PodName, params.Namespace)
+	data, err := service.Pod.GetPodContainer(ctx.Request.Context(), params.PodName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Pod容器成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Pod容器成功", data)
 }
 
 // 获取pod中容器日志
@@ -185,40 +141,35 @@ func (p *pod) GetPodLog(ctx *gin.Context) {
 	})
 	//GET请求，绑定参数方法改为ctx.Bind
 	if err := ctx.Bind(params); err != nil {
-		utils.Logger.Error().Err(errors.New("Bind请求参数失败")).Stack().Msg("err.Error()")
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		utils.Log(ctx.Request.Context()).Error().Err(errors.New("Bind请求参数失败")).Stack().Msg("err.Error()")
+		response.BindError(ctx, err)
 		return
 	}
-	data, err := service.Pod.GetPodLog(params.ContainerName, params.PodName, params.Namespace)
+	data, err := service.Pod.GetPodLog(ctx.Request.Context(), params.ContainerName, params.PodName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Pod中容器日志成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Pod中容器日志成功", data)
 }
 
 // 获取每个namespace的pod数量
 func (p *pod) GetPodNumPerNp(ctx *gin.Context) {
-	data, err := service.Pod.GetPodNumPerNp()
+	data, err := service.Pod.GetPodNumPerNp(ctx.Request.Context())
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取每个namespace的pod数量成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取每个namespace的pod数量成功", data)
+}
+
+// pod端口转发，websocket中的二进制消息与pod端口上的TCP数据双向转发
+func (p *pod) PortForward(ctx *gin.Context) {
+	service.PortForward.WsHandler(ctx.Writer, ctx.Request)
+}
+
+// 创建临时调试容器并打开终端，用于没有shell的pod
+func (p *pod) Debug(ctx *gin.Context) {
+	service.Debug.WsHandler(ctx.Writer, ctx.Request)
 }
@@ -8,20 +8,68 @@
 package controller
 
 import (
+	"k8s-server/middleware"
+
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // 注册路由
 func RegisterRouter(r *gin.Engine) {
 	r.GET("/testapi", TestApi)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 	r.POST("/api/login", Login.Auth)
 	rgroup := r.Group("/api/k8s")
+	//websocket、SSE、文件传输等长时间运行的路由使用middleware.NoTimeout，不受请求超时时间限制
 	rgroup.
 	//工作流
 	GET("/workflows", Workflow.GetList).
 	GET("/workflow/detail", Workflow.GetById).
 	POST("/workflow/create", Workflow.Create).
+	PUT("/workflow/update", Workflow.Update).
+	POST("/workflow/sync", Workflow.Sync).
+	GET("/workflow/revisions", Workflow.GetRevisions).
+	GET("/workflow/revision/detail", Workflow.GetRevisionDetail).
+	GET("/workflow/revision/diff", Workflow.DiffRevisions).
+	POST("/workflow/rollback", Workflow.Rollback).
+	POST("/workflow/release", Workflow.Release).
+	GET("/workflow/release/detail", Workflow.GetRelease).
+	GET("/workflow/releases", Workflow.GetReleases).
+	POST("/workflow/release/promote", Workflow.PromoteRelease).
+	POST("/workflow/release/abort", Workflow.AbortRelease).
+	POST("/workflow/promote", Workflow.Promote).
+	GET("/workflow/promotions", Workflow.GetPromotions).
+	GET("/workflow/promotion/detail", Workflow.GetPromotionDetail).
+	POST("/workflow/promotion/approve", Workflow.ApprovePromotion).
+	POST("/workflow/promotion/reject", Workflow.RejectPromotion).
+	GET("/workflow/import/discover", Workflow.Discover).
+	POST("/workflow/import", Workflow.Import).
 	DELETE("/workflow/del", Workflow.DelById).
+	//工作流模板
+	GET("/workflow/templates", WorkflowTemplate.GetList).
+	GET("/workflow/template/detail", WorkflowTemplate.GetDetail).
+	POST("/workflow/template/create", WorkflowTemplate.Create).
+	DELETE("/workflow/template/del", WorkflowTemplate.Delete).
+	POST("/workflow/template/instantiate", WorkflowTemplate.Instantiate).
+	//环境
+	GET("/environments", Environment.GetList).
+	POST("/environment/create", Environment.Create).
+	PUT("/environment/update", Environment.Update).
+	DELETE("/environment/del", Environment.Delete).
+	//变更审批，匹配审批策略的请求由middleware.ChangeApproval拦截
+	GET("/changes", Change.GetList).
+	GET("/change/detail", Change.GetDetail).
+	POST("/change/approve", Change.Approve).
+	POST("/change/reject", Change.Reject).
+	GET("/change/policies", Change.GetPolicies).
+	POST("/change/policy/create", Change.CreatePolicy).
+	PUT("/change/policy/update", Change.UpdatePolicy).
+	DELETE("/change/policy/del", Change.DeletePolicy).
+	//回收站，通过接口删除的资源在删除前保存快照
+	GET("/trash", Trash.GetList).
+	GET("/trash/detail", Trash.GetDetail).
+	POST("/trash/restore", Trash.Restore).
+	DELETE("/trash/del", Trash.Delete).
 	//pod操作
 	GET("/pods", Pod.GetPods).
 	GET("/pod/detail", Pod.GetPodDetail).
@@ -30,6 +78,16 @@ func RegisterRouter(r *gin.Engine) {
 	GET("/pod/container", Pod.GetPodContainer).
 	GET("/pod/log", Pod.GetPodLog).
 	GET("/pod/numnp", Pod.GetPodNumPerNp).
+	POST("/pod/exec", middleware.NoTimeout, Terminal.Exec).
+	GET("/pod/portforward", middleware.NoTimeout, Pod.PortForward).
+	GET("/pod/debug", middleware.NoTimeout, Pod.Debug).
+	POST("/pod/file/upload", middleware.NoTimeout, Terminal.UploadFile).
+	GET("/pod/file/download", middleware.NoTimeout, Terminal.DownloadFile).
+	//终端websocket
+	GET("/terminal/ws", middleware.NoTimeout, Terminal.WsHandler).
+	//终端录像
+	GET("/terminal/records", Terminal.GetRecords).
+	GET("/terminal/record/replay", Terminal.ReplayRecord).
 	//deployment操作
 	GET("/deployments", Deployment.GetDeployments).
 	GET("/deployment/detail", Deployment.GetDeploymentDetail).
@@ -79,6 +137,26 @@ func RegisterRouter(r *gin.Engine) {
 	//node操作
 	GET("/nodes", Node.GetNodes).
 	GET("/node/detail", Node.GetNodeDetail).
+	PUT("/node/cordon", Node.CordonNode).
+	PUT("/node/uncordon", Node.UncordonNode).
+	POST("/node/drain", Node.DrainNode).
+	POST("/node/label/add", Node.AddNodeLabels).
+	PUT("/node/label/update", Node.UpdateNodeLabels).
+	DELETE("/node/label/del", Node.RemoveNodeLabels).
+	POST("/node/annotation/add", Node.AddNodeAnnotations).
+	PUT("/node/annotation/update", Node.UpdateNodeAnnotations).
+	DELETE("/node/annotation/del", Node.RemoveNodeAnnotations).
+	POST("/node/taint/add", Node.AddNodeTaint).
+	PUT("/node/taint/update", Node.UpdateNodeTaint).
+	DELETE("/node/taint/del", Node.RemoveNodeTaint).
+	//长时间运行的操作
+	GET("/operations", Operation.GetOperations).
+	GET("/operation/detail", Operation.GetOperationDetail).
+	GET("/operation/stream", middleware.NoTimeout, Operation.StreamOperation).
+	//集群概览
+	GET("/overview", Overview.GetOverview).
+	//资源使用量历史
+	GET("/metrics/history", Metrics.GetHistory).
 	//namespace操作
 	GET("/namespaces", Namespace.GetNamespaces).
 	GET("/namespace/detail", Namespace.GetNamespaceDetail).
@@ -1,8 +1,8 @@
 package controller
 
 import (
+	"k8s-server/response"
 	"k8s-server/service"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wonderivan/logger"
@@ -22,26 +22,17 @@ func (s *servicev1) GetServices(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.Servicev1.GetServices(params.FilterName, params.Namespace, params.Limit, params.Page)
+	data, err := service.Servicev1.GetServices(ctx.Request.Context(), params.FilterName, params.Namespace, params.Limit, params.Page)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Servicet列表成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Servicet列表成功", data)
 }
 
 // 获取service详情
@@ -52,26 +43,17 @@ func (s *servicev1) GetServiceDetail(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.Servicev1.GetServicetDetail(params.ServiceName, params.Namespace)
+	data, err := service.Servicev1.GetServicetDetail(ctx.Request.Context(), params.ServiceName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Service详情成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Service详情成功", data)
 }
 
 // 创建service
@@ -83,24 +65,15 @@ func (s *servicev1) CreateService(ctx *gin.Context) {
 
 	if err = ctx.ShouldBindJSON(serviceCreate); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	if err = service.Servicev1.CreateService(serviceCreate); err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+	if err = service.Servicev1.CreateService(ctx.Request.Context(), serviceCreate); err != nil {
+		response.Error(ctx, err)
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "创建Service成功",
-		"data": nil,
-	})
+	response.Success(ctx, "创建Service成功", nil)
 }
 
 // 删除service
@@ -112,25 +85,16 @@ func (s *servicev1) DeleteService(ctx *gin.Context) {
 	//DELETE请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.Servicev1.DeleteService(params.ServiceName, params.Namespace)
+	err := service.Servicev1.DeleteService(ctx.Request.Context(), params.ServiceName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "删除Service成功",
-		"data": nil,
-	})
+	response.Success(ctx, "删除Service成功", nil)
 }
 
 // 更新service
@@ -142,23 +106,14 @@ func (s *servicev1) UpdateService(ctx *gin.Context) {
 	//PUT请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.Servicev1.UpdateService(params.Namespace, params.Content)
+	err := service.Servicev1.UpdateService(ctx.Request.Context(), params.Namespace, params.Content)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "更新Service成功",
-		"data": nil,
-	})
+	response.Success(ctx, "更新Service成功", nil)
 }
@@ -1,8 +1,8 @@
 package controller
 
 import (
+	"k8s-server/response"
 	"k8s-server/service"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wonderivan/logger"
@@ -22,26 +22,17 @@ func (s *statefulSet) GetStatefulSets(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.StatefulSet.GetStatefulSets(params.FilterName, params.Namespace, params.Limit, params.Page)
+	data, err := service.StatefulSet.GetStatefulSets(ctx.Request.Context(), params.FilterName, params.Namespace, params.Limit, params.Page)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取StatefulSet列表成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取StatefulSet列表成功", data)
 }
 
 // 获取statefulset详情
@@ -52,26 +43,17 @@ func (s *statefulSet) GetStatefulSetDetail(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.StatefulSet.GetStatefulSetDetail(params.StatefulSetName, params.Namespace)
+	data, err := service.StatefulSet.GetStatefulSetDetail(ctx.Request.Context(), params.StatefulSetName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取StatefulSet详情成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取StatefulSet详情成功", data)
 }
 
 // 删除statefulset
@@ -83,25 +65,16 @@ func (s *statefulSet) DeleteStatefulSet(ctx *gin.Context) {
 	//DELETE请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.StatefulSet.DeleteStatefulSet(params.StatefulSetName, params.Namespace)
+	err := service.StatefulSet.DeleteStatefulSet(ctx.Request.Context(), params.StatefulSetName, params.Namespace)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "删除StatefulSet成功",
-		"data": nil,
-	})
+	response.Success(ctx, "删除StatefulSet成功", nil)
 }
 
 // 更新statefulSet
@@ -113,23 +86,14 @@ func (s *statefulSet) UpdateStatefulSet(ctx *gin.Context) {
 	//PUT请求，绑定参数方法改为ctx.ShouldBindJSON
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	err := service.StatefulSet.UpdateStatefulSet(params.Namespace, params.Content)
+	err := service.StatefulSet.UpdateStatefulSet(ctx.Request.Context(), params.Namespace, params.Content)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "更新StatefulSet成功",
-		"data": nil,
-	})
+	response.Success(ctx, "更新StatefulSet成功", nil)
 }
@@ -0,0 +1,155 @@
+package controller
+
+import (
+	"k8s-server/errcode"
+	"k8s-server/response"
+	"k8s-server/service"
+	"mime"
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonderivan/logger"
+)
+
+var Terminal terminal
+
+type terminal struct{}
+
+// 终端websocket，鉴权、Origin校验、并发限制和空闲超时在service中处理
+func (t *terminal) WsHandler(ctx *gin.Context) {
+	service.Terminal.WsHandler(ctx.Writer, ctx.Request)
+}
+
+// 在容器中执行一次性命令，返回stdout、stderr和退出码
+func (t *terminal) Exec(ctx *gin.Context) {
+	params := new(struct {
+		PodName       string   `json:"pod_name"`
+		Namespace     string   `json:"namespace"`
+		ContainerName string   `json:"container_name"`
+		Command       []string `json:"command"`
+		Timeout       int      `json:"timeout"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.Terminal.Exec(ctx.Request.Context(), params.Namespace, params.PodName, params.ContainerName, params.Command, params.Timeout)
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "执行命令成功", data)
+}
+
+// 获取终端录像列表，支持按用户、namespace、pod过滤
+func (t *terminal) GetRecords(ctx *gin.Context) {
+	params := new(struct {
+		UserName  string `form:"username"`
+		Namespace string `form:"namespace"`
+		PodName   string `form:"pod_name"`
+		Page      int    `form:"page"`
+		Limit     int    `form:"limit"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.Recorder.GetList(ctx.Request.Context(), params.UserName, params.Namespace, params.PodName, params.Page, params.Limit)
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取终端录像列表成功", data)
+}
+
+// 回放终端录像，返回asciicast v2格式的录像文件，可直接交给asciinema-player播放
+func (t *terminal) ReplayRecord(ctx *gin.Context) {
+	params := new(struct {
+		ID int `form:"id"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	_, path, err := service.Recorder.GetFile(ctx.Request.Context(), params.ID)
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	//Cors中间件默认设置了json的Content-Type，这里覆盖掉
+	ctx.Header("Content-Type", "application/x-asciicast")
+	ctx.File(path)
+}
+
+// 上传文件到容器，multipart表单中的files字段可以包含多个文件，上传目录时paths字段按顺序传入每个文件的相对路径
+func (t *terminal) UploadFile(ctx *gin.Context) {
+	//限制请求体大小，额外预留1MB给表单的其他字段
+	if max := service.FileCopy.MaxUploadSize(); max > 0 {
+		ctx.Request.Body = http.MaxBytesReader(ctx.Writer, ctx.Request.Body, max+1<<20)
+	}
+	params := new(struct {
+		PodName       string `form:"pod_name"`
+		Namespace     string `form:"namespace"`
+		ContainerName string `form:"container_name"`
+		DestDir       string `form:"dest_dir"`
+	})
+	if err := ctx.ShouldBind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+	form, err := ctx.MultipartForm()
+	if err != nil {
+		logger.Error("解析上传文件失败, " + err.Error())
+		response.Error(ctx, errcode.InvalidParam.Wrapf(err, "解析上传文件失败"))
+		return
+	}
+
+	err = service.FileCopy.Upload(ctx.Request.Context(), params.Namespace, params.PodName, params.ContainerName, params.DestDir, form.File["files"], form.Value["paths"])
+	if err != nil {
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "上传文件成功", nil)
+}
+
+// 从容器中下载文件或目录，返回tar.gz压缩包
+func (t *terminal) DownloadFile(ctx *gin.Context) {
+	params := new(struct {
+		PodName       string `form:"pod_name"`
+		Namespace     string `form:"namespace"`
+		ContainerName string `form:"container_name"`
+		Path          string `form:"path"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	filename := path.Base(path.Clean("/"+params.Path)) + ".tar.gz"
+	ctx.Header("Content-Type", "application/gzip")
+	ctx.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	err := service.FileCopy.Download(ctx.Request.Context(), params.Namespace, params.PodName, params.ContainerName, params.Path, ctx.Writer)
+	if err != nil {
+		//已经开始写入文件内容时无法再返回json，只能中断连接
+		if ctx.Writer.Written() {
+			ctx.Abort()
+			return
+		}
+		ctx.Writer.Header().Del("Content-Disposition")
+		ctx.Header("Content-Type", "application/json")
+		response.Error(ctx, err)
+	}
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"k8s-server/response"
+	"k8s-server/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonderivan/logger"
+)
+
+var Trash trash
+
+type trash struct{}
+
+// 获取回收站列表
+func (t *trash) GetList(ctx *gin.Context) {
+	params := new(struct {
+		Kind      string `form:"kind"`
+		Namespace string `form:"namespace"`
+		Name      string `form:"name"`
+		Page      int    `form:"page"`
+		Limit     int    `form:"limit"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.Trash.GetList(ctx.Request.Context(), params.Kind, params.Namespace, params.Name, params.Page, params.Limit)
+	if err != nil {
+		logger.Error("获取回收站列表失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取回收站列表成功", data)
+}
+
+// 获取回收站记录详情，包含资源快照
+func (t *trash) GetDetail(ctx *gin.Context) {
+	params := new(struct {
+		ID int `form:"id"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.Trash.GetDetail(ctx.Request.Context(), params.ID)
+	if err != nil {
+		logger.Error("获取回收站记录详情失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取回收站记录详情成功", data)
+}
+
+// 从回收站恢复资源
+func (t *trash) Restore(ctx *gin.Context) {
+	params := new(service.TrashRestore)
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.Trash.Restore(ctx.Request.Context(), params)
+	if err != nil {
+		logger.Error("恢复资源失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "恢复资源成功", data)
+}
+
+// 彻底删除回收站记录
+func (t *trash) Delete(ctx *gin.Context) {
+	params := new(struct {
+		ID int `json:"id"`
+	})
+	//DELETE请求，绑定参数方法改为ctx.ShouldBindJSON
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := service.Trash.Delete(ctx.Request.Context(), params.ID); err != nil {
+		logger.Error("删除回收站记录失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "删除回收站记录成功", nil)
+}
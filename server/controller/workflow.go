@@ -1,8 +1,8 @@
 package controller
 
 import (
+	"k8s-server/response"
 	"k8s-server/service"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 	"github.com/wonderivan/logger"
@@ -21,27 +21,18 @@ func (w *workflow) GetList(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.Workflow.GetList(params.Name, params.Page, params.Limit)
+	data, err := service.Workflow.GetList(ctx.Request.Context(), params.Name, params.Page, params.Limit)
 	if err != nil {
 		logger.Error("获取Workflow列表失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "获取Workflow列表成功",
-		"data": data,
-	})
+	response.Success(ctx, "获取Workflow列表成功", data)
 }
 
 // 查询workflow单条数据
@@ -51,27 +42,18 @@ func (w *workflow) GetById(ctx *gin.Context) {
 	})
 	if err := ctx.Bind(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	data, err := service.Workflow.GetById(params.ID)
+	data, err := service.Workflow.GetById(ctx.Request.Context(), params.ID)
 	if err != nil {
 		logger.Error("查询Workflow单条数据失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "查询Workflow单条数据成功",
-		"data": data,
-	})
+	response.Success(ctx, "查询Workflow单条数据成功", data)
 }
 
 // 创建workflow
@@ -83,27 +65,310 @@ func (w *workflow) Create(ctx *gin.Context) {
 
 	if err = ctx.ShouldBindJSON(wc); err != nil {
 		logger.Error("Bind请求参数dc失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	if err = service.Workflow.CreateWorkflow(wc); err != nil {
+	if err = service.Workflow.CreateWorkflow(ctx.Request.Context(), wc); err != nil {
 		logger.Error("创建Workflow失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "创建Workflow成功", nil)
+
+}
+
+// 更新workflow，只修改传入的字段
+func (w *workflow) Update(ctx *gin.Context) {
+	wu := &service.WorkflowUpdate{}
+	if err := ctx.ShouldBindJSON(wu); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := service.Workflow.UpdateWorkflow(ctx.Request.Context(), wu); err != nil {
+		logger.Error("更新Workflow失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "更新Workflow成功", nil)
+}
+
+// 同步workflow，direction为db时以集群为准更新数据库，为cluster时以数据库为准修改集群
+func (w *workflow) Sync(ctx *gin.Context) {
+	params := new(struct {
+		ID        int    `json:"id"`
+		Direction string `json:"direction"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := service.Workflow.SyncWorkflow(ctx.Request.Context(), params.ID, params.Direction); err != nil {
+		logger.Error("同步Workflow失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "同步Workflow成功", nil)
+}
+
+// 开始金丝雀或蓝绿发布
+func (w *workflow) Release(ctx *gin.Context) {
+	params := new(service.WorkflowReleaseCreate)
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowReleaser.Create(ctx.Request.Context(), params)
+	if err != nil {
+		logger.Error("开始Workflow发布失败, " + err.Error())
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "创建Workflow成功",
-		"data": nil,
+	response.Success(ctx, "开始Workflow发布成功", data)
+}
+
+// 获取workflow最近一次发布及新版本的实时状态
+func (w *workflow) GetRelease(ctx *gin.Context) {
+	params := new(struct {
+		ID int `form:"id"`
 	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
 
+	data, err := service.WorkflowReleaser.GetDetail(ctx.Request.Context(), params.ID)
+	if err != nil {
+		logger.Error("获取Workflow发布失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取Workflow发布成功", data)
+}
+
+// 获取workflow的发布记录列表
+func (w *workflow) GetReleases(ctx *gin.Context) {
+	params := new(struct {
+		ID    int `form:"id"`
+		Page  int `form:"page"`
+		Limit int `form:"limit"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowReleaser.GetList(ctx.Request.Context(), params.ID, params.Page, params.Limit)
+	if err != nil {
+		logger.Error("获取Workflow发布记录列表失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取Workflow发布记录列表成功", data)
+}
+
+// 确认发布
+func (w *workflow) PromoteRelease(ctx *gin.Context) {
+	params := new(struct {
+		ID int `json:"id"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := service.WorkflowReleaser.Promote(ctx.Request.Context(), params.ID); err != nil {
+		logger.Error("确认Workflow发布失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "确认Workflow发布成功", nil)
+}
+
+// 放弃发布，流量切回当前版本
+func (w *workflow) AbortRelease(ctx *gin.Context) {
+	params := new(struct {
+		ID int `json:"id"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := service.WorkflowReleaser.Abort(ctx.Request.Context(), params.ID); err != nil {
+		logger.Error("放弃Workflow发布失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "放弃Workflow发布成功", nil)
+}
+
+// 将workflow当前的版本提升到下一个环境，目标环境需要审批时返回等待审批的提升记录
+func (w *workflow) Promote(ctx *gin.Context) {
+	params := new(struct {
+		ID int `json:"id"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowPromotion.Promote(ctx.Request.Context(), params.ID)
+	if err != nil {
+		logger.Error("提升Workflow失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "提升Workflow成功", data)
+}
+
+// 获取提升记录列表，id为空时查询所有workflow
+func (w *workflow) GetPromotions(ctx *gin.Context) {
+	params := new(struct {
+		ID     int    `form:"id"`
+		Status string `form:"status"`
+		Page   int    `form:"page"`
+		Limit  int    `form:"limit"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowPromotion.GetList(ctx.Request.Context(), params.ID, params.Status, params.Page, params.Limit)
+	if err != nil {
+		logger.Error("获取Workflow提升记录列表失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取Workflow提升记录列表成功", data)
+}
+
+// 获取提升记录详情
+func (w *workflow) GetPromotionDetail(ctx *gin.Context) {
+	params := new(struct {
+		ID int `form:"id"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowPromotion.GetDetail(ctx.Request.Context(), params.ID)
+	if err != nil {
+		logger.Error("获取Workflow提升记录失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取Workflow提升记录成功", data)
+}
+
+// 审批通过并执行提升
+func (w *workflow) ApprovePromotion(ctx *gin.Context) {
+	params := new(struct {
+		ID int `json:"id"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowPromotion.Approve(ctx.Request.Context(), params.ID)
+	if err != nil {
+		logger.Error("审批Workflow提升失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "审批Workflow提升成功", data)
+}
+
+// 拒绝提升
+func (w *workflow) RejectPromotion(ctx *gin.Context) {
+	params := new(struct {
+		ID     int    `json:"id"`
+		Reason string `json:"reason"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := service.WorkflowPromotion.Reject(ctx.Request.Context(), params.ID, params.Reason); err != nil {
+		logger.Error("拒绝Workflow提升失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "拒绝Workflow提升成功", nil)
+}
+
+// 发现未被workflow管理的已有deployment，namespace为空时查询所有命名空间
+func (w *workflow) Discover(ctx *gin.Context) {
+	params := new(struct {
+		Namespace string `form:"namespace"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.Workflow.DiscoverWorkflows(ctx.Request.Context(), params.Namespace)
+	if err != nil {
+		logger.Error("发现已有资源失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "发现已有资源成功", data)
+}
+
+// 将确认的已有deployment导入为workflow，返回每个deployment的导入结果
+func (w *workflow) Import(ctx *gin.Context) {
+	params := new(struct {
+		Items []*service.WorkflowImportItem `json:"items"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.Workflow.ImportWorkflows(ctx.Request.Context(), params.Items)
+	if err != nil {
+		logger.Error("导入Workflow失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "导入Workflow完成", data)
 }
 
 // 删除workflow
@@ -113,24 +378,104 @@ func (w *workflow) DelById(ctx *gin.Context) {
 	})
 	if err := ctx.ShouldBindJSON(params); err != nil {
 		logger.Error("Bind请求参数失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.BindError(ctx, err)
 		return
 	}
 
-	if err := service.Workflow.DelById(params.ID); err != nil {
+	if err := service.Workflow.DelById(ctx.Request.Context(), params.ID); err != nil {
 		logger.Error("删除Workflow失败, " + err.Error())
-		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"msg":  err.Error(),
-			"data": nil,
-		})
+		response.Error(ctx, err)
 		return
 	}
 
-	ctx.JSON(http.StatusOK, gin.H{
-		"msg":  "删除Workflow成功",
-		"data": nil,
+	response.Success(ctx, "删除Workflow成功", nil)
+}
+
+// 获取workflow的历史版本列表
+func (w *workflow) GetRevisions(ctx *gin.Context) {
+	params := new(struct {
+		ID    int `form:"id"`
+		Page  int `form:"page"`
+		Limit int `form:"limit"`
 	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowRevision.GetList(ctx.Request.Context(), params.ID, params.Page, params.Limit)
+	if err != nil {
+		logger.Error("获取Workflow历史版本列表失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取Workflow历史版本列表成功", data)
+}
+
+// 获取workflow的指定版本，包含参数和k8s资源
+func (w *workflow) GetRevisionDetail(ctx *gin.Context) {
+	params := new(struct {
+		ID       int `form:"id"`
+		Revision int `form:"revision"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowRevision.GetDetail(ctx.Request.Context(), params.ID, params.Revision)
+	if err != nil {
+		logger.Error("获取Workflow历史版本失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取Workflow历史版本成功", data)
+}
+
+// 对比workflow的两个版本，to为空时与集群中的当前状态对比
+func (w *workflow) DiffRevisions(ctx *gin.Context) {
+	params := new(struct {
+		ID   int `form:"id"`
+		From int `form:"from"`
+		To   int `form:"to"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowRevision.Diff(ctx.Request.Context(), params.ID, params.From, params.To)
+	if err != nil {
+		logger.Error("对比Workflow版本失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "对比Workflow版本成功", data)
+}
+
+// 回滚workflow到指定版本
+func (w *workflow) Rollback(ctx *gin.Context) {
+	params := new(struct {
+		ID       int `json:"id"`
+		Revision int `json:"revision"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := service.WorkflowRevision.Rollback(ctx.Request.Context(), params.ID, params.Revision); err != nil {
+		logger.Error("回滚Workflow失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "回滚Workflow成功", nil)
 }
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"k8s-server/response"
+	"k8s-server/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/wonderivan/logger"
+)
+
+var WorkflowTemplate workflowTemplate
+
+type workflowTemplate struct{}
+
+// 获取模板列表，同名模板的每个版本各为一条数据
+func (t *workflowTemplate) GetList(ctx *gin.Context) {
+	params := new(struct {
+		Name  string `form:"name"`
+		Page  int    `form:"page"`
+		Limit int    `form:"limit"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowTemplate.GetList(ctx.Request.Context(), params.Name, params.Page, params.Limit)
+	if err != nil {
+		logger.Error("获取Workflow模板列表失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取Workflow模板列表成功", data)
+}
+
+// 获取模板详情，version为空时返回最新版本
+func (t *workflowTemplate) GetDetail(ctx *gin.Context) {
+	params := new(struct {
+		Name    string `form:"name"`
+		Version int    `form:"version"`
+	})
+	if err := ctx.Bind(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowTemplate.GetDetail(ctx.Request.Context(), params.Name, params.Version)
+	if err != nil {
+		logger.Error("获取Workflow模板详情失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "获取Workflow模板详情成功", data)
+}
+
+// 保存模板，每次保存生成一个新版本
+func (t *workflowTemplate) Create(ctx *gin.Context) {
+	params := &service.WorkflowTemplateCreate{}
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowTemplate.Create(ctx.Request.Context(), params)
+	if err != nil {
+		logger.Error("保存Workflow模板失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "保存Workflow模板成功", data)
+}
+
+// 删除模板的指定版本
+func (t *workflowTemplate) Delete(ctx *gin.Context) {
+	params := new(struct {
+		Name    string `json:"name"`
+		Version int    `json:"version"`
+	})
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	if err := service.WorkflowTemplate.Delete(ctx.Request.Context(), params.Name, params.Version); err != nil {
+		logger.Error("删除Workflow模板失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	response.Success(ctx, "删除Workflow模板成功", nil)
+}
+
+// 通过模板创建workflow，dry_run为true时只返回渲染结果
+func (t *workflowTemplate) Instantiate(ctx *gin.Context) {
+	params := &service.WorkflowTemplateInstantiate{}
+	if err := ctx.ShouldBindJSON(params); err != nil {
+		logger.Error("Bind请求参数失败, " + err.Error())
+		response.BindError(ctx, err)
+		return
+	}
+
+	data, err := service.WorkflowTemplate.Instantiate(ctx.Request.Context(), params)
+	if err != nil {
+		logger.Error("通过模板创建Workflow失败, " + err.Error())
+		response.Error(ctx, err)
+		return
+	}
+
+	msg := "通过模板创建Workflow成功"
+	if params.DryRun {
+		msg = "渲染Workflow模板成功"
+	}
+	response.Success(ctx, msg, data)
+}
@@ -0,0 +1,75 @@
+package dao
+
+import (
+	"context"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"k8s-server/db"
+	"k8s-server/errcode"
+	"k8s-server/model"
+
+	"k8s-server/utils"
+)
+
+var ChangePolicy changePolicy
+
+type changePolicy struct{}
+
+// 获取所有审批策略，按id排列，多条策略匹配同一请求时使用id最小的策略
+func (c *changePolicy) GetList(ctx context.Context) (policies []*model.ChangePolicy, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Order("id").Find(&policies)
+	if tx.Error != nil && !tx.RecordNotFound() {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取审批策略列表失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取审批策略列表失败")
+	}
+	return policies, nil
+}
+
+// 查询单个审批策略，不存在时返回nil
+func (c *changePolicy) GetById(ctx context.Context, id int) (policy *model.ChangePolicy, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	policy = &model.ChangePolicy{}
+	tx := db.GORM.Where("id = ?", id).First(policy)
+	if tx.RecordNotFound() {
+		return nil, nil
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取审批策略失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取审批策略失败")
+	}
+	return policy, nil
+}
+
+// 新增或更新审批策略，名称重复时返回AlreadyExists
+func (c *changePolicy) Save(ctx context.Context, policy *model.ChangePolicy) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Save(policy)
+	if mysqlErr, ok := tx.Error.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+		return errcode.AlreadyExists.New("审批策略名称已存在: " + policy.Name)
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("保存审批策略失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "保存审批策略失败")
+	}
+	return nil
+}
+
+// 删除审批策略，不影响已经提交的变更申请
+func (c *changePolicy) Delete(ctx context.Context, id int) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Where("id = ?", id).Delete(&model.ChangePolicy{})
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除审批策略失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "删除审批策略失败")
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package dao
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"k8s-server/db"
+	"k8s-server/model"
+
+	"k8s-server/utils"
+)
+
+var ChangeRequest changeRequest
+
+type changeRequest struct{}
+
+// 定义列表的返回内容，列表中不包含请求内容和响应内容
+type ChangeRequestResp struct {
+	Items []*model.ChangeRequest `json:"items"`
+	Total int                    `json:"total"`
+}
+
+// 获取变更申请列表，按id倒序，status为空时查询所有状态
+func (c *changeRequest) GetList(ctx context.Context, status string, page, limit int) (data *ChangeRequestResp, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	startSet := (page - 1) * limit
+
+	var (
+		changeList []*model.ChangeRequest
+		total      int
+	)
+
+	tx := db.GORM.Model(&model.ChangeRequest{}).
+		Select("id, created_at, updated_at, policy_id, method, route, path, namespace, approver_role, status, message, response_status, requester, approver")
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	tx = tx.Count(&total).
+		Limit(limit).
+		Offset(startSet).
+		Order("id desc").
+		Find(&changeList)
+	if tx.Error != nil && tx.Error.Error() != "record not found" {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取变更申请列表失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取变更申请列表失败")
+	}
+
+	return &ChangeRequestResp{
+		Items: changeList,
+		Total: total,
+	}, nil
+}
+
+// 查询单条变更申请，不存在时返回nil
+func (c *changeRequest) GetById(ctx context.Context, id int) (change *model.ChangeRequest, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	change = &model.ChangeRequest{}
+	tx := db.GORM.Where("id = ?", id).First(change)
+	if tx.RecordNotFound() {
+		return nil, nil
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取变更申请失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取变更申请失败")
+	}
+	return change, nil
+}
+
+// 新增或更新变更申请
+func (c *changeRequest) Save(ctx context.Context, change *model.ChangeRequest) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Save(change)
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("保存变更申请失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "保存变更申请失败")
+	}
+	return nil
+}
+
+// 只在变更申请当前状态为oldStatus时更新状态和审批人，避免同一条申请被重复审批
+func (c *changeRequest) UpdateStatusIf(ctx context.Context, id uint, oldStatus, status, approver, message string) (updated bool, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Model(&model.ChangeRequest{}).Where("id = ? AND status = ?", id, oldStatus).
+		Updates(map[string]interface{}{"status": status, "approver": approver, "message": message})
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新变更申请失败, ")).Msg(tx.Error.Error())
+		return false, errors.Wrap(tx.Error, "更新变更申请失败")
+	}
+	return tx.RowsAffected > 0, nil
+}
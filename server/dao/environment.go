@@ -0,0 +1,75 @@
+package dao
+
+import (
+	"context"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"k8s-server/db"
+	"k8s-server/errcode"
+	"k8s-server/model"
+
+	"k8s-server/utils"
+)
+
+var Environment environment
+
+type environment struct{}
+
+// 获取所有环境，按提升顺序排列
+func (e *environment) GetList(ctx context.Context) (environments []*model.Environment, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Order("position, id").Find(&environments)
+	if tx.Error != nil && !tx.RecordNotFound() {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取环境列表失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取环境列表失败")
+	}
+	return environments, nil
+}
+
+// 查询单个环境，不存在时返回nil
+func (e *environment) GetById(ctx context.Context, id int) (env *model.Environment, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	env = &model.Environment{}
+	tx := db.GORM.Where("id = ?", id).First(env)
+	if tx.RecordNotFound() {
+		return nil, nil
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取环境失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取环境失败")
+	}
+	return env, nil
+}
+
+// 新增或更新环境，名称或命名空间重复时返回AlreadyExists
+func (e *environment) Save(ctx context.Context, env *model.Environment) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Save(env)
+	if mysqlErr, ok := tx.Error.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+		return errcode.AlreadyExists.Newf("环境名称或命名空间已存在: %s/%s", env.Name, env.Namespace)
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("保存环境失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "保存环境失败")
+	}
+	return nil
+}
+
+// 删除环境，不影响该命名空间中已有的workflow
+func (e *environment) Delete(ctx context.Context, id int) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Where("id = ?", id).Delete(&model.Environment{})
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除环境失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "删除环境失败")
+	}
+	return nil
+}
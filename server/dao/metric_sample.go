@@ -0,0 +1,110 @@
+package dao
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"k8s-server/db"
+	"k8s-server/model"
+	"strings"
+	"time"
+
+	"k8s-server/utils"
+)
+
+var MetricSample metricSample
+
+type metricSample struct{}
+
+// 批量写入时每条SQL包含的最大行数
+const metricSampleBatchSize = 500
+
+// 降采样后的一个数据点，CPU和Memory为时间窗口内的平均值，CPUMax和MemoryMax为最大值
+type MetricPoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	CPU       int64     `json:"cpu"`
+	Memory    int64     `json:"memory"`
+	CPUMax    int64     `json:"cpu_max"`
+	MemoryMax int64     `json:"memory_max"`
+}
+
+// 批量新增采样数据，gorm v1不支持批量插入，这里拼接多行的INSERT语句
+func (m *metricSample) BatchAdd(ctx context.Context, samples []*model.MetricSample) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	for start := 0; start < len(samples); start += metricSampleBatchSize {
+		end := start + metricSampleBatchSize
+		if end > len(samples) {
+			end = len(samples)
+		}
+		batch := samples[start:end]
+		placeholders := make([]string, len(batch))
+		args := make([]interface{}, 0, len(batch)*6)
+		for i, s := range batch {
+			placeholders[i] = "(?, ?, ?, ?, ?, ?)"
+			args = append(args, s.Kind, s.Namespace, s.Name, s.CPU, s.Memory, s.Timestamp)
+		}
+		sql := "INSERT INTO metric_sample (kind, namespace, name, cpu, memory, timestamp) VALUES " + strings.Join(placeholders, ", ")
+		tx := db.GORM.Exec(sql, args...)
+		if tx.Error != nil {
+			utils.Log(ctx).Error().Stack().Err(errors.New("添加资源使用量采样失败, ")).Msg(tx.Error.Error())
+			return errors.Wrap(tx.Error, "添加资源使用量采样失败")
+		}
+	}
+	return nil
+}
+
+// 查询一个对象在时间范围内的采样，按step秒为窗口在数据库中降采样
+func (m *metricSample) GetSeries(ctx context.Context, kind, namespace, name string, start, end time.Time, step int) (points []*MetricPoint, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	if step <= 0 {
+		step = 1
+	}
+	rows, err := db.GORM.Model(&model.MetricSample{}).
+		Select("CAST(FLOOR(UNIX_TIMESTAMP(timestamp) / ?) * ? AS SIGNED) AS bucket, AVG(cpu), AVG(memory), MAX(cpu), MAX(memory)", step, step).
+		Where("kind = ? AND namespace = ? AND name = ? AND timestamp >= ? AND timestamp < ?", kind, namespace, name, start, end).
+		Group("bucket").
+		Order("bucket").
+		Rows()
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("查询资源使用量采样失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "查询资源使用量采样失败")
+	}
+	defer rows.Close()
+
+	points = []*MetricPoint{}
+	for rows.Next() {
+		var (
+			bucket         int64
+			cpuAvg, memAvg float64
+			cpuMax, memMax int64
+		)
+		if err := rows.Scan(&bucket, &cpuAvg, &memAvg, &cpuMax, &memMax); err != nil {
+			utils.Log(ctx).Error().Stack().Err(errors.New("读取资源使用量采样失败, ")).Msg(err.Error())
+			return nil, errors.Wrap(err, "读取资源使用量采样失败")
+		}
+		points = append(points, &MetricPoint{
+			Timestamp: time.Unix(bucket, 0),
+			CPU:       int64(cpuAvg),
+			Memory:    int64(memAvg),
+			CPUMax:    cpuMax,
+			MemoryMax: memMax,
+		})
+	}
+	return points, nil
+}
+
+// 删除指定时间之前的采样，返回删除的行数
+func (m *metricSample) DeleteBefore(ctx context.Context, t time.Time) (deleted int64, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Where("timestamp < ?", t).Delete(&model.MetricSample{})
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("清理资源使用量采样失败, ")).Msg(tx.Error.Error())
+		return 0, errors.Wrap(tx.Error, "清理资源使用量采样失败")
+	}
+	return tx.RowsAffected, nil
+}
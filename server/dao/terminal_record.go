@@ -0,0 +1,98 @@
+package dao
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"k8s-server/db"
+	"k8s-server/model"
+
+	"k8s-server/utils"
+)
+
+var TerminalRecord terminalRecord
+
+type terminalRecord struct{}
+
+// 定义列表的返回内容，Items是录像元素列表，Total为录像元素数量
+type TerminalRecordResp struct {
+	Items []*model.TerminalRecord `json:"items"`
+	Total int                     `json:"total"`
+}
+
+// 获取录像列表分页查询，支持按用户、namespace、pod过滤
+func (t *terminalRecord) GetList(ctx context.Context, username, namespace, pod string, page, limit int) (data *TerminalRecordResp, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	startSet := (page - 1) * limit
+
+	var (
+		recordList []*model.TerminalRecord
+		total      int
+	)
+
+	tx := db.GORM.Model(&model.TerminalRecord{})
+	if username != "" {
+		tx = tx.Where("username = ?", username)
+	}
+	if namespace != "" {
+		tx = tx.Where("namespace = ?", namespace)
+	}
+	if pod != "" {
+		tx = tx.Where("pod like ?", "%"+pod+"%")
+	}
+	tx = tx.Count(&total).
+		Limit(limit).
+		Offset(startSet).
+		Order("id desc").
+		Find(&recordList)
+	if tx.Error != nil && tx.Error.Error() != "record not found" {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取终端录像列表失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取终端录像列表失败")
+	}
+
+	return &TerminalRecordResp{
+		Items: recordList,
+		Total: total,
+	}, nil
+}
+
+// 查询录像单条数据
+func (t *terminalRecord) GetById(ctx context.Context, id int) (record *model.TerminalRecord, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	record = &model.TerminalRecord{}
+	tx := db.GORM.Where("id = ?", id).First(&record)
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取终端录像单条数据失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取终端录像单条数据失败")
+	}
+	return record, nil
+}
+
+// 新增录像
+func (t *terminalRecord) Add(ctx context.Context, record *model.TerminalRecord) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Create(record)
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("添加终端录像失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "添加终端录像失败")
+	}
+	return nil
+}
+
+// 更新录像，会话结束时写入时长和文件大小
+func (t *terminalRecord) Update(ctx context.Context, record *model.TerminalRecord) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Save(record)
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新终端录像失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "更新终端录像失败")
+	}
+	return nil
+}
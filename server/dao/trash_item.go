@@ -0,0 +1,161 @@
+package dao
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"k8s-server/db"
+	"k8s-server/model"
+	"time"
+
+	"k8s-server/utils"
+)
+
+var TrashItem trashItem
+
+type trashItem struct{}
+
+// 定义列表的返回内容，列表中不包含资源快照
+type TrashItemResp struct {
+	Items []*model.TrashItem `json:"items"`
+	Total int                `json:"total"`
+}
+
+// 获取回收站列表，按删除时间倒序，只返回顶层记录(namespace中的资源随namespace一起查看)
+// kind、namespace为空时不过滤，name为模糊匹配
+func (t *trashItem) GetList(ctx context.Context, kind, namespace, name string, page, limit int) (data *TrashItemResp, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	startSet := (page - 1) * limit
+
+	var (
+		itemList []*model.TrashItem
+		total    int
+	)
+
+	tx := db.GORM.Model(&model.TrashItem{}).
+		Select("id, created_at, parent_id, api_version, kind, resource, namespace, name, operator, restored_name, restored_by, restored_at").
+		Where("parent_id = 0")
+	if kind != "" {
+		tx = tx.Where("kind = ?", kind)
+	}
+	if namespace != "" {
+		tx = tx.Where("namespace = ?", namespace)
+	}
+	if name != "" {
+		tx = tx.Where("name LIKE ?", "%"+name+"%")
+	}
+	tx = tx.Count(&total).
+		Limit(limit).
+		Offset(startSet).
+		Order("id desc").
+		Find(&itemList)
+	if tx.Error != nil && tx.Error.Error() != "record not found" {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取回收站列表失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取回收站列表失败")
+	}
+
+	return &TrashItemResp{
+		Items: itemList,
+		Total: total,
+	}, nil
+}
+
+// 查询单条回收站记录，不存在时返回nil
+func (t *trashItem) GetById(ctx context.Context, id int) (item *model.TrashItem, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	item = &model.TrashItem{}
+	tx := db.GORM.Where("id = ?", id).First(item)
+	if tx.RecordNotFound() {
+		return nil, nil
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取回收站记录失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取回收站记录失败")
+	}
+	return item, nil
+}
+
+// 获取namespace记录中的所有资源，包含资源快照
+func (t *trashItem) GetChildren(ctx context.Context, parentID uint) (items []*model.TrashItem, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Where("parent_id = ?", parentID).Order("id").Find(&items)
+	if tx.Error != nil && !tx.RecordNotFound() {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取回收站记录失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取回收站记录失败")
+	}
+	return items, nil
+}
+
+// 在一个事务中保存资源快照和其中的子资源，子资源的ParentID设置为item的id
+func (t *trashItem) Add(ctx context.Context, item *model.TrashItem, children []*model.TrashItem) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Begin()
+	if tx.Error != nil {
+		return errors.Wrap(tx.Error, "开启事务失败")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			utils.Log(ctx).Error().Stack().Err(errors.New("保存回收站记录失败, ")).Msg(err.Error())
+		}
+	}()
+	if err = tx.Create(item).Error; err != nil {
+		return errors.Wrap(err, "保存回收站记录失败")
+	}
+	for _, child := range children {
+		child.ParentID = item.ID
+		if err = tx.Create(child).Error; err != nil {
+			return errors.Wrap(err, "保存回收站记录失败")
+		}
+	}
+	if err = tx.Commit().Error; err != nil {
+		return errors.Wrap(err, "提交事务失败")
+	}
+	return nil
+}
+
+// 更新回收站记录
+func (t *trashItem) Save(ctx context.Context, item *model.TrashItem) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Save(item)
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新回收站记录失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "更新回收站记录失败")
+	}
+	return nil
+}
+
+// 彻底删除回收站记录及其子记录
+func (t *trashItem) Delete(ctx context.Context, id uint) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Where("id = ? OR parent_id = ?", id, id).Delete(&model.TrashItem{})
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除回收站记录失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "删除回收站记录失败")
+	}
+	return nil
+}
+
+// 清理删除时间早于t的记录，子记录与namespace记录在同一事务中保存，删除时间相同
+func (t *trashItem) DeleteBefore(ctx context.Context, before time.Time) (deleted int64, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Where("created_at < ?", before).Delete(&model.TrashItem{})
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("清理回收站失败, ")).Msg(tx.Error.Error())
+		return 0, errors.Wrap(tx.Error, "清理回收站失败")
+	}
+	return tx.RowsAffected, nil
+}
@@ -1,8 +1,11 @@
 package dao
 
 import (
-	"errors"
+	"context"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
 	"k8s-server/db"
+	"k8s-server/errcode"
 	"k8s-server/model"
 
 	"k8s-server/utils"
@@ -19,7 +22,10 @@ type WorkflowResp struct {
 }
 
 // 获取列表分页查询
-func (w *workflow) GetList(name string, page, limit int) (data *WorkflowResp, err error) {
+func (w *workflow) GetList(ctx context.Context, name string, page, limit int) (data *WorkflowResp, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
 	//定义分页数据的起始位置
 	startSet := (page - 1) * limit
 
@@ -41,8 +47,8 @@ func (w *workflow) GetList(name string, page, limit int) (data *WorkflowResp, er
 		Find(&workflowList)
 	//gorm会默认把空数据也放到err中，故这里要排除空数据的情况
 	if tx.Error != nil && tx.Error.Error() != "record not found" {
-		utils.Logger.Error().Stack().Err(errors.New("获取Workflow列表失败, ")).Msg(tx.Error.Error())
-		return nil, errors.New("获取Workflow列表失败, " + tx.Error.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow列表失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow列表失败")
 	}
 
 	return &WorkflowResp{
@@ -51,23 +57,144 @@ func (w *workflow) GetList(name string, page, limit int) (data *WorkflowResp, er
 	}, nil
 }
 
+// 获取所有未删除的workflow，用于后台巡检
+func (w *workflow) GetAll(ctx context.Context) (workflows []*model.Workflow, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Order("id").Find(&workflows)
+	if tx.Error != nil && !tx.RecordNotFound() {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow列表失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow列表失败")
+	}
+	return workflows, nil
+}
+
 // 查询workflow单条数据
-func (w *workflow) GetById(id int) (workflow *model.Workflow, err error) {
+func (w *workflow) GetById(ctx context.Context, id int) (workflow *model.Workflow, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
 	workflow = &model.Workflow{}
 	tx := db.GORM.Where("id = ?", id).First(&workflow)
 	if tx.Error != nil && tx.Error.Error() != "record not found" {
-		utils.Logger.Error().Stack().Err(errors.New("获取Workflow单条数据失败, ")).Msg(tx.Error.Error())
-		return nil, errors.New("获取Workflow单条数据失败, " + tx.Error.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow单条数据失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow单条数据失败")
 	}
 	return
 }
 
 // 新增workflow
-func (w *workflow) Add(workflow *model.Workflow) (err error) {
+func (w *workflow) Add(ctx context.Context, workflow *model.Workflow) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
 	tx := db.GORM.Create(&workflow)
+	//name上有唯一索引，并发创建同名workflow时只有一个能成功
+	if mysqlErr, ok := tx.Error.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+		return errcode.AlreadyExists.New("Workflow已存在: " + workflow.Name)
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("添加Workflow失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "添加Workflow失败")
+	}
+	return nil
+}
+
+// 按名字查询workflow，包含已软删除的数据，不存在时返回nil
+func (w *workflow) GetByName(ctx context.Context, name string) (workflow *model.Workflow, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	workflow = &model.Workflow{}
+	tx := db.GORM.Unscoped().Where("name = ?", name).First(workflow)
+	if tx.RecordNotFound() {
+		return nil, nil
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow单条数据失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow单条数据失败")
+	}
+	return
+}
+
+// 更新workflow的状态和状态信息
+func (w *workflow) UpdateStatus(ctx context.Context, id uint, status, message string) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Model(&model.Workflow{}).Unscoped().Where("id = ?", id).
+		Updates(map[string]interface{}{"status": status, "message": message})
 	if tx.Error != nil {
-		utils.Logger.Error().Stack().Err(errors.New("添加Workflow失败, ")).Msg(tx.Error.Error())
-		return errors.New("添加Workflow失败, " + tx.Error.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Workflow状态失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "更新Workflow状态失败")
+	}
+	return nil
+}
+
+// 只在workflow当前状态为oldStatus时更新状态，用于后台巡检，避免覆盖并发的创建、更新和删除设置的状态
+func (w *workflow) UpdateStatusIf(ctx context.Context, id uint, oldStatus, status, message string) (updated bool, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Model(&model.Workflow{}).Where("id = ? AND status = ?", id, oldStatus).
+		Updates(map[string]interface{}{"status": status, "message": message})
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Workflow状态失败, ")).Msg(tx.Error.Error())
+		return false, errors.Wrap(tx.Error, "更新Workflow状态失败")
+	}
+	return tx.RowsAffected > 0, nil
+}
+
+// 在一个事务中更新workflow并保存一条历史版本，版本号在已有的最大版本号上加1
+// 历史版本的参数和模板信息取自更新后的workflow
+func (w *workflow) SaveWithRevision(ctx context.Context, workflow *model.Workflow, revision *model.WorkflowRevision) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Begin()
+	if tx.Error != nil {
+		return errors.Wrap(tx.Error, "开启事务失败")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			utils.Log(ctx).Error().Stack().Err(errors.New("保存Workflow历史版本失败, ")).Msg(err.Error())
+		}
+	}()
+	if err = tx.Save(workflow).Error; err != nil {
+		return errors.Wrap(err, "更新Workflow失败")
+	}
+	//锁住该workflow已有的版本，避免并发修改得到相同的版本号
+	var latest int
+	row := tx.Raw("SELECT COALESCE(MAX(revision), 0) FROM workflow_revision WHERE workflow_id = ? FOR UPDATE", workflow.ID).Row()
+	if err = row.Scan(&latest); err != nil {
+		return errors.Wrap(err, "获取Workflow历史版本号失败")
+	}
+	revision.WorkflowID = workflow.ID
+	revision.WorkflowName = workflow.Name
+	revision.Spec = workflow.Spec
+	revision.Template = workflow.Template
+	revision.TemplateVersion = workflow.TemplateVersion
+	revision.Revision = latest + 1
+	if err = tx.Create(revision).Error; err != nil {
+		return errors.Wrap(err, "保存Workflow历史版本失败")
+	}
+	if err = tx.Commit().Error; err != nil {
+		return errors.Wrap(err, "提交事务失败")
+	}
+	return nil
+}
+
+// 硬删除workflow，用于清理创建失败或已软删除的同名数据，释放name上的唯一索引
+func (w *workflow) Purge(ctx context.Context, id uint) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Unscoped().Where("id = ?", id).Delete(&model.Workflow{})
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("清理Workflow失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "清理Workflow失败")
 	}
 	return nil
 }
@@ -78,11 +205,14 @@ func (w *workflow) Add(workflow *model.Workflow) (err error) {
 // 实际执行语句 UPDATE `workflow` SET `deleted_at` = '2021-03-01 08:32:11' WHERE `id` IN ('1'
 // 硬删除 db.GORM.Unscoped().Delete("id = ?", id)) 直接从表中删除这条数据
 // 实际执行语句 DELETE FROM `workflow` WHERE `id` IN ('1');
-func (w *workflow) DelById(id int) (err error) {
+func (w *workflow) DelById(ctx context.Context, id int) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
 	tx := db.GORM.Where("id = ?", id).Delete(&model.Workflow{})
 	if tx.Error != nil {
-		utils.Logger.Error().Stack().Err(errors.New("删除Workflow失败, ")).Msg(tx.Error.Error())
-		return errors.New("删除Workflow失败, " + tx.Error.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除Workflow失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "删除Workflow失败")
 	}
 	return nil
 }
@@ -0,0 +1,117 @@
+package dao
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"k8s-server/db"
+	"k8s-server/model"
+
+	"k8s-server/utils"
+)
+
+var WorkflowPromotion workflowPromotion
+
+type workflowPromotion struct{}
+
+// 定义列表的返回内容，列表中不包含参数
+type WorkflowPromotionResp struct {
+	Items []*model.WorkflowPromotion `json:"items"`
+	Total int                        `json:"total"`
+}
+
+// 获取提升记录列表，按id倒序，workflowID为0时查询所有workflow，status为空时查询所有状态
+func (w *workflowPromotion) GetList(ctx context.Context, workflowID uint, status string, page, limit int) (data *WorkflowPromotionResp, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	startSet := (page - 1) * limit
+
+	var (
+		promotionList []*model.WorkflowPromotion
+		total         int
+	)
+
+	tx := db.GORM.Model(&model.WorkflowPromotion{}).
+		Select("id, created_at, updated_at, workflow_id, workflow_name, revision, from_env, to_env, target_name, target_id, approver_role, status, message, operator, approver")
+	if workflowID != 0 {
+		tx = tx.Where("workflow_id = ?", workflowID)
+	}
+	if status != "" {
+		tx = tx.Where("status = ?", status)
+	}
+	tx = tx.Count(&total).
+		Limit(limit).
+		Offset(startSet).
+		Order("id desc").
+		Find(&promotionList)
+	if tx.Error != nil && tx.Error.Error() != "record not found" {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow提升记录列表失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow提升记录列表失败")
+	}
+
+	return &WorkflowPromotionResp{
+		Items: promotionList,
+		Total: total,
+	}, nil
+}
+
+// 查询单条提升记录，不存在时返回nil
+func (w *workflowPromotion) GetById(ctx context.Context, id int) (promotion *model.WorkflowPromotion, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	promotion = &model.WorkflowPromotion{}
+	tx := db.GORM.Where("id = ?", id).First(promotion)
+	if tx.RecordNotFound() {
+		return nil, nil
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow提升记录失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow提升记录失败")
+	}
+	return promotion, nil
+}
+
+// 查询目标workflow等待审批的提升记录，不存在时返回nil
+func (w *workflowPromotion) GetPending(ctx context.Context, targetName string) (promotion *model.WorkflowPromotion, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	promotion = &model.WorkflowPromotion{}
+	tx := db.GORM.Where("target_name = ? AND status = ?", targetName, model.PromotionPending).First(promotion)
+	if tx.RecordNotFound() {
+		return nil, nil
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow提升记录失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow提升记录失败")
+	}
+	return promotion, nil
+}
+
+// 新增或更新提升记录
+func (w *workflowPromotion) Save(ctx context.Context, promotion *model.WorkflowPromotion) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Save(promotion)
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("保存Workflow提升记录失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "保存Workflow提升记录失败")
+	}
+	return nil
+}
+
+// 只在提升记录当前状态为oldStatus时更新状态和审批人，避免同一条记录被重复审批
+func (w *workflowPromotion) UpdateStatusIf(ctx context.Context, id uint, oldStatus, status, approver, message string) (updated bool, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Model(&model.WorkflowPromotion{}).Where("id = ? AND status = ?", id, oldStatus).
+		Updates(map[string]interface{}{"status": status, "approver": approver, "message": message})
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Workflow提升记录失败, ")).Msg(tx.Error.Error())
+		return false, errors.Wrap(tx.Error, "更新Workflow提升记录失败")
+	}
+	return tx.RowsAffected > 0, nil
+}
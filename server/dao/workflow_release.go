@@ -0,0 +1,107 @@
+package dao
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"k8s-server/db"
+	"k8s-server/model"
+
+	"k8s-server/utils"
+)
+
+var WorkflowRelease workflowRelease
+
+type workflowRelease struct{}
+
+// 定义列表的返回内容
+type WorkflowReleaseResp struct {
+	Items []*model.WorkflowRelease `json:"items"`
+	Total int                      `json:"total"`
+}
+
+// 获取workflow的发布记录列表，按id倒序
+func (w *workflowRelease) GetList(ctx context.Context, workflowID uint, page, limit int) (data *WorkflowReleaseResp, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	startSet := (page - 1) * limit
+
+	var (
+		releaseList []*model.WorkflowRelease
+		total       int
+	)
+
+	tx := db.GORM.
+		Model(&model.WorkflowRelease{}).
+		Where("workflow_id = ?", workflowID).
+		Count(&total).
+		Limit(limit).
+		Offset(startSet).
+		Order("id desc").
+		Find(&releaseList)
+	if tx.Error != nil && tx.Error.Error() != "record not found" {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow发布记录列表失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow发布记录列表失败")
+	}
+
+	return &WorkflowReleaseResp{
+		Items: releaseList,
+		Total: total,
+	}, nil
+}
+
+// 查询workflow最近一次发布，不存在时返回nil
+func (w *workflowRelease) GetLatest(ctx context.Context, workflowID uint) (release *model.WorkflowRelease, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	release = &model.WorkflowRelease{}
+	tx := db.GORM.Where("workflow_id = ?", workflowID).Order("id desc").First(release)
+	if tx.RecordNotFound() {
+		return nil, nil
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow发布记录失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow发布记录失败")
+	}
+	return release, nil
+}
+
+// 获取所有处于指定状态的发布，用于后台推进发布
+func (w *workflowRelease) GetByStatus(ctx context.Context, status ...string) (releases []*model.WorkflowRelease, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Where("status in (?)", status).Order("id").Find(&releases)
+	if tx.Error != nil && !tx.RecordNotFound() {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow发布记录列表失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow发布记录列表失败")
+	}
+	return releases, nil
+}
+
+// 新增发布记录
+func (w *workflowRelease) Add(ctx context.Context, release *model.WorkflowRelease) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Create(release)
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("添加Workflow发布记录失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "添加Workflow发布记录失败")
+	}
+	return nil
+}
+
+// 保存发布记录的进度和状态
+func (w *workflowRelease) Save(ctx context.Context, release *model.WorkflowRelease) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Save(release)
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Workflow发布记录失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "更新Workflow发布记录失败")
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package dao
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"k8s-server/db"
+	"k8s-server/model"
+
+	"k8s-server/utils"
+)
+
+var WorkflowRevision workflowRevision
+
+type workflowRevision struct{}
+
+// 定义列表的返回内容，列表中不包含参数和manifest，需要时查询单条数据
+type WorkflowRevisionResp struct {
+	Items []*model.WorkflowRevision `json:"items"`
+	Total int                       `json:"total"`
+}
+
+// 获取workflow的历史版本列表，按版本号倒序
+func (w *workflowRevision) GetList(ctx context.Context, workflowID uint, page, limit int) (data *WorkflowRevisionResp, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	startSet := (page - 1) * limit
+
+	var (
+		revisionList []*model.WorkflowRevision
+		total        int
+	)
+
+	tx := db.GORM.
+		Model(&model.WorkflowRevision{}).
+		Select("id, created_at, workflow_id, workflow_name, revision, action, template, template_version, operator, message").
+		Where("workflow_id = ?", workflowID).
+		Count(&total).
+		Limit(limit).
+		Offset(startSet).
+		Order("revision desc").
+		Find(&revisionList)
+	if tx.Error != nil && tx.Error.Error() != "record not found" {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow历史版本列表失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow历史版本列表失败")
+	}
+
+	return &WorkflowRevisionResp{
+		Items: revisionList,
+		Total: total,
+	}, nil
+}
+
+// 查询workflow的指定版本，不存在时返回nil
+func (w *workflowRevision) Get(ctx context.Context, workflowID uint, revision int) (data *model.WorkflowRevision, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	data = &model.WorkflowRevision{}
+	tx := db.GORM.Where("workflow_id = ? AND revision = ?", workflowID, revision).First(data)
+	if tx.RecordNotFound() {
+		return nil, nil
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow历史版本失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow历史版本失败")
+	}
+	return data, nil
+}
@@ -0,0 +1,116 @@
+package dao
+
+import (
+	"context"
+	"github.com/go-sql-driver/mysql"
+	"github.com/pkg/errors"
+	"k8s-server/db"
+	"k8s-server/errcode"
+	"k8s-server/model"
+
+	"k8s-server/utils"
+)
+
+var WorkflowTemplate workflowTemplate
+
+type workflowTemplate struct{}
+
+// 定义列表的返回内容，Items是模板元素列表，Total为模板元素数量
+type WorkflowTemplateResp struct {
+	Items []*model.WorkflowTemplate `json:"items"`
+	Total int                       `json:"total"`
+}
+
+// 获取模板列表分页查询，同名模板的每个版本各为一条数据
+func (w *workflowTemplate) GetList(ctx context.Context, name string, page, limit int) (data *WorkflowTemplateResp, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	startSet := (page - 1) * limit
+
+	var (
+		templateList []*model.WorkflowTemplate
+		total        int
+	)
+
+	tx := db.GORM.
+		Model(&model.WorkflowTemplate{}).
+		Where("name like ?", "%"+name+"%").
+		Count(&total).
+		Limit(limit).
+		Offset(startSet).
+		Order("name, version desc").
+		Find(&templateList)
+	if tx.Error != nil && tx.Error.Error() != "record not found" {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow模板列表失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow模板列表失败")
+	}
+
+	return &WorkflowTemplateResp{
+		Items: templateList,
+		Total: total,
+	}, nil
+}
+
+// 查询模板的指定版本，version为0时返回最新版本，不存在时返回nil
+func (w *workflowTemplate) Get(ctx context.Context, name string, version int) (template *model.WorkflowTemplate, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	template = &model.WorkflowTemplate{}
+	tx := db.GORM.Where("name = ?", name)
+	if version > 0 {
+		tx = tx.Where("version = ?", version)
+	}
+	tx = tx.Order("version desc").First(template)
+	if tx.RecordNotFound() {
+		return nil, nil
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow模板失败, ")).Msg(tx.Error.Error())
+		return nil, errors.Wrap(tx.Error, "获取Workflow模板失败")
+	}
+	return template, nil
+}
+
+// 获取模板已使用的最大版本号，包含已删除的版本，避免删除后版本号被复用
+func (w *workflowTemplate) MaxVersion(ctx context.Context, name string) (version int, err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	row := db.GORM.Unscoped().Model(&model.WorkflowTemplate{}).Where("name = ?", name).Select("COALESCE(MAX(version), 0)").Row()
+	if err = row.Scan(&version); err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Workflow模板版本失败, ")).Msg(err.Error())
+		return 0, errors.Wrap(err, "获取Workflow模板版本失败")
+	}
+	return version, nil
+}
+
+// 新增模板版本，并发保存同一模板时只有一个能成功
+func (w *workflowTemplate) Add(ctx context.Context, template *model.WorkflowTemplate) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Create(template)
+	if mysqlErr, ok := tx.Error.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+		return errcode.Conflict.Newf("Workflow模板%s的版本%d已存在, 请重试", template.Name, template.Version)
+	}
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("添加Workflow模板失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "添加Workflow模板失败")
+	}
+	return nil
+}
+
+// 删除模板的指定版本(软删除)，通过该版本创建的workflow仍然保留记录
+func (w *workflowTemplate) Delete(ctx context.Context, name string, version int) (err error) {
+	if err = db.CheckContext(ctx); err != nil {
+		return
+	}
+	tx := db.GORM.Where("name = ? AND version = ?", name, version).Delete(&model.WorkflowTemplate{})
+	if tx.Error != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除Workflow模板失败, ")).Msg(tx.Error.Error())
+		return errors.Wrap(tx.Error, "删除Workflow模板失败")
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"k8s-server/config"
 	"time"
@@ -9,6 +11,8 @@ import (
 
 	"github.com/jinzhu/gorm"                  //gorm库
 	_ "github.com/jinzhu/gorm/dialects/mysql" //gorm对应的mysql驱动
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 )
 
 var (
@@ -50,6 +54,9 @@ func Init() {
 	// 设置了连接可复用的最大时间
 	GORM.DB().SetConnMaxLifetime(time.Duration(config.Config.GetInt("DB.MaxLifeTime")) * time.Second)
 
+	//连接池状态的prometheus指标
+	prometheus.MustRegister(collectors.NewDBStatsCollector(GORM.DB(), config.Config.GetString("DB.DbName")))
+
 	isInit = true
 	utils.Logger.Info().Msg("连接数据库成功!")
 }
@@ -58,3 +65,12 @@ func Init() {
 func Close() error {
 	return GORM.Close()
 }
+
+// CheckContext 在执行sql前检查请求是否已取消或超时
+// jinzhu/gorm不支持context，无法中断执行中的sql，只能避免在请求结束后继续访问数据库
+func CheckContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return errors.New("请求已取消或超时, " + err.Error())
+	}
+	return nil
+}
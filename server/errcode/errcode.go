@@ -0,0 +1,147 @@
+package errcode
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+	"github.com/pkg/errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// 错误码，Code是稳定的机器可读的错误码，Status是对应的http状态码
+// Msg和MsgEn是错误码的默认中英文说明
+type Code struct {
+	Status int
+	Code   string
+	Msg    string
+	MsgEn  string
+}
+
+var (
+	InvalidParam       = &Code{http.StatusBadRequest, "InvalidParam", "请求参数错误", "invalid request parameters"}
+	Unauthorized       = &Code{http.StatusUnauthorized, "Unauthorized", "未登录或登录已过期", "unauthorized"}
+	Forbidden          = &Code{http.StatusForbidden, "Forbidden", "没有权限", "forbidden"}
+	NotFound           = &Code{http.StatusNotFound, "NotFound", "资源不存在", "resource not found"}
+	AlreadyExists      = &Code{http.StatusConflict, "AlreadyExists", "资源已存在", "resource already exists"}
+	Conflict           = &Code{http.StatusConflict, "Conflict", "资源已被修改，请刷新后重试", "resource has been modified, please retry"}
+	Invalid            = &Code{http.StatusUnprocessableEntity, "Invalid", "资源校验失败", "resource validation failed"}
+	TooManyRequests    = &Code{http.StatusTooManyRequests, "TooManyRequests", "请求过于频繁", "too many requests"}
+	Timeout            = &Code{http.StatusGatewayTimeout, "Timeout", "请求超时", "request timed out"}
+	Canceled           = &Code{499, "Canceled", "请求已取消", "request canceled"}
+	ServiceUnavailable = &Code{http.StatusServiceUnavailable, "ServiceUnavailable", "服务暂不可用", "service unavailable"}
+	Internal           = &Code{http.StatusInternalServerError, "Internal", "服务内部错误", "internal server error"}
+)
+
+// Error 是带有错误码的错误，msg和msgEn为空时使用错误码的默认说明
+type Error struct {
+	*Code
+	msg   string
+	msgEn string
+	cause error
+}
+
+// New 创建一个带有错误码的错误，msg为中文说明，英文说明使用错误码的默认说明
+func (c *Code) New(msg string) *Error {
+	return &Error{Code: c, msg: msg}
+}
+
+// Newf 与New一致，msg支持格式化
+func (c *Code) Newf(format string, args ...interface{}) *Error {
+	return &Error{Code: c, msg: fmt.Sprintf(format, args...)}
+}
+
+// Wrap 为err加上错误码，保留原始错误
+func (c *Code) Wrap(err error) *Error {
+	return &Error{Code: c, cause: err}
+}
+
+// Wrapf 与Wrap一致，同时指定中文说明
+func (c *Code) Wrapf(err error, format string, args ...interface{}) *Error {
+	return &Error{Code: c, msg: fmt.Sprintf(format, args...), cause: err}
+}
+
+func (e *Error) Error() string {
+	msg := e.Message()
+	if e.cause != nil {
+		return msg + ", " + e.cause.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Message 返回中文说明，不包含原始错误
+func (e *Error) Message() string {
+	if e.msg != "" {
+		return e.msg
+	}
+	return e.Code.Msg
+}
+
+// MessageEn 返回英文说明，包含原始错误
+func (e *Error) MessageEn() string {
+	msg := e.msgEn
+	if msg == "" {
+		msg = e.Code.MsgEn
+	}
+	if e.cause != nil {
+		return msg + ": " + e.cause.Error()
+	}
+	return msg
+}
+
+// k8s apiserver返回的错误原因与错误码的对应关系
+var reasonCodes = map[metav1.StatusReason]*Code{
+	metav1.StatusReasonBadRequest:            InvalidParam,
+	metav1.StatusReasonUnauthorized:          Unauthorized,
+	metav1.StatusReasonForbidden:             Forbidden,
+	metav1.StatusReasonNotFound:              NotFound,
+	metav1.StatusReasonGone:                  NotFound,
+	metav1.StatusReasonAlreadyExists:         AlreadyExists,
+	metav1.StatusReasonConflict:              Conflict,
+	metav1.StatusReasonInvalid:               Invalid,
+	metav1.StatusReasonRequestEntityTooLarge: InvalidParam,
+	metav1.StatusReasonTooManyRequests:       TooManyRequests,
+	metav1.StatusReasonTimeout:               Timeout,
+	metav1.StatusReasonServerTimeout:         Timeout,
+	metav1.StatusReasonServiceUnavailable:    ServiceUnavailable,
+	metav1.StatusReasonMethodNotAllowed:      InvalidParam,
+}
+
+// From 将任意错误转换为带有错误码的错误，错误链中的信息按以下顺序识别：
+// errcode.Error、k8s apiserver返回的错误、context超时或取消、gorm的记录不存在，其他错误为Internal
+// 返回的Error的中文说明为err.Error()，即service中逐层包装的说明
+func From(err error) *Error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		if e == err {
+			return e
+		}
+		return &Error{Code: e.Code, msg: err.Error(), msgEn: e.MessageEn()}
+	}
+	if status, ok := err.(apierrors.APIStatus); ok || errors.As(err, &status) {
+		code, ok := reasonCodes[status.Status().Reason]
+		if !ok {
+			code = Internal
+		}
+		return &Error{Code: code, msg: err.Error(), msgEn: code.MsgEn + ": " + status.Status().Message}
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Code: Timeout, msg: err.Error()}
+	case errors.Is(err, context.Canceled):
+		return &Error{Code: Canceled, msg: err.Error()}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return &Error{Code: NotFound, msg: err.Error()}
+	}
+	return &Error{Code: Internal, msg: err.Error(), msgEn: Internal.MsgEn + ": " + err.Error()}
+}
@@ -14,7 +14,6 @@ import (
 	"k8s-server/middleware"
 	"k8s-server/service"
 	"k8s-server/utils"
-	"net/http"
 
 	"github.com/pkg/errors"
 
@@ -28,19 +27,26 @@ func main() {
 	utils.LogInit()
 	// 初始化K8s clientset
 	service.InitK8sClientSet()
+	// 启动informer缓存
+	service.Cache.Start()
 	// 初始化数据库
 	db.Init()
+	// 启动资源使用量采样
+	service.MetricsHistory.Start()
+	// 启动workflow巡检
+	service.WorkflowReconciler.Start()
+	// 启动workflow发布控制，推进金丝雀、蓝绿发布
+	service.WorkflowReleaser.Start()
+	// 启动回收站过期快照清理
+	service.Trash.Start()
 	// 创建gin实例
 	r := gin.New()
-	// 使用日志中间件
-	r.Use(middleware.GinLogger, middleware.Cors())
+	// 使用日志中间件，ChangeApproval拦截需要审批的请求
+	r.Use(middleware.RequestContext, middleware.GinLogger, middleware.Metrics, middleware.Cors(), middleware.ChangeApproval)
 	// 初始化路由
 	controller.RegisterRouter(r)
-	//终端websocket
-	go func() {
-		http.HandleFunc("/ws", service.Terminal.WsHandler)
-		http.ListenAndServe(":8082", nil)
-	}()
+	// 审批通过的变更申请通过gin实例重新执行
+	service.ChangeRequest.SetHandler(r)
 	// 运行程序
 	err := r.Run(config.Config.GetString("Server.listenAddr"))
 	if err != nil {
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"k8s-server/errcode"
+	"k8s-server/response"
+	"k8s-server/service"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/pkg/errors"
+)
+
+// ChangeApproval 拦截匹配变更审批策略的请求，保存为等待审批的变更申请，返回202和变更申请，不执行原始请求
+// GET等只读请求和审批通过后由服务端执行的请求不拦截
+// 命名空间取自query、表单或json请求体中的namespace和namespace_name(删除namespace等操作)
+func ChangeApproval(c *gin.Context) {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		c.Next()
+		return
+	}
+	ctx := c.Request.Context()
+	if service.IsApprovedChange(ctx) {
+		c.Next()
+		return
+	}
+	policies, err := service.ChangePolicy.Match(ctx, c.Request.Method, c.FullPath())
+	if err != nil {
+		response.Error(c, err)
+		c.Abort()
+		return
+	}
+	if len(policies) == 0 {
+		c.Next()
+		return
+	}
+
+	//读取请求体后放回，策略的命名空间不匹配时请求正常执行
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		response.Error(c, errcode.InvalidParam.Wrap(errors.Wrap(err, "读取请求体失败")))
+		c.Abort()
+		return
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	change, err := service.ChangeRequest.Submit(ctx, policies, &service.ChangeRequestSubmit{
+		Method:      c.Request.Method,
+		Route:       c.FullPath(),
+		Path:        c.Request.URL.Path,
+		RawQuery:    c.Request.URL.RawQuery,
+		ContentType: c.ContentType(),
+		Body:        string(body),
+		Namespaces:  requestNamespaces(c, body),
+	})
+	if err != nil {
+		response.Error(c, err)
+		c.Abort()
+		return
+	}
+	if change == nil {
+		c.Next()
+		return
+	}
+	response.Accepted(c, "操作需要审批, 已提交变更申请", change)
+	c.Abort()
+}
+
+// 请求中可能表示命名空间的参数名，比较时使用strings.EqualFold，与encoding/json绑定结构体字段的规则一致
+var namespaceParams = []string{"namespace", "namespace_name"}
+
+// 参数名对应的命名空间参数，不是命名空间参数时返回空字符串
+func namespaceParam(key string) string {
+	for _, param := range namespaceParams {
+		if strings.EqualFold(key, param) {
+			return param
+		}
+	}
+	return ""
+}
+
+// 获取请求中所有可能的命名空间，包括query、表单和json请求体中的参数
+// handler绑定参数的位置不同，只要其中一个匹配策略就需要审批，避免通过在另一个位置传入命名空间绕过审批
+// json请求体中有多个参数名对应同一个命名空间参数时，无法确定handler绑定的是哪一个，返回空列表按匹配处理
+func requestNamespaces(c *gin.Context, body []byte) []string {
+	var namespaces []string
+	add := func(key, value string) {
+		if namespaceParam(key) != "" && value != "" {
+			namespaces = append(namespaces, value)
+		}
+	}
+	for key, values := range c.Request.URL.Query() {
+		for _, value := range values {
+			add(key, value)
+		}
+	}
+	if form, err := url.ParseQuery(string(body)); err == nil && c.ContentType() == binding.MIMEPOSTForm {
+		for key, values := range form {
+			for _, value := range values {
+				add(key, value)
+			}
+		}
+	}
+	params := make(map[string]interface{})
+	_ = json.Unmarshal(body, &params)
+	seen := make(map[string]bool)
+	for key, value := range params {
+		param := namespaceParam(key)
+		if param == "" {
+			continue
+		}
+		if seen[param] {
+			return nil
+		}
+		seen[param] = true
+		if namespace, ok := value.(string); ok {
+			add(key, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+	return namespaces
+}
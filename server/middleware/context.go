@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"k8s-server/config"
+	"k8s-server/utils"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// 请求ID的header，客户端传入时沿用，否则生成一个新的ID
+const RequestIDHeader = "X-Request-ID"
+
+// gin.Context中保存不带超时的context的key，供NoTimeout使用
+const baseContextKey = "middleware.baseContext"
+
+// RequestContext 为每个请求生成请求ID，写入响应header，并在请求的context中放入带有request_id字段的logger和当前用户
+// 请求的context带有Server.requesttimeout的超时时间，客户端断开连接时context也会被取消
+// service和dao通过ctx.Request.Context()拿到这个context
+func RequestContext(c *gin.Context) {
+	requestID := c.GetHeader(RequestIDHeader)
+	if requestID == "" || len(requestID) > 64 {
+		requestID = uuid.NewString()
+	}
+	c.Header(RequestIDHeader, requestID)
+
+	//解析token得到当前用户，写入日志和context，供操作记录和审批使用
+	//路由没有注册JWTAuth，没有token或token无效时当前用户为空，需要用户身份的操作由service自行校验
+	//审批通过后由服务端执行的变更申请不带token，沿用context中的申请人
+	username := utils.UserFromContext(c.Request.Context())
+	if token := c.GetHeader("Authorization"); token != "" {
+		if claims, err := utils.JWTToken.ParseToken(token); err == nil {
+			username = claims.UserName
+		}
+	}
+	logContext := utils.Logger.With().Str("request_id", requestID)
+	if username != "" {
+		logContext = logContext.Str("user", username)
+	}
+	logger := logContext.Logger()
+	ctx := utils.WithUser(logger.WithContext(c.Request.Context()), username)
+	c.Set(baseContextKey, ctx)
+
+	timeout := config.Config.GetInt("Server.requesttimeout")
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+		defer cancel()
+	}
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// NoTimeout 去掉请求context的超时时间，用于websocket、SSE、文件传输等长时间运行的路由
+// 这些路由有各自的超时配置，客户端断开连接时context仍然会被取消
+func NoTimeout(c *gin.Context) {
+	if ctx, ok := c.Get(baseContextKey); ok {
+		c.Request = c.Request.WithContext(ctx.(context.Context))
+	}
+	c.Next()
+}
@@ -1,8 +1,9 @@
 package middleware
 
 import (
+	"k8s-server/errcode"
+	"k8s-server/response"
 	"k8s-server/utils"
-	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
@@ -17,10 +18,7 @@ func JWTAuth() gin.HandlerFunc {
 			//获取Header中的Authorization
 			token := c.Request.Header.Get("Authorization")
 			if token == "" {
-				c.JSON(http.StatusBadRequest, gin.H{
-					"msg":  "请求未携带token，无权限访问",
-					"data": nil,
-				})
+				response.Error(c, errcode.Unauthorized.New("请求未携带token，无权限访问"))
 				c.Abort()
 				return
 			}
@@ -30,18 +28,12 @@ func JWTAuth() gin.HandlerFunc {
 			if err != nil {
 				//token延期错误
 				if err.Error() == "TokenExpired" {
-					c.JSON(http.StatusBadRequest, gin.H{
-						"msg":  "授权已过期",
-						"data": nil,
-					})
+					response.Error(c, errcode.Unauthorized.New("授权已过期"))
 					c.Abort()
 					return
 				}
 				//其他解析错误
-				c.JSON(http.StatusBadRequest, gin.H{
-					"msg":  err.Error(),
-					"data": nil,
-				})
+				response.Error(c, errcode.Unauthorized.Wrap(err))
 				c.Abort()
 				return
 			}
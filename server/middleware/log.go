@@ -25,10 +25,10 @@ func GinLogger(c *gin.Context) {
 	// 视图函数执行完成，统计时间，记录日志
 	cost := time.Since(start)
 	if int(cost) >= 10000000000 {
-		utils.Logger.Error().
+		utils.Log(c.Request.Context()).Error().
 			Err(errors.New("请求响应超时")).
 			Stack().
-			Int("status", 500).
+			Int("status", c.Writer.Status()).
 			Str("method", c.Request.Method).
 			Str("path", path).
 			Str("query", query).
@@ -36,8 +36,8 @@ func GinLogger(c *gin.Context) {
 			Str("user-agent", c.Request.UserAgent()).
 			Msg("请求响应超时")
 	} else {
-		utils.Logger.Info().
-			Int("status", 200).
+		utils.Log(c.Request.Context()).Info().
+			Int("status", c.Writer.Status()).
 			Str("method", c.Request.Method).
 			Str("path", path).
 			Str("query", query).
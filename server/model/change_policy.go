@@ -0,0 +1,41 @@
+package model
+
+import "time"
+
+/*
+执行以下SQL创建表
+CREATE TABLE `change_policy` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `name` varchar(64) COLLATE utf8mb4_general_ci NOT NULL,
+  `method` varchar(16) COLLATE utf8mb4_general_ci NOT NULL,
+  `route` varchar(255) COLLATE utf8mb4_general_ci NOT NULL,
+  `namespaces` text COLLATE utf8mb4_general_ci,
+  `approver_role` varchar(64) COLLATE utf8mb4_general_ci NOT NULL,
+  `enabled` tinyint(1) NOT NULL DEFAULT '1',
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE KEY `idx_change_policy_name` (`name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+
+// 变更审批策略，匹配的请求不会立即执行，而是保存为等待审批的变更申请
+// Method为请求方法，*表示所有修改类的方法；Route为路由模板，例如/api/k8s/namespace/del，支持path.Match的通配符
+// Namespaces为json格式的命名空间通配符数组，例如["prod-*"]，为空时匹配所有请求(包括不带命名空间的请求，例如node drain)
+// 配置了命名空间时，请求中任一命名空间匹配即需要审批，请求中没有命名空间时同样需要审批
+type ChangePolicy struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+
+	Name         string `json:"name"`
+	Method       string `json:"method"`
+	Route        string `json:"route"`
+	Namespaces   string `json:"namespaces" gorm:"type:text"`
+	ApproverRole string `json:"approver_role"`
+	Enabled      bool   `json:"enabled"`
+}
+
+func (*ChangePolicy) TableName() string {
+	return "change_policy"
+}
@@ -0,0 +1,72 @@
+package model
+
+import "time"
+
+/*
+执行以下SQL创建表
+CREATE TABLE `change_request` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `policy_id` int NOT NULL,
+  `method` varchar(16) COLLATE utf8mb4_general_ci NOT NULL,
+  `route` varchar(255) COLLATE utf8mb4_general_ci NOT NULL,
+  `path` varchar(255) COLLATE utf8mb4_general_ci NOT NULL,
+  `raw_query` text COLLATE utf8mb4_general_ci,
+  `content_type` varchar(128) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `body` mediumtext COLLATE utf8mb4_general_ci,
+  `namespace` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `approver_role` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `status` varchar(16) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `message` text COLLATE utf8mb4_general_ci,
+  `response_status` int DEFAULT NULL,
+  `response` mediumtext COLLATE utf8mb4_general_ci,
+  `requester` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `approver` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`) USING BTREE,
+  KEY `idx_change_request_status` (`status`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+
+// 变更申请的状态
+// Pending表示等待审批，Approved表示已审批正在执行，执行成功为Succeeded，失败为Failed，审批拒绝为Rejected
+const (
+	ChangePending   = "Pending"
+	ChangeApproved  = "Approved"
+	ChangeSucceeded = "Succeeded"
+	ChangeFailed    = "Failed"
+	ChangeRejected  = "Rejected"
+)
+
+// 需要审批的变更申请，保存原始请求，审批通过后由服务端按原始请求执行
+type ChangeRequest struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+
+	//匹配的审批策略
+	PolicyID uint `json:"policy_id"`
+	//原始请求，不保存token等header
+	Method      string `json:"method"`
+	Route       string `json:"route"`
+	Path        string `json:"path"`
+	RawQuery    string `json:"raw_query" gorm:"type:text"`
+	ContentType string `json:"content_type"`
+	Body        string `json:"body" gorm:"type:mediumtext"`
+	//请求中所有可能的命名空间，多个时用逗号分隔
+	Namespace string `json:"namespace"`
+
+	ApproverRole string `json:"approver_role"`
+	Status       string `json:"status"`
+	Message      string `json:"message" gorm:"type:text"`
+	//执行结果，为原始请求的http状态码和响应内容
+	ResponseStatus int    `json:"response_status"`
+	Response       string `json:"response" gorm:"type:mediumtext"`
+	//申请人和审批人，请求未携带token时申请人为空
+	Requester string `json:"requester"`
+	Approver  string `json:"approver"`
+}
+
+func (*ChangeRequest) TableName() string {
+	return "change_request"
+}
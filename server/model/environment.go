@@ -0,0 +1,46 @@
+package model
+
+import "time"
+
+/*
+执行以下SQL创建表
+CREATE TABLE `environment` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `name` varchar(32) COLLATE utf8mb4_general_ci NOT NULL,
+  `namespace` varchar(32) COLLATE utf8mb4_general_ci NOT NULL,
+  `position` int NOT NULL,
+  `replicas` int DEFAULT NULL,
+  `cpu` varchar(16) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `memory` varchar(16) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `hosts` text COLLATE utf8mb4_general_ci,
+  `approver_role` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE KEY `idx_environment_name` (`name`),
+  UNIQUE KEY `idx_environment_namespace` (`namespace`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+
+// 环境，每个环境对应一个命名空间，按Position从小到大排列，workflow按顺序逐个环境提升
+// Replicas、Cpu、Memory、Hosts为提升到该环境时覆盖的参数，为空时沿用上一个环境的参数
+// ApproverRole不为空时，提升到该环境需要拥有该角色的用户审批
+type Environment struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Position  int    `json:"position"`
+	Replicas  *int32 `json:"replicas"`
+	Cpu       string `json:"cpu"`
+	Memory    string `json:"memory"`
+	//ingress的域名，json格式的数组，{name}替换为workflow名
+	Hosts        string `json:"hosts" gorm:"type:text"`
+	ApproverRole string `json:"approver_role"`
+}
+
+func (*Environment) TableName() string {
+	return "environment"
+}
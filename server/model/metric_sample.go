@@ -0,0 +1,43 @@
+package model
+
+import "time"
+
+/*
+执行以下SQL创建表
+CREATE TABLE `metric_sample` (
+  `id` bigint NOT NULL AUTO_INCREMENT,
+  `kind` varchar(16) COLLATE utf8mb4_general_ci NOT NULL,
+  `namespace` varchar(64) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `name` varchar(255) COLLATE utf8mb4_general_ci NOT NULL DEFAULT '',
+  `cpu` bigint NOT NULL DEFAULT '0',
+  `memory` bigint NOT NULL DEFAULT '0',
+  `timestamp` datetime NOT NULL,
+  PRIMARY KEY (`id`) USING BTREE,
+  KEY `idx_series` (`kind`,`namespace`,`name`,`timestamp`),
+  KEY `idx_timestamp` (`timestamp`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+
+// 资源使用量的采样类型
+const (
+	MetricKindPod       = "pod"
+	MetricKindNode      = "node"
+	MetricKindNamespace = "namespace"
+)
+
+// 资源使用量的一次采样，cpu单位为毫核，memory单位为字节
+// node的Namespace为空，namespace类型的Name为空
+// 采样数据按保留时间直接删除，不使用软删除
+type MetricSample struct {
+	ID        uint64    `json:"id" gorm:"primaryKey"`
+	Kind      string    `json:"kind"`
+	Namespace string    `json:"namespace"`
+	Name      string    `json:"name"`
+	CPU       int64     `json:"cpu" gorm:"column:cpu"`
+	Memory    int64     `json:"memory"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (*MetricSample) TableName() string {
+	return "metric_sample"
+}
@@ -0,0 +1,52 @@
+package model
+
+import "time"
+
+/*
+执行以下SQL创建表
+CREATE TABLE `terminal_record` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `username` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `namespace` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `pod` varchar(255) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `container` varchar(255) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `shell` varchar(32) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `file_path` varchar(512) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `size` bigint DEFAULT NULL,
+  `duration` double DEFAULT NULL,
+  `started_at` datetime DEFAULT NULL,
+  `ended_at` datetime DEFAULT NULL,
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL,
+  `deleted_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`) USING BTREE,
+  KEY `idx_pod` (`namespace`,`pod`),
+  KEY `idx_username` (`username`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+
+// 终端会话录像的索引，录像内容以asciicast v2格式保存在文件中
+type TerminalRecord struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+
+	UserName  string `json:"username" gorm:"column:username"`
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Shell     string `json:"shell"`
+	//录像文件相对于录像目录的路径
+	FilePath string `json:"file_path"`
+	//录像文件大小，单位字节
+	Size int64 `json:"size"`
+	//会话时长，单位秒
+	Duration  float64    `json:"duration"`
+	StartedAt *time.Time `json:"started_at"`
+	EndedAt   *time.Time `json:"ended_at"`
+}
+
+func (*TerminalRecord) TableName() string {
+	return "terminal_record"
+}
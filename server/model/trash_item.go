@@ -0,0 +1,52 @@
+package model
+
+import "time"
+
+/*
+执行以下SQL创建表
+CREATE TABLE `trash_item` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `parent_id` int NOT NULL DEFAULT '0',
+  `api_version` varchar(128) COLLATE utf8mb4_general_ci NOT NULL,
+  `kind` varchar(64) COLLATE utf8mb4_general_ci NOT NULL,
+  `resource` varchar(64) COLLATE utf8mb4_general_ci NOT NULL,
+  `namespace` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `name` varchar(255) COLLATE utf8mb4_general_ci NOT NULL,
+  `manifest` mediumtext COLLATE utf8mb4_general_ci,
+  `operator` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `restored_name` varchar(255) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `restored_by` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `restored_at` datetime DEFAULT NULL,
+  `created_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`) USING BTREE,
+  KEY `idx_trash_item_parent` (`parent_id`),
+  KEY `idx_trash_item_created` (`created_at`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+
+// 回收站中的资源，删除前保存的资源快照，去掉了uid、resourceVersion、status等由集群生成的字段
+// 删除namespace时，namespace本身为一条记录，其中的每个资源为ParentID指向它的子记录
+// CreatedAt为删除时间，超过保留时间后被清理
+type TrashItem struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt *time.Time `json:"created_at"`
+
+	ParentID   uint   `json:"parent_id"`
+	APIVersion string `json:"api_version"`
+	Kind       string `json:"kind"`
+	//资源的复数名称，例如deployments，恢复时与APIVersion一起确定接口
+	Resource  string `json:"resource"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	//json格式的资源快照，secret的内容同样保存在这里
+	Manifest string `json:"manifest" gorm:"type:mediumtext"`
+	Operator string `json:"operator"`
+	//最近一次恢复的名称、操作人和时间，未恢复时为空，同一快照可以多次恢复
+	RestoredName string     `json:"restored_name"`
+	RestoredBy   string     `json:"restored_by"`
+	RestoredAt   *time.Time `json:"restored_at"`
+}
+
+func (*TrashItem) TableName() string {
+	return "trash_item"
+}
@@ -13,14 +13,46 @@ CREATE TABLE `workflow` (
   `service` varchar(32) COLLATE utf8mb4_general_ci DEFAULT NULL,
   `ingress` varchar(32) COLLATE utf8mb4_general_ci DEFAULT NULL,
   `type` varchar(32) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `image` varchar(255) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `spec` mediumtext COLLATE utf8mb4_general_ci,
+  `template` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `template_version` int DEFAULT NULL,
+  `status` varchar(16) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `message` text COLLATE utf8mb4_general_ci,
   `created_at` datetime DEFAULT NULL,
   `updated_at` datetime DEFAULT NULL,
   `deleted_at` datetime DEFAULT NULL,
   PRIMARY KEY (`id`) USING BTREE,
   UNIQUE KEY `name` (`name`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+已有的表执行以下SQL添加字段
+ALTER TABLE `workflow`
+  ADD COLUMN `image` varchar(255) COLLATE utf8mb4_general_ci DEFAULT NULL AFTER `type`,
+  ADD COLUMN `spec` mediumtext COLLATE utf8mb4_general_ci AFTER `image`,
+  ADD COLUMN `template` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL AFTER `spec`,
+  ADD COLUMN `template_version` int DEFAULT NULL AFTER `template`,
+  ADD COLUMN `status` varchar(16) COLLATE utf8mb4_general_ci DEFAULT NULL AFTER `template_version`,
+  ADD COLUMN `message` text COLLATE utf8mb4_general_ci AFTER `status`;
+
+已按text类型创建spec字段的表执行以下SQL，附加资源较多时text的64KB不够用
+ALTER TABLE `workflow` MODIFY COLUMN `spec` mediumtext COLLATE utf8mb4_general_ci;
 */
 
+// workflow的状态
+// Creating、Deleting表示正在创建或删除k8s资源，Failed表示创建或删除失败，失败原因记录在Message中
+// Degraded、Missing由后台巡检设置，分别表示资源与记录不一致(或未就绪)和资源缺失
+// Releasing表示正在进行金丝雀或蓝绿发布，发布结束前不能修改或删除
+const (
+	WorkflowCreating  = "Creating"
+	WorkflowRunning   = "Running"
+	WorkflowFailed    = "Failed"
+	WorkflowDeleting  = "Deleting"
+	WorkflowDegraded  = "Degraded"
+	WorkflowMissing   = "Missing"
+	WorkflowReleasing = "Releasing"
+)
+
 // 定义结构体，属性与mysql表字段对齐
 type Workflow struct {
 	//gorm:"primaryKey"用于声明主键
@@ -36,7 +68,15 @@ type Workflow struct {
 	Service    string `json:"service"`
 	Ingress    string `json:"ingress"`
 	//gorm:"column:type"用于声明mysql中表的字段名
-	Type string `json:"type" gorm:"column:type"`
+	Type  string `json:"type" gorm:"column:type"`
+	Image string `json:"image"`
+	//最近一次创建或更新时使用的完整参数，json格式
+	Spec string `json:"spec" gorm:"type:mediumtext"`
+	//通过模板创建时记录模板名和版本
+	Template        string `json:"template"`
+	TemplateVersion int    `json:"template_version"`
+	Status          string `json:"status"`
+	Message         string `json:"message"`
 }
 
 // 定义TableName方法，返回mysql表名，以此来定义mysql中的表名
@@ -0,0 +1,69 @@
+package model
+
+import "time"
+
+/*
+执行以下SQL创建表
+CREATE TABLE `workflow_promotion` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `workflow_id` int NOT NULL,
+  `workflow_name` varchar(32) COLLATE utf8mb4_general_ci NOT NULL,
+  `revision` int NOT NULL,
+  `from_env` varchar(32) COLLATE utf8mb4_general_ci NOT NULL,
+  `to_env` varchar(32) COLLATE utf8mb4_general_ci NOT NULL,
+  `target_name` varchar(32) COLLATE utf8mb4_general_ci NOT NULL,
+  `target_id` int DEFAULT NULL,
+  `spec` mediumtext COLLATE utf8mb4_general_ci,
+  `approver_role` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `status` varchar(16) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `message` text COLLATE utf8mb4_general_ci,
+  `operator` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `approver` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`) USING BTREE,
+  KEY `idx_workflow_promotion` (`workflow_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+已按text类型创建spec字段的表执行以下SQL，附加资源较多时text的64KB不够用
+ALTER TABLE `workflow_promotion` MODIFY COLUMN `spec` mediumtext COLLATE utf8mb4_general_ci;
+*/
+
+// 提升的状态
+// Pending表示等待审批，Approved表示已审批正在执行，执行成功为Succeeded，失败为Failed，审批拒绝为Rejected
+const (
+	PromotionPending   = "Pending"
+	PromotionApproved  = "Approved"
+	PromotionSucceeded = "Succeeded"
+	PromotionFailed    = "Failed"
+	PromotionRejected  = "Rejected"
+)
+
+// workflow从一个环境提升到下一个环境的记录
+type WorkflowPromotion struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+
+	//源workflow和提升的版本号
+	WorkflowID   uint   `json:"workflow_id"`
+	WorkflowName string `json:"workflow_name"`
+	Revision     int    `json:"revision"`
+	FromEnv      string `json:"from_env"`
+	ToEnv        string `json:"to_env"`
+	//目标环境中的workflow，首次提升时执行后才有id
+	TargetName string `json:"target_name"`
+	TargetID   uint   `json:"target_id"`
+	//应用覆盖参数后的完整参数，json格式，审批通过后按此参数执行
+	Spec         string `json:"spec" gorm:"type:mediumtext"`
+	ApproverRole string `json:"approver_role"`
+	Status       string `json:"status"`
+	Message      string `json:"message" gorm:"type:text"`
+	//发起人和审批人，请求未携带token时发起人为空
+	Operator string `json:"operator"`
+	Approver string `json:"approver"`
+}
+
+func (*WorkflowPromotion) TableName() string {
+	return "workflow_promotion"
+}
@@ -0,0 +1,94 @@
+package model
+
+import "time"
+
+/*
+执行以下SQL创建表
+CREATE TABLE `workflow_release` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `workflow_id` int NOT NULL,
+  `workflow_name` varchar(32) COLLATE utf8mb4_general_ci NOT NULL,
+  `strategy` varchar(16) COLLATE utf8mb4_general_ci NOT NULL,
+  `image` varchar(255) COLLATE utf8mb4_general_ci NOT NULL,
+  `replicas` int DEFAULT NULL,
+  `stable_deployment` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `stable_hash` varchar(32) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `service_selector` text COLLATE utf8mb4_general_ci,
+  `deployment` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `service` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `ingress` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `steps` varchar(255) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `step` int DEFAULT NULL,
+  `weight` int DEFAULT NULL,
+  `step_interval` int DEFAULT NULL,
+  `ready_timeout` int DEFAULT NULL,
+  `max_restarts` int DEFAULT NULL,
+  `status` varchar(16) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `message` text COLLATE utf8mb4_general_ci,
+  `operator` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `step_started_at` datetime DEFAULT NULL,
+  `unready_since` datetime DEFAULT NULL,
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`) USING BTREE,
+  KEY `idx_workflow_release` (`workflow_id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+
+// 发布策略
+const (
+	ReleaseCanary    = "canary"
+	ReleaseBlueGreen = "bluegreen"
+)
+
+// 发布的状态
+// Progressing表示正在等待新版本就绪或逐步调整流量，Paused表示流量已经全部调整完，等待确认(promote)或放弃(abort)
+// Promoting表示正在确认，失败时可以重试，RolledBack表示超过就绪或重启阈值后自动回退
+const (
+	ReleaseProgressing = "Progressing"
+	ReleasePaused      = "Paused"
+	ReleasePromoting   = "Promoting"
+	ReleasePromoted    = "Promoted"
+	ReleaseAborted     = "Aborted"
+	ReleaseRolledBack  = "RolledBack"
+)
+
+// workflow的一次金丝雀或蓝绿发布，新版本以第二个deployment的形式运行
+type WorkflowRelease struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+
+	WorkflowID   uint   `json:"workflow_id"`
+	WorkflowName string `json:"workflow_name"`
+	Strategy     string `json:"strategy"`
+	Image        string `json:"image"`
+	Replicas     int32  `json:"replicas"`
+	//发布前的deployment和其当前ReplicaSet的pod-template-hash，发布期间service只选择这些pod
+	StableDeployment string `json:"stable_deployment"`
+	StableHash       string `json:"stable_hash"`
+	//发布前service的selector，json格式，结束发布时恢复
+	ServiceSelector string `json:"service_selector" gorm:"type:text"`
+	//新版本的deployment，金丝雀发布还有对应的service和带canary注解的ingress
+	Deployment string `json:"deployment"`
+	Service    string `json:"service"`
+	Ingress    string `json:"ingress"`
+	//金丝雀发布的流量比例，json格式的数组，Step为当前步骤的下标，-1表示还没有开始切流量
+	Steps  string `json:"steps"`
+	Step   int    `json:"step"`
+	Weight int    `json:"weight"`
+	//每一步的持续时间、新版本未就绪的最长时间，单位秒，以及新版本pod重启次数的上限
+	StepInterval int    `json:"step_interval"`
+	ReadyTimeout int    `json:"ready_timeout"`
+	MaxRestarts  int32  `json:"max_restarts"`
+	Status       string `json:"status"`
+	Message      string `json:"message" gorm:"type:text"`
+	//操作人，请求未携带token时为空
+	Operator      string     `json:"operator"`
+	StepStartedAt *time.Time `json:"step_started_at"`
+	UnreadySince  *time.Time `json:"unready_since"`
+}
+
+func (*WorkflowRelease) TableName() string {
+	return "workflow_release"
+}
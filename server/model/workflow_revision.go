@@ -0,0 +1,62 @@
+package model
+
+import "time"
+
+/*
+执行以下SQL创建表
+CREATE TABLE `workflow_revision` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `workflow_id` int NOT NULL,
+  `workflow_name` varchar(32) COLLATE utf8mb4_general_ci NOT NULL,
+  `revision` int NOT NULL,
+  `action` varchar(16) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `spec` mediumtext COLLATE utf8mb4_general_ci,
+  `manifests` mediumtext COLLATE utf8mb4_general_ci,
+  `template` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `template_version` int DEFAULT NULL,
+  `operator` varchar(64) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `message` varchar(255) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `created_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE KEY `idx_workflow_revision` (`workflow_id`,`revision`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+
+已按text类型创建spec字段的表执行以下SQL，附加资源较多时text的64KB不够用
+ALTER TABLE `workflow_revision` MODIFY COLUMN `spec` mediumtext COLLATE utf8mb4_general_ci;
+*/
+
+// workflow修改的来源
+const (
+	RevisionCreate   = "create"
+	RevisionUpdate   = "update"
+	RevisionSync     = "sync"
+	RevisionRollback = "rollback"
+	RevisionImport   = "import"
+	RevisionRelease  = "release"
+	RevisionPromote  = "promote"
+)
+
+// workflow的历史版本，每次创建或修改workflow保存一条，保存后不再修改
+type WorkflowRevision struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt *time.Time `json:"created_at"`
+
+	WorkflowID   uint   `json:"workflow_id"`
+	WorkflowName string `json:"workflow_name"`
+	//版本号，同一个workflow内从1开始递增
+	Revision int    `json:"revision"`
+	Action   string `json:"action"`
+	//完整参数，json格式
+	Spec string `json:"spec" gorm:"type:mediumtext"`
+	//根据参数生成的k8s资源，多文档yaml格式
+	Manifests       string `json:"manifests" gorm:"type:mediumtext"`
+	Template        string `json:"template"`
+	TemplateVersion int    `json:"template_version"`
+	//操作人，请求未携带token时为空
+	Operator string `json:"operator"`
+	Message  string `json:"message"`
+}
+
+func (*WorkflowRevision) TableName() string {
+	return "workflow_revision"
+}
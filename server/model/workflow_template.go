@@ -0,0 +1,40 @@
+package model
+
+import "time"
+
+/*
+执行以下SQL创建表
+CREATE TABLE `workflow_template` (
+  `id` int NOT NULL AUTO_INCREMENT,
+  `name` varchar(64) COLLATE utf8mb4_general_ci NOT NULL,
+  `version` int NOT NULL,
+  `description` varchar(255) COLLATE utf8mb4_general_ci DEFAULT NULL,
+  `variables` text COLLATE utf8mb4_general_ci,
+  `content` mediumtext COLLATE utf8mb4_general_ci,
+  `created_at` datetime DEFAULT NULL,
+  `updated_at` datetime DEFAULT NULL,
+  `deleted_at` datetime DEFAULT NULL,
+  PRIMARY KEY (`id`) USING BTREE,
+  UNIQUE KEY `idx_name_version` (`name`,`version`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_general_ci;
+*/
+
+// workflow模板，同名模板的每次修改都保存为一个新版本，已保存的版本不再修改
+type WorkflowTemplate struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	CreatedAt *time.Time `json:"created_at"`
+	UpdatedAt *time.Time `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at"`
+
+	Name        string `json:"name"`
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	//模板变量的定义，json格式
+	Variables string `json:"variables" gorm:"type:text"`
+	//模板内容，go template语法的yaml
+	Content string `json:"content" gorm:"type:mediumtext"`
+}
+
+func (*WorkflowTemplate) TableName() string {
+	return "workflow_template"
+}
@@ -0,0 +1,56 @@
+package response
+
+import (
+	"k8s-server/errcode"
+	"k8s-server/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 统一的响应格式
+// code为机器可读的错误码，成功时为OK；msg为中文说明，msg_en为英文说明
+type Body struct {
+	Code  string      `json:"code"`
+	Msg   string      `json:"msg"`
+	MsgEn string      `json:"msg_en"`
+	Data  interface{} `json:"data"`
+}
+
+// Success 返回200和数据
+func Success(ctx *gin.Context, msg string, data interface{}) {
+	ctx.JSON(http.StatusOK, &Body{
+		Code:  "OK",
+		Msg:   msg,
+		MsgEn: "success",
+		Data:  data,
+	})
+}
+
+// Accepted 返回202和数据，用于已接受但需要审批后才执行的请求
+func Accepted(ctx *gin.Context, msg string, data interface{}) {
+	ctx.JSON(http.StatusAccepted, &Body{
+		Code:  "Accepted",
+		Msg:   msg,
+		MsgEn: "accepted, pending approval",
+		Data:  data,
+	})
+}
+
+// Error 根据错误码返回对应的http状态码，5xx错误会记录日志
+func Error(ctx *gin.Context, err error) {
+	e := errcode.From(err)
+	if e.Status >= http.StatusInternalServerError {
+		utils.Log(ctx.Request.Context()).Error().Str("code", e.Code.Code).Str("path", ctx.FullPath()).Msg(e.Error())
+	}
+	ctx.JSON(e.Status, &Body{
+		Code:  e.Code.Code,
+		Msg:   e.Error(),
+		MsgEn: e.MessageEn(),
+	})
+}
+
+// BindError 返回请求参数错误
+func BindError(ctx *gin.Context, err error) {
+	Error(ctx, errcode.InvalidParam.Wrap(err))
+}
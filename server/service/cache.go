@@ -0,0 +1,237 @@
+package service
+
+import (
+	"context"
+	"k8s-server/config"
+	"k8s-server/utils"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/tools/cache"
+)
+
+// 集群资源的informer缓存，用于概览等需要全量数据的接口，避免每次请求都从apiserver拉取全部资源
+// 缓存未开启或未同步完成时，Snapshot退化为每种资源一次集群级别的List
+var Cache = &clusterCache{}
+
+type clusterCache struct {
+	once    sync.Once
+	factory informers.SharedInformerFactory
+	synced  atomic.Bool
+	//每种资源的informer是否同步完成，key为资源名
+	informers map[string]cache.SharedIndexInformer
+}
+
+// 定义clusterSnapshot结构体，某一时刻集群中的资源，从缓存中获取时不能修改其中的对象
+type clusterSnapshot struct {
+	Namespaces   []*corev1.Namespace
+	Nodes        []*corev1.Node
+	Pods         []*corev1.Pod
+	Deployments  []*appsv1.Deployment
+	StatefulSets []*appsv1.StatefulSet
+	DaemonSets   []*appsv1.DaemonSet
+	Jobs         []*batchv1.Job
+	CronJobs     []*batchv1.CronJob
+	//是否从缓存中获取
+	FromCache bool
+}
+
+// 启动informer，配置中未开启时不启动
+func (c *clusterCache) Start() {
+	if !config.Config.GetBool("Cache.enabled") || K8sClientSet == nil {
+		return
+	}
+	c.once.Do(func() {
+		resync := time.Duration(config.Config.GetInt("Cache.resync")) * time.Second
+		c.factory = informers.NewSharedInformerFactory(K8sClientSet, resync)
+		c.informers = map[string]cache.SharedIndexInformer{
+			"namespaces":   c.factory.Core().V1().Namespaces().Informer(),
+			"nodes":        c.factory.Core().V1().Nodes().Informer(),
+			"pods":         c.factory.Core().V1().Pods().Informer(),
+			"deployments":  c.factory.Apps().V1().Deployments().Informer(),
+			"statefulsets": c.factory.Apps().V1().StatefulSets().Informer(),
+			"daemonsets":   c.factory.Apps().V1().DaemonSets().Informer(),
+			"jobs":         c.factory.Batch().V1().Jobs().Informer(),
+			"cronjobs":     c.factory.Batch().V1().CronJobs().Informer(),
+		}
+		//服务运行期间informer不停止
+		stopCh := make(chan struct{})
+		c.factory.Start(stopCh)
+		go func() {
+			start := time.Now()
+			for resource, ok := range c.factory.WaitForCacheSync(stopCh) {
+				if !ok {
+					utils.Logger.Error().Stack().Err(errors.New("informer缓存同步失败")).Msg(resource.String())
+					return
+				}
+			}
+			c.synced.Store(true)
+			utils.Logger.Info().Dur("duration", time.Since(start)).Msg("informer缓存同步完成")
+		}()
+	})
+}
+
+// 缓存是否同步完成
+func (c *clusterCache) Synced() bool {
+	return c.synced.Load()
+}
+
+// 每种资源的缓存同步状态，缓存未开启时返回空
+func (c *clusterCache) Status() map[string]bool {
+	status := map[string]bool{}
+	for resource, informer := range c.informers {
+		status[resource] = informer.HasSynced()
+	}
+	return status
+}
+
+// 获取集群资源快照，缓存已同步时从缓存中获取，否则并发地对每种资源做一次集群级别的List
+func (c *clusterCache) Snapshot(ctx context.Context) (snapshot *clusterSnapshot, err error) {
+	if c.Synced() {
+		snapshot, err = c.fromCache()
+	} else {
+		snapshot, err = c.fromList(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	//lister返回的顺序不固定，namespace按名称排序，与List接口的顺序一致
+	sort.Slice(snapshot.Namespaces, func(i, j int) bool {
+		return snapshot.Namespaces[i].Name < snapshot.Namespaces[j].Name
+	})
+	return snapshot, nil
+}
+
+func (c *clusterCache) fromCache() (snapshot *clusterSnapshot, err error) {
+	snapshot = &clusterSnapshot{FromCache: true}
+	all := labels.Everything()
+	if snapshot.Namespaces, err = c.factory.Core().V1().Namespaces().Lister().List(all); err != nil {
+		return nil, err
+	}
+	if snapshot.Nodes, err = c.factory.Core().V1().Nodes().Lister().List(all); err != nil {
+		return nil, err
+	}
+	if snapshot.Pods, err = c.factory.Core().V1().Pods().Lister().List(all); err != nil {
+		return nil, err
+	}
+	if snapshot.Deployments, err = c.factory.Apps().V1().Deployments().Lister().List(all); err != nil {
+		return nil, err
+	}
+	if snapshot.StatefulSets, err = c.factory.Apps().V1().StatefulSets().Lister().List(all); err != nil {
+		return nil, err
+	}
+	if snapshot.DaemonSets, err = c.factory.Apps().V1().DaemonSets().Lister().List(all); err != nil {
+		return nil, err
+	}
+	if snapshot.Jobs, err = c.factory.Batch().V1().Jobs().Lister().List(all); err != nil {
+		return nil, err
+	}
+	if snapshot.CronJobs, err = c.factory.Batch().V1().CronJobs().Lister().List(all); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func (c *clusterCache) fromList(ctx context.Context) (snapshot *clusterSnapshot, err error) {
+	snapshot = &clusterSnapshot{}
+	opts := metav1.ListOptions{}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	run := func(resource string, list func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := list(); err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = errors.Wrap(err, "获取"+resource+"列表失败")
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	run("Namespace", func() error {
+		list, err := K8sClientSet.CoreV1().Namespaces().List(ctx, opts)
+		if err == nil {
+			snapshot.Namespaces = toPointers(list.Items)
+		}
+		return err
+	})
+	run("Node", func() error {
+		list, err := K8sClientSet.CoreV1().Nodes().List(ctx, opts)
+		if err == nil {
+			snapshot.Nodes = toPointers(list.Items)
+		}
+		return err
+	})
+	run("Pod", func() error {
+		list, err := K8sClientSet.CoreV1().Pods("").List(ctx, opts)
+		if err == nil {
+			snapshot.Pods = toPointers(list.Items)
+		}
+		return err
+	})
+	run("Deployment", func() error {
+		list, err := K8sClientSet.AppsV1().Deployments("").List(ctx, opts)
+		if err == nil {
+			snapshot.Deployments = toPointers(list.Items)
+		}
+		return err
+	})
+	run("StatefulSet", func() error {
+		list, err := K8sClientSet.AppsV1().StatefulSets("").List(ctx, opts)
+		if err == nil {
+			snapshot.StatefulSets = toPointers(list.Items)
+		}
+		return err
+	})
+	run("DaemonSet", func() error {
+		list, err := K8sClientSet.AppsV1().DaemonSets("").List(ctx, opts)
+		if err == nil {
+			snapshot.DaemonSets = toPointers(list.Items)
+		}
+		return err
+	})
+	run("Job", func() error {
+		list, err := K8sClientSet.BatchV1().Jobs("").List(ctx, opts)
+		if err == nil {
+			snapshot.Jobs = toPointers(list.Items)
+		}
+		return err
+	})
+	run("CronJob", func() error {
+		list, err := K8sClientSet.BatchV1().CronJobs("").List(ctx, opts)
+		if err == nil {
+			snapshot.CronJobs = toPointers(list.Items)
+		}
+		return err
+	})
+	wg.Wait()
+	if firstErr != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取集群资源失败")).Msg(firstErr.Error())
+		return nil, firstErr
+	}
+	return snapshot, nil
+}
+
+// 将对象列表转为指针列表，与lister的返回值保持一致
+func toPointers[T any](items []T) []*T {
+	pointers := make([]*T, len(items))
+	for i := range items {
+		pointers[i] = &items[i]
+	}
+	return pointers
+}
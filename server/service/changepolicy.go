@@ -0,0 +1,245 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"k8s-server/config"
+	"k8s-server/dao"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 变更审批策略，标记哪些路由、命名空间、请求方法的组合需要审批
+// 策略缓存在内存中，修改后立即刷新，多个实例部署时其他实例最多changePolicyTTL后生效
+var ChangePolicy = &changePolicy{}
+
+type changePolicy struct {
+	mu       sync.RWMutex
+	policies []*model.ChangePolicy
+	loadedAt time.Time
+}
+
+// 策略缓存的有效期
+const changePolicyTTL = 30 * time.Second
+
+// 管理审批策略需要的角色，角色及其用户在配置文件的Roles中定义
+const changePolicyRole = "admin"
+
+// 变更申请本身的路由不受审批策略限制，避免审批操作也需要审批
+const changeRoutePrefix = "/api/k8s/change"
+
+// 定义ChangePolicyCreate结构体，用于新增和修改审批策略，ID为0时新增
+// Enabled为空时，新增的策略默认启用，修改时保持不变
+type ChangePolicyCreate struct {
+	ID           int      `json:"id"`
+	Name         string   `json:"name"`
+	Method       string   `json:"method"`
+	Route        string   `json:"route"`
+	Namespaces   []string `json:"namespaces"`
+	ApproverRole string   `json:"approver_role"`
+	Enabled      *bool    `json:"enabled"`
+}
+
+// 定义ChangePolicyDetail结构体，Namespaces为解析后的命名空间列表
+type ChangePolicyDetail struct {
+	*model.ChangePolicy
+	Namespaces []string `json:"namespaces"`
+}
+
+// 获取所有审批策略
+func (c *changePolicy) GetList(ctx context.Context) ([]*ChangePolicyDetail, error) {
+	policies, err := dao.ChangePolicy.GetList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	details := make([]*ChangePolicyDetail, 0, len(policies))
+	for _, policy := range policies {
+		details = append(details, changePolicyDetail(policy))
+	}
+	return details, nil
+}
+
+// 新增审批策略
+func (c *changePolicy) Create(ctx context.Context, data *ChangePolicyCreate) (*ChangePolicyDetail, error) {
+	data.ID = 0
+	policy := &model.ChangePolicy{Enabled: true}
+	return c.save(ctx, policy, data)
+}
+
+// 修改审批策略，已经提交的变更申请仍按提交时的策略审批
+func (c *changePolicy) Update(ctx context.Context, data *ChangePolicyCreate) (*ChangePolicyDetail, error) {
+	policy, err := dao.ChangePolicy.GetById(ctx, data.ID)
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		return nil, errcode.NotFound.Newf("审批策略不存在: %d", data.ID)
+	}
+	return c.save(ctx, policy, data)
+}
+
+// 删除审批策略
+func (c *changePolicy) Delete(ctx context.Context, id int) error {
+	if err := checkChangePolicyRole(ctx); err != nil {
+		return err
+	}
+	policy, err := dao.ChangePolicy.GetById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return errcode.NotFound.Newf("审批策略不存在: %d", id)
+	}
+	if err = dao.ChangePolicy.Delete(ctx, id); err != nil {
+		return err
+	}
+	c.invalidate()
+	utils.Log(ctx).Info().Str("policy", policy.Name).Msg("删除审批策略")
+	return nil
+}
+
+// 获取请求方法和路由匹配的已启用策略，按id排列，不匹配时返回空列表
+// 命名空间需要解析请求参数才能得到，由调用方再用matchNamespace筛选
+func (c *changePolicy) Match(ctx context.Context, method, route string) ([]*model.ChangePolicy, error) {
+	if route == "" || strings.HasPrefix(route, changeRoutePrefix) {
+		return nil, nil
+	}
+	policies, err := c.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var matched []*model.ChangePolicy
+	for _, policy := range policies {
+		if !policy.Enabled {
+			continue
+		}
+		if policy.Method != "*" && policy.Method != method {
+			continue
+		}
+		if ok, _ := path.Match(policy.Route, route); ok {
+			matched = append(matched, policy)
+		}
+	}
+	return matched, nil
+}
+
+// 从缓存获取所有策略，缓存过期时从数据库重新加载
+func (c *changePolicy) load(ctx context.Context) ([]*model.ChangePolicy, error) {
+	c.mu.RLock()
+	if !c.loadedAt.IsZero() && time.Since(c.loadedAt) < changePolicyTTL {
+		defer c.mu.RUnlock()
+		return c.policies, nil
+	}
+	c.mu.RUnlock()
+
+	policies, err := dao.ChangePolicy.GetList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.policies = policies
+	c.loadedAt = time.Now()
+	return policies, nil
+}
+
+// 清空缓存，下次匹配时重新加载
+func (c *changePolicy) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loadedAt = time.Time{}
+}
+
+// 校验参数并保存审批策略
+func (c *changePolicy) save(ctx context.Context, policy *model.ChangePolicy, data *ChangePolicyCreate) (*ChangePolicyDetail, error) {
+	if err := checkChangePolicyRole(ctx); err != nil {
+		return nil, err
+	}
+	if data.Name == "" {
+		return nil, errcode.InvalidParam.New("策略名称不能为空")
+	}
+	data.Method = strings.ToUpper(data.Method)
+	switch data.Method {
+	case "*", http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+	default:
+		return nil, errcode.InvalidParam.New("请求方法只能是*、POST、PUT、PATCH、DELETE: " + data.Method)
+	}
+	if !strings.HasPrefix(data.Route, "/") {
+		return nil, errcode.InvalidParam.New("路由需要以/开头: " + data.Route)
+	}
+	if _, err := path.Match(data.Route, ""); err != nil {
+		return nil, errcode.InvalidParam.Newf("路由格式不正确: %s", data.Route)
+	}
+	for _, namespace := range data.Namespaces {
+		if _, err := path.Match(namespace, ""); err != nil || namespace == "" {
+			return nil, errcode.InvalidParam.Newf("命名空间格式不正确: %s", namespace)
+		}
+	}
+	//角色没有配置用户时，匹配的变更申请无人能审批
+	if data.ApproverRole == "" {
+		return nil, errcode.InvalidParam.New("审批角色不能为空")
+	}
+	if len(config.Config.GetStringSlice("Roles."+data.ApproverRole)) == 0 {
+		return nil, errcode.InvalidParam.New("审批角色没有配置用户: " + data.ApproverRole)
+	}
+
+	namespaces, _ := json.Marshal(data.Namespaces)
+	policy.Name = data.Name
+	policy.Method = data.Method
+	policy.Route = data.Route
+	policy.Namespaces = string(namespaces)
+	policy.ApproverRole = data.ApproverRole
+	if data.Enabled != nil {
+		policy.Enabled = *data.Enabled
+	}
+	if err := dao.ChangePolicy.Save(ctx, policy); err != nil {
+		return nil, err
+	}
+	c.invalidate()
+	utils.Log(ctx).Info().Str("policy", policy.Name).Str("method", policy.Method).Str("route", policy.Route).
+		Bool("enabled", policy.Enabled).Msg("保存审批策略")
+	return changePolicyDetail(policy), nil
+}
+
+// 策略的命名空间是否匹配，策略未配置命名空间时匹配所有请求
+// 请求中任一命名空间匹配即需要审批；请求中没有命名空间时无法判断，按匹配处理
+func matchNamespace(policy *model.ChangePolicy, namespaces []string) bool {
+	var patterns []string
+	_ = json.Unmarshal([]byte(policy.Namespaces), &patterns)
+	if len(patterns) == 0 || len(namespaces) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		for _, namespace := range namespaces {
+			if ok, _ := path.Match(pattern, namespace); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 管理审批策略需要登录并拥有changePolicyRole角色
+func checkChangePolicyRole(ctx context.Context) error {
+	username := utils.UserFromContext(ctx)
+	if username == "" {
+		return errcode.Unauthorized.New("管理审批策略需要登录")
+	}
+	if !Login.HasRole(username, changePolicyRole) {
+		return errcode.Forbidden.Newf("需要%s角色才能管理审批策略", changePolicyRole)
+	}
+	return nil
+}
+
+// 解析审批策略中json格式的字段
+func changePolicyDetail(policy *model.ChangePolicy) *ChangePolicyDetail {
+	detail := &ChangePolicyDetail{ChangePolicy: policy}
+	_ = json.Unmarshal([]byte(policy.Namespaces), &detail.Namespaces)
+	return detail
+}
@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8s-server/dao"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// 需要审批的变更申请
+// 匹配审批策略的请求由middleware.ChangeApproval拦截，保存原始请求后返回变更申请id，不立即执行
+// 另一个拥有策略审批角色的用户审批通过后，服务端以申请人的身份重新执行原始请求，执行结果记录在变更申请中
+var ChangeRequest = &changeRequest{}
+
+type changeRequest struct {
+	//执行原始请求的http handler，注册路由时设置
+	handler http.Handler
+}
+
+// 保存的响应内容的最大长度
+const changeResponseMaxLen = 64 * 1024
+
+// 定义ChangeRequestSubmit结构体，为被拦截的原始请求
+type ChangeRequestSubmit struct {
+	Method      string
+	Route       string
+	Path        string
+	RawQuery    string
+	ContentType string
+	Body        string
+	//请求中所有可能的命名空间
+	Namespaces []string
+}
+
+// context中标记审批通过正在执行的变更申请的key
+// 只能由服务端写入context，客户端无法伪造，带有该标记的请求不再被审批策略拦截
+type approvedChangeKey struct{}
+
+// 在context中标记正在执行的变更申请
+func WithApprovedChange(ctx context.Context, id uint) context.Context {
+	return context.WithValue(ctx, approvedChangeKey{}, id)
+}
+
+// 请求是否为审批通过后执行的变更申请
+func IsApprovedChange(ctx context.Context) bool {
+	_, ok := ctx.Value(approvedChangeKey{}).(uint)
+	return ok
+}
+
+// 设置执行原始请求的http handler，一般为gin.Engine
+func (c *changeRequest) SetHandler(handler http.Handler) {
+	c.handler = handler
+}
+
+// 获取变更申请列表，status为空时查询所有状态
+func (c *changeRequest) GetList(ctx context.Context, status string, page, limit int) (*dao.ChangeRequestResp, error) {
+	return dao.ChangeRequest.GetList(ctx, status, page, limit)
+}
+
+// 获取单条变更申请，包含原始请求和执行结果
+func (c *changeRequest) GetDetail(ctx context.Context, id int) (*model.ChangeRequest, error) {
+	change, err := dao.ChangeRequest.GetById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if change == nil {
+		return nil, errcode.NotFound.Newf("变更申请不存在: %d", id)
+	}
+	return change, nil
+}
+
+// 按第一条命名空间也匹配的策略保存为等待审批的变更申请，没有匹配的策略时返回nil，请求正常执行
+func (c *changeRequest) Submit(ctx context.Context, policies []*model.ChangePolicy, data *ChangeRequestSubmit) (*model.ChangeRequest, error) {
+	var policy *model.ChangePolicy
+	for _, p := range policies {
+		if matchNamespace(p, data.Namespaces) {
+			policy = p
+			break
+		}
+	}
+	if policy == nil {
+		return nil, nil
+	}
+	//申请人为空时任何审批人都不等于申请人，需要登录才能提交，保证申请人和审批人不是同一个用户
+	requester := utils.UserFromContext(ctx)
+	if requester == "" {
+		return nil, errcode.Unauthorized.New("操作需要审批, 提交变更申请需要登录")
+	}
+	change := &model.ChangeRequest{
+		PolicyID:     policy.ID,
+		Method:       data.Method,
+		Route:        data.Route,
+		Path:         data.Path,
+		RawQuery:     data.RawQuery,
+		ContentType:  data.ContentType,
+		Body:         data.Body,
+		Namespace:    strings.Join(data.Namespaces, ","),
+		ApproverRole: policy.ApproverRole,
+		Status:       model.ChangePending,
+		Message:      fmt.Sprintf("等待拥有%s角色的用户审批", policy.ApproverRole),
+		Requester:    requester,
+	}
+	if err := dao.ChangeRequest.Save(ctx, change); err != nil {
+		return nil, err
+	}
+	utils.Log(ctx).Info().Uint("change", change.ID).Str("policy", policy.Name).Str("method", change.Method).
+		Str("path", change.Path).Str("namespace", change.Namespace).Msg("请求需要审批, 已保存变更申请")
+	return change, nil
+}
+
+// 审批通过并执行变更申请，审批人需要拥有策略配置的角色，且不能是申请人
+func (c *changeRequest) Approve(ctx context.Context, id int) (*model.ChangeRequest, error) {
+	change, approver, err := c.decide(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := dao.ChangeRequest.UpdateStatusIf(ctx, change.ID, model.ChangePending, model.ChangeApproved, approver, "")
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, errcode.Conflict.Newf("变更申请已被处理: %d", change.ID)
+	}
+	change.Status = model.ChangeApproved
+	change.Approver = approver
+	change.Message = ""
+	return change, c.execute(ctx, change)
+}
+
+// 拒绝变更申请
+func (c *changeRequest) Reject(ctx context.Context, id int, reason string) error {
+	change, approver, err := c.decide(ctx, id)
+	if err != nil {
+		return err
+	}
+	updated, err := dao.ChangeRequest.UpdateStatusIf(ctx, change.ID, model.ChangePending, model.ChangeRejected, approver, reason)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return errcode.Conflict.Newf("变更申请已被处理: %d", change.ID)
+	}
+	return nil
+}
+
+// 获取等待审批的变更申请，并校验当前用户是否可以审批
+func (c *changeRequest) decide(ctx context.Context, id int) (*model.ChangeRequest, string, error) {
+	change, err := c.GetDetail(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if change.Status != model.ChangePending {
+		return nil, "", errcode.Conflict.Newf("变更申请状态为%s, 不能审批", change.Status)
+	}
+	approver := utils.UserFromContext(ctx)
+	if approver == "" {
+		return nil, "", errcode.Unauthorized.New("审批需要登录")
+	}
+	if approver == change.Requester {
+		return nil, "", errcode.Forbidden.New("不能审批自己提交的变更申请")
+	}
+	if !Login.HasRole(approver, change.ApproverRole) {
+		return nil, "", errcode.Forbidden.Newf("需要%s角色才能审批", change.ApproverRole)
+	}
+	return change, approver, nil
+}
+
+// 以申请人的身份重新执行原始请求，响应状态码为2xx时成功，结果记录在变更申请中
+// 执行不受审批人请求断开的影响，超时时间与普通请求相同
+func (c *changeRequest) execute(ctx context.Context, change *model.ChangeRequest) (err error) {
+	defer func() {
+		change.Status = model.ChangeSucceeded
+		change.Message = ""
+		if err != nil {
+			change.Status = model.ChangeFailed
+			change.Message = err.Error()
+		}
+		saveCtx, cancel := detachedContext(ctx)
+		defer cancel()
+		if saveErr := dao.ChangeRequest.Save(saveCtx, change); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+
+	if c.handler == nil {
+		return errors.New("没有设置执行变更申请的handler")
+	}
+	runCtx := WithApprovedChange(utils.WithUser(context.WithoutCancel(ctx), change.Requester), change.ID)
+	req, err := http.NewRequestWithContext(runCtx, change.Method, change.Path, strings.NewReader(change.Body))
+	if err != nil {
+		return errors.Wrap(err, "构造变更请求失败")
+	}
+	req.URL.RawQuery = change.RawQuery
+	if change.ContentType != "" {
+		req.Header.Set("Content-Type", change.ContentType)
+	}
+	recorder := httptest.NewRecorder()
+	c.handler.ServeHTTP(recorder, req)
+
+	change.ResponseStatus = recorder.Code
+	change.Response = recorder.Body.String()
+	if len(change.Response) > changeResponseMaxLen {
+		change.Response = change.Response[:changeResponseMaxLen]
+	}
+	if recorder.Code < http.StatusOK || recorder.Code >= http.StatusMultipleChoices {
+		body := new(struct {
+			Msg string `json:"msg"`
+		})
+		if json.Unmarshal(recorder.Body.Bytes(), body) != nil || body.Msg == "" {
+			body.Msg = http.StatusText(recorder.Code)
+		}
+		return errors.Errorf("执行变更申请失败, 状态码%d: %s", recorder.Code, body.Msg)
+	}
+	utils.Log(ctx).Info().Uint("change", change.ID).Str("requester", change.Requester).Str("approver", change.Approver).
+		Str("method", change.Method).Str("path", change.Path).Msg("变更申请执行成功")
+	return nil
+}
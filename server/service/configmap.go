@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"k8s-server/errcode"
 	"k8s-server/utils"
 
 	"github.com/pkg/errors"
@@ -21,12 +22,12 @@ type ConfigMapsResp struct {
 }
 
 // 获取configmap列表，支持过滤、排序、分页
-func (c *configMap) GetConfigMaps(filterName, namespace string, limit, page int) (configMapsResp *ConfigMapsResp, err error) {
+func (c *configMap) GetConfigMaps(ctx context.Context, filterName, namespace string, limit, page int) (configMapsResp *ConfigMapsResp, err error) {
 	//获取configMapList类型的configMap列表
-	configMapList, err := K8sClientSet.CoreV1().ConfigMaps(namespace).List(context.TODO(), metav1.ListOptions{})
+	configMapList, err := K8sClientSet.CoreV1().ConfigMaps(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取ConfigMap列表失败")).Msg(err.Error())
-		return nil, errors.New("获取ConfigMap列表失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取ConfigMap列表失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取ConfigMap列表失败")
 	}
 
 	selectableData := &DataSelector{
@@ -53,41 +54,47 @@ func (c *configMap) GetConfigMaps(filterName, namespace string, limit, page int)
 }
 
 // 获取configmap详情
-func (c *configMap) GetConfigMapDetail(configMapName, namespace string) (configMap *corev1.ConfigMap, err error) {
-	configMap, err = K8sClientSet.CoreV1().ConfigMaps(namespace).Get(context.TODO(), configMapName, metav1.GetOptions{})
+func (c *configMap) GetConfigMapDetail(ctx context.Context, configMapName, namespace string) (configMap *corev1.ConfigMap, err error) {
+	configMap, err = K8sClientSet.CoreV1().ConfigMaps(namespace).Get(ctx, configMapName, metav1.GetOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取ConfigMap详情失败")).Msg(err.Error())
-		return nil, errors.New("获取ConfigMap详情失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取ConfigMap详情失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取ConfigMap详情失败")
 	}
 
 	return configMap, nil
 }
 
 // 删除configmap
-func (c *configMap) DeleteConfigMap(configMapName, namespace string) (err error) {
-	err = K8sClientSet.CoreV1().ConfigMaps(namespace).Delete(context.TODO(), configMapName, metav1.DeleteOptions{})
+func (c *configMap) DeleteConfigMap(ctx context.Context, configMapName, namespace string) (err error) {
+	//删除前保存快照到回收站，删除失败时丢弃快照
+	item, err := Trash.Snapshot(ctx, corev1.SchemeGroupVersion.WithResource("configmaps"), namespace, configMapName)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("删除ConfigMap失败")).Msg(err.Error())
-		return errors.New("删除ConfigMap失败, " + err.Error())
+		return err
+	}
+	err = K8sClientSet.CoreV1().ConfigMaps(namespace).Delete(ctx, configMapName, metav1.DeleteOptions{})
+	if err != nil {
+		Trash.Discard(ctx, item)
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除ConfigMap失败")).Msg(err.Error())
+		return errors.Wrap(err, "删除ConfigMap失败")
 	}
 
 	return nil
 }
 
 // 更新configmap
-func (c *configMap) UpdateConfigMap(namespace, content string) (err error) {
+func (c *configMap) UpdateConfigMap(ctx context.Context, namespace, content string) (err error) {
 	var configMap = &corev1.ConfigMap{}
 
 	err = json.Unmarshal([]byte(content), configMap)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("反序列化失败")).Msg(err.Error())
-		return errors.New("反序列化失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("反序列化失败")).Msg(err.Error())
+		return errcode.InvalidParam.Wrapf(err, "反序列化失败")
 	}
 
-	_, err = K8sClientSet.CoreV1().ConfigMaps(namespace).Update(context.TODO(), configMap, metav1.UpdateOptions{})
+	_, err = K8sClientSet.CoreV1().ConfigMaps(namespace).Update(ctx, configMap, metav1.UpdateOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("更新ConfigMap失败")).Msg(err.Error())
-		return errors.New("更新ConfigMap失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新ConfigMap失败")).Msg(err.Error())
+		return errors.Wrap(err, "更新ConfigMap失败")
 	}
 	return nil
 }
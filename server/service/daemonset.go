@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 
+	"k8s-server/errcode"
 	"k8s-server/utils"
 
 	"github.com/pkg/errors"
@@ -22,12 +23,12 @@ type DaemonSetsResp struct {
 }
 
 // 获取daemonset列表，支持过滤、排序、分页
-func (d *daemonSet) GetDaemonSets(filterName, namespace string, limit, page int) (daemonSetsResp *DaemonSetsResp, err error) {
+func (d *daemonSet) GetDaemonSets(ctx context.Context, filterName, namespace string, limit, page int) (daemonSetsResp *DaemonSetsResp, err error) {
 	//获取daemonSetList类型的daemonSet列表
-	daemonSetList, err := K8sClientSet.AppsV1().DaemonSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	daemonSetList, err := K8sClientSet.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取DaemonSet列表失败")).Msg(err.Error())
-		return nil, errors.New("获取DaemonSet列表失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取DaemonSet列表失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取DaemonSet列表失败")
 	}
 	selectableData := &DataSelector{
 		GenericDataList: d.toCells(daemonSetList.Items),
@@ -53,41 +54,47 @@ func (d *daemonSet) GetDaemonSets(filterName, namespace string, limit, page int)
 }
 
 // 获取daemonset详情
-func (d *daemonSet) GetDaemonSetDetail(daemonSetName, namespace string) (daemonSet *appsv1.DaemonSet, err error) {
-	daemonSet, err = K8sClientSet.AppsV1().DaemonSets(namespace).Get(context.TODO(), daemonSetName, metav1.GetOptions{})
+func (d *daemonSet) GetDaemonSetDetail(ctx context.Context, daemonSetName, namespace string) (daemonSet *appsv1.DaemonSet, err error) {
+	daemonSet, err = K8sClientSet.AppsV1().DaemonSets(namespace).Get(ctx, daemonSetName, metav1.GetOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取DaemonSet详情失败")).Msg(err.Error())
-		return nil, errors.New("获取DaemonSet详情失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取DaemonSet详情失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取DaemonSet详情失败")
 	}
 
 	return daemonSet, nil
 }
 
 // 删除daemonset
-func (d *daemonSet) DeleteDaemonSet(daemonSetName, namespace string) (err error) {
-	err = K8sClientSet.AppsV1().DaemonSets(namespace).Delete(context.TODO(), daemonSetName, metav1.DeleteOptions{})
+func (d *daemonSet) DeleteDaemonSet(ctx context.Context, daemonSetName, namespace string) (err error) {
+	//删除前保存快照到回收站，删除失败时丢弃快照
+	item, err := Trash.Snapshot(ctx, appsv1.SchemeGroupVersion.WithResource("daemonsets"), namespace, daemonSetName)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("删除DaemonSet失败")).Msg(err.Error())
-		return errors.New("删除DaemonSet失败, " + err.Error())
+		return err
+	}
+	err = K8sClientSet.AppsV1().DaemonSets(namespace).Delete(ctx, daemonSetName, metav1.DeleteOptions{})
+	if err != nil {
+		Trash.Discard(ctx, item)
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除DaemonSet失败")).Msg(err.Error())
+		return errors.Wrap(err, "删除DaemonSet失败")
 	}
 
 	return nil
 }
 
 // 更新daemonset
-func (d *daemonSet) UpdateDaemonSet(namespace, content string) (err error) {
+func (d *daemonSet) UpdateDaemonSet(ctx context.Context, namespace, content string) (err error) {
 	var daemonSet = &appsv1.DaemonSet{}
 
 	err = json.Unmarshal([]byte(content), daemonSet)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("反序列化失败")).Msg(err.Error())
-		return errors.New("反序列化失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("反序列化失败")).Msg(err.Error())
+		return errcode.InvalidParam.Wrapf(err, "反序列化失败")
 	}
 
-	_, err = K8sClientSet.AppsV1().DaemonSets(namespace).Update(context.TODO(), daemonSet, metav1.UpdateOptions{})
+	_, err = K8sClientSet.AppsV1().DaemonSets(namespace).Update(ctx, daemonSet, metav1.UpdateOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("更新DaemonSet失败")).Msg(err.Error())
-		return errors.New("更新DaemonSet失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新DaemonSet失败")).Msg(err.Error())
+		return errors.Wrap(err, "更新DaemonSet失败")
 	}
 	return nil
 }
@@ -15,6 +15,7 @@ type DataSelector struct {
 	GenericDataList []DataCell // 存储数据的列表
 	FilterQuery     *FilterQuery // 过滤条件，具体属性看FilterQuery结构体{Name string}
 	PaginateQuery   *PaginateQuery // 分页条件，具体属性看PaginateQuery结构体{Limit int,Page  int}
+	SortQuery       *SortQuery // 排序条件，为空时按创建时间倒序
 }
 
 // DataCell 是数据元素的接口，用于各种资源列表的类型转换
@@ -28,6 +29,23 @@ type FilterQuery struct {
 	Name string // 名称过滤条件
 }
 
+// SortQuery 定义了排序条件，SortBy为cpu或memory时按资源使用量倒序，使用量相同或没有使用量时按创建时间倒序
+type SortQuery struct {
+	SortBy string // 排序字段，支持cpu、memory
+	Usage  map[string]*ResourceUsage // 资源使用量，key为usageKey返回的值
+}
+
+// 支持的排序字段
+const (
+	SortByCPU    = "cpu"
+	SortByMemory = "memory"
+)
+
+// namespacedCell 是带namespace的数据元素，使用量的key为"namespace/name"
+type namespacedCell interface {
+	GetNamespace() string
+}
+
 // PaginateQuery 定义了分页条件，包括每页数据条数和页数
 type PaginateQuery struct {
 	Limit int // 每页数据条数
@@ -44,11 +62,37 @@ func (d *DataSelector) Swap(i, j int) {
 	d.GenericDataList[i], d.GenericDataList[j] = d.GenericDataList[j], d.GenericDataList[i]
 }
 
-// Less 比较两个数据元素的创建时间，用于排序
+// Less 比较两个数据元素的资源使用量或创建时间，用于排序
 func (d *DataSelector) Less(i, j int) bool {
+	if d.SortQuery != nil && (d.SortQuery.SortBy == SortByCPU || d.SortQuery.SortBy == SortByMemory) {
+		a, b := d.usage(d.GenericDataList[i]), d.usage(d.GenericDataList[j])
+		if a != b {
+			return a > b
+		}
+	}
 	return d.GenericDataList[j].GetCreation().Before(d.GenericDataList[i].GetCreation())
 }
 
+// usage 返回数据元素按排序字段的资源使用量，没有使用量时返回-1，排在最后
+func (d *DataSelector) usage(cell DataCell) int64 {
+	u, ok := d.SortQuery.Usage[usageKey(cell)]
+	if !ok {
+		return -1
+	}
+	if d.SortQuery.SortBy == SortByCPU {
+		return u.CPU
+	}
+	return u.Memory
+}
+
+// usageKey 返回数据元素在资源使用量map中的key
+func usageKey(cell DataCell) string {
+	if nc, ok := cell.(namespacedCell); ok {
+		return nc.GetNamespace() + "/" + cell.GetName()
+	}
+	return cell.GetName()
+}
+
 // Sort 对数据列表进行排序
 func (d *DataSelector) Sort() *DataSelector {
 	sort.Sort(d)
@@ -103,6 +147,10 @@ func (p podCell) GetName() string {
 	return p.Name
 }
 
+func (p podCell) GetNamespace() string {
+	return p.Namespace
+}
+
 // deploymentCell 是 appsv1.Deployment 类型的数据元素，实现了 DataCell 接口
 type deploymentCell appsv1.Deployment
 
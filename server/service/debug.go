@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"k8s-server/config"
+	"k8s-server/errcode"
+	"k8s-server/utils"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// 临时调试容器，用于没有shell的镜像(distroless等)
+// 通过pods/ephemeralcontainers子资源向运行中的pod添加一个调试容器，再exec进入调试容器
+var Debug debug
+
+type debug struct{}
+
+// 定义DebugCreate结构体，用于创建调试容器需要的参数
+// TargetContainer不为空时，调试容器与目标容器共享进程命名空间，可以看到目标容器的进程
+type DebugCreate struct {
+	Namespace       string `json:"namespace"`
+	PodName         string `json:"pod_name"`
+	Image           string `json:"image"`
+	TargetContainer string `json:"target_container"`
+}
+
+// 定义websocket的handler方法，创建调试容器并等待其启动，然后在同一个websocket中打开终端
+func (d *debug) WsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		return
+	}
+	data := &DebugCreate{
+		Namespace:       r.Form.Get("namespace"),
+		PodName:         r.Form.Get("pod_name"),
+		Image:           r.Form.Get("image"),
+		TargetContainer: r.Form.Get("target_container"),
+	}
+	shell := r.Form.Get("shell")
+	username, err := wsUser(r)
+	if err != nil {
+		utils.Log(r.Context()).Info().Str("pod", data.PodName).Str("ip", r.RemoteAddr).Msg("调试容器鉴权失败, " + err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	pty, err := NewTerminalSession(w, r, nil)
+	if err != nil {
+		utils.Log(r.Context()).Error().Stack().Err(errors.New("get pty failed")).Msg(err.Error())
+		return
+	}
+	defer pty.Close()
+	release, ok := pty.acquire(username)
+	if !ok {
+		return
+	}
+	defer release()
+
+	pty.Write([]byte("正在创建调试容器...\r\n"))
+	containerName, err := d.CreateDebugContainer(r.Context(), data)
+	if err != nil {
+		pty.Write([]byte(err.Error() + "\r\n"))
+		pty.Done()
+		return
+	}
+	utils.Log(r.Context()).Info().Str("user", username).Str("namespace", data.Namespace).Str("pod", data.PodName).
+		Str("container", containerName).Str("target", data.TargetContainer).Msg("创建调试容器成功")
+	pty.Write([]byte(fmt.Sprintf("调试容器%s已启动\r\n", containerName)))
+
+	Terminal.attachShell(r.Context(), pty, username, data.Namespace, data.PodName, containerName, shell)
+}
+
+// 创建调试容器并等待其进入Running状态，返回调试容器名
+func (d *debug) CreateDebugContainer(ctx context.Context, data *DebugCreate) (containerName string, err error) {
+	image := data.Image
+	if image == "" {
+		image = config.Config.GetString("Debug.image")
+	}
+	if allowed := config.Config.GetStringSlice("Debug.allowedimages"); len(allowed) > 0 && !containsString(allowed, image) {
+		return "", errcode.Forbidden.New("不允许使用该调试镜像: " + image)
+	}
+
+	pod, err := K8sClientSet.CoreV1().Pods(data.Namespace).Get(ctx, data.PodName, metav1.GetOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Pod详情失败")).Msg(err.Error())
+		return "", errors.Wrap(err, "获取Pod详情失败")
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		return "", errcode.Conflict.New("Pod未处于Running状态: " + string(pod.Status.Phase))
+	}
+	if data.TargetContainer != "" && !hasContainer(pod, data.TargetContainer) {
+		return "", errcode.NotFound.New("目标容器不存在: " + data.TargetContainer)
+	}
+
+	containerName = "debugger-" + utilrand.String(5)
+	//Stdin和TTY保证调试镜像的默认shell不会立即退出
+	podCopy := pod.DeepCopy()
+	podCopy.Spec.EphemeralContainers = append(podCopy.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:                     containerName,
+			Image:                    image,
+			ImagePullPolicy:          corev1.PullIfNotPresent,
+			Stdin:                    true,
+			TTY:                      true,
+			TerminationMessagePolicy: corev1.TerminationMessageReadFile,
+		},
+		TargetContainerName: data.TargetContainer,
+	})
+	_, err = K8sClientSet.CoreV1().Pods(data.Namespace).UpdateEphemeralContainers(ctx, data.PodName, podCopy, metav1.UpdateOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("创建调试容器失败")).Msg(err.Error())
+		return "", errors.Wrap(err, "创建调试容器失败")
+	}
+
+	if err := d.waitForRunning(ctx, data.Namespace, data.PodName, containerName); err != nil {
+		return "", err
+	}
+	return containerName, nil
+}
+
+// 等待调试容器进入Running状态，镜像拉取失败或容器退出时直接返回错误
+func (d *debug) waitForRunning(ctx context.Context, namespace, podName, containerName string) error {
+	timeout := config.Config.GetInt("Debug.timeout")
+	if timeout <= 0 {
+		timeout = 120
+	}
+	var lastReason string
+	err := wait.PollUntilContextTimeout(ctx, time.Second, time.Duration(timeout)*time.Second, true, func(ctx context.Context) (bool, error) {
+		pod, err := K8sClientSet.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, status := range pod.Status.EphemeralContainerStatuses {
+			if status.Name != containerName {
+				continue
+			}
+			switch {
+			case status.State.Running != nil:
+				return true, nil
+			case status.State.Terminated != nil:
+				return false, errors.New("调试容器已退出: " + status.State.Terminated.Reason)
+			case status.State.Waiting != nil:
+				lastReason = status.State.Waiting.Reason
+				switch lastReason {
+				case "ErrImagePull", "ImagePullBackOff", "InvalidImageName", "CreateContainerError":
+					return false, errors.New("调试容器启动失败: " + lastReason + ", " + status.State.Waiting.Message)
+				}
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		if wait.Interrupted(err) {
+			return errcode.Timeout.New("等待调试容器启动超时, " + lastReason)
+		}
+		utils.Log(ctx).Error().Stack().Err(errors.New("等待调试容器启动失败")).Msg(err.Error())
+		return err
+	}
+	return nil
+}
+
+// 判断pod中是否存在指定的容器
+func hasContainer(pod *corev1.Pod, containerName string) bool {
+	for _, c := range pod.Spec.Containers {
+		if c.Name == containerName {
+			return true
+		}
+	}
+	return false
+}
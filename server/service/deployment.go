@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"k8s-server/errcode"
 	"k8s-server/utils"
 	"strconv"
 	"time"
@@ -11,6 +12,7 @@ import (
 
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -45,12 +47,12 @@ type DeploysNp struct {
 }
 
 // 获取deployment列表，支持过滤、排序、分页
-func (d *deployment) GetDeployments(filterName, namespace string, limit, page int) (deploymentsResp *DeploymentsResp, err error) {
+func (d *deployment) GetDeployments(ctx context.Context, filterName, namespace string, limit, page int) (deploymentsResp *DeploymentsResp, err error) {
 	//获取deploymentList类型的deployment列表
-	deploymentList, err := K8sClientSet.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{})
+	deploymentList, err := K8sClientSet.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Deployment列表失败")).Msg(err.Error())
-		return nil, errors.New("获取Deployment列表失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Deployment列表失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Deployment列表失败")
 	}
 	//将deploymentList中的deployment列表(Items)，放进dataselector对象中，进行排序
 	selectableData := &DataSelector{
@@ -77,39 +79,55 @@ func (d *deployment) GetDeployments(filterName, namespace string, limit, page in
 }
 
 // 获取deployment详情
-func (d *deployment) GetDeploymentDetail(deploymentName, namespace string) (deployment *appsv1.Deployment, err error) {
-	deployment, err = K8sClientSet.AppsV1().Deployments(namespace).Get(context.TODO(), deploymentName, metav1.GetOptions{})
+func (d *deployment) GetDeploymentDetail(ctx context.Context, deploymentName, namespace string) (deployment *appsv1.Deployment, err error) {
+	deployment, err = K8sClientSet.AppsV1().Deployments(namespace).Get(ctx, deploymentName, metav1.GetOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Deployment详情失败")).Msg(err.Error())
-		return nil, errors.New("获取Deployment详情失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Deployment详情失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Deployment详情失败")
 	}
 
 	return deployment, nil
 }
 
 // 设置deployment副本数
-func (d *deployment) ScaleDeployment(deploymentName, namespace string, scaleNum int) (replica int32, err error) {
+func (d *deployment) ScaleDeployment(ctx context.Context, deploymentName, namespace string, scaleNum int) (replica int32, err error) {
 	//获取autoscalingv1.Scale类型的对象，能点出当前的副本数
-	scale, err := K8sClientSet.AppsV1().Deployments(namespace).GetScale(context.TODO(), deploymentName, metav1.GetOptions{})
+	scale, err := K8sClientSet.AppsV1().Deployments(namespace).GetScale(ctx, deploymentName, metav1.GetOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Deployment副本数信息失败")).Msg(err.Error())
-		return 0, errors.New("获取Deployment副本数信息失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Deployment副本数信息失败")).Msg(err.Error())
+		return 0, errors.Wrap(err, "获取Deployment副本数信息失败")
 	}
 	//修改副本数
 	scale.Spec.Replicas = int32(scaleNum)
 	//更新副本数，传入scale对象
-	newScale, err := K8sClientSet.AppsV1().Deployments(namespace).UpdateScale(context.TODO(), deploymentName, scale, metav1.UpdateOptions{})
+	newScale, err := K8sClientSet.AppsV1().Deployments(namespace).UpdateScale(ctx, deploymentName, scale, metav1.UpdateOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("更新Deployment副本数信息失败")).Msg(err.Error())
-		return 0, errors.New("更新Deployment副本数信息失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Deployment副本数信息失败")).Msg(err.Error())
+		return 0, errors.Wrap(err, "更新Deployment副本数信息失败")
 	}
 
 	return newScale.Spec.Replicas, nil
 }
 
 // 创建deployment,接收DeployCreate对象
-func (d *deployment) CreateDeployment(data *DeployCreate) (err error) {
+func (d *deployment) CreateDeployment(ctx context.Context, data *DeployCreate) (err error) {
+	if err = validateResources(data.Cpu, data.Memory); err != nil {
+		return err
+	}
 	//将data中的属性组装成appsv1.Deployment对象
+	deployment := buildDeployment(data)
+	//调用sdk创建deployment
+	_, err = K8sClientSet.AppsV1().Deployments(data.Namespace).Create(ctx, deployment, metav1.CreateOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("创建Deployment失败")).Msg(err.Error())
+		return errors.Wrap(err, "创建Deployment失败")
+	}
+
+	return nil
+}
+
+// 将DeployCreate组装成appsv1.Deployment对象，创建deployment和生成workflow的manifest时使用
+func buildDeployment(data *DeployCreate) *appsv1.Deployment {
 	deployment := &appsv1.Deployment{
 		//ObjectMeta中定义资源名、命名空间以及标签
 		ObjectMeta: metav1.ObjectMeta{
@@ -150,6 +168,9 @@ func (d *deployment) CreateDeployment(data *DeployCreate) (err error) {
 		//Status定义资源的运行状态，这里由于是新建，传入空的appsv1.DeploymentStatus{}对象即可
 		Status: appsv1.DeploymentStatus{},
 	}
+	//cpu和内存不为空时设置容器的requests和limits
+	setContainerResource(&deployment.Spec.Template.Spec.Containers[0], corev1.ResourceCPU, data.Cpu)
+	setContainerResource(&deployment.Spec.Template.Spec.Containers[0], corev1.ResourceMemory, data.Memory)
 	//判断是否打开健康检查功能，若打开，则定义ReadinessProbe和LivenessProbe
 	// if data.HealthCheck {
 	// 	//设置第一个容器的ReadinessProbe，因为我们pod中只有一个容器，所以直接使用index 0即可
@@ -198,29 +219,28 @@ func (d *deployment) CreateDeployment(data *DeployCreate) (err error) {
 	// 		corev1.ResourceMemory : resource.MustParse(data.Memory),
 	// 	}
 	// }
-	//调用sdk创建deployment
-	_, err = K8sClientSet.AppsV1().Deployments(data.Namespace).Create(context.TODO(), deployment, metav1.CreateOptions{})
-	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("创建Deployment失败")).Msg(err.Error())
-		return errors.New("创建Deployment失败, " + err.Error())
-	}
-
-	return nil
+	return deployment
 }
 
 // 删除deployment
-func (d *deployment) DeleteDeployment(deploymentName, namespace string) (err error) {
-	err = K8sClientSet.AppsV1().Deployments(namespace).Delete(context.TODO(), deploymentName, metav1.DeleteOptions{})
+func (d *deployment) DeleteDeployment(ctx context.Context, deploymentName, namespace string) (err error) {
+	//删除前保存快照到回收站，删除失败时丢弃快照
+	item, err := Trash.Snapshot(ctx, appsv1.SchemeGroupVersion.WithResource("deployments"), namespace, deploymentName)
+	if err != nil {
+		return err
+	}
+	err = K8sClientSet.AppsV1().Deployments(namespace).Delete(ctx, deploymentName, metav1.DeleteOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("删除Deployment失败")).Msg(err.Error())
-		return errors.New("删除Deployment失败, " + err.Error())
+		Trash.Discard(ctx, item)
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除Deployment失败")).Msg(err.Error())
+		return errors.Wrap(err, "删除Deployment失败")
 	}
 
 	return nil
 }
 
 // 重启deployment
-func (d *deployment) RestartDeployment(deploymentName, namespace string) (err error) {
+func (d *deployment) RestartDeployment(ctx context.Context, deploymentName, namespace string) (err error) {
 	//此功能等同于一下kubectl命令
 	//kubectl deployment ${service} -p \
 	//'{"spec":{"template":{"spec":{"containers":[{"name":"'"${service}"'","env":[{"name":"RESTART_","value":"'$(date +%s)'"}]}]}}}}'
@@ -245,52 +265,48 @@ func (d *deployment) RestartDeployment(deploymentName, namespace string) (err er
 	//序列化为字节，因为patch方法只接收字节类型参数
 	patchByte, err := json.Marshal(patchData)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("json序列化失败")).Msg(err.Error())
-		return errors.New("json序列化失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("json序列化失败")).Msg(err.Error())
+		return errors.Wrap(err, "json序列化失败")
 	}
 	//调用patch方法更新deployment
-	_, err = K8sClientSet.AppsV1().Deployments(namespace).Patch(context.TODO(), deploymentName, "application/strategic-merge-patch+json", patchByte, metav1.PatchOptions{})
+	_, err = K8sClientSet.AppsV1().Deployments(namespace).Patch(ctx, deploymentName, "application/strategic-merge-patch+json", patchByte, metav1.PatchOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("重启Deployment失败")).Msg(err.Error())
-		return errors.New("重启Deployment失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("重启Deployment失败")).Msg(err.Error())
+		return errors.Wrap(err, "重启Deployment失败")
 	}
 
 	return nil
 }
 
 // 更新deployment
-func (d *deployment) UpdateDeployment(namespace, content string) (err error) {
+func (d *deployment) UpdateDeployment(ctx context.Context, namespace, content string) (err error) {
 	var deploy = &appsv1.Deployment{}
 
 	err = json.Unmarshal([]byte(content), deploy)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("反序列化失败")).Msg(err.Error())
-		return errors.New("反序列化失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("反序列化失败")).Msg(err.Error())
+		return errcode.InvalidParam.Wrapf(err, "反序列化失败")
 	}
 
-	_, err = K8sClientSet.AppsV1().Deployments(namespace).Update(context.TODO(), deploy, metav1.UpdateOptions{})
+	_, err = K8sClientSet.AppsV1().Deployments(namespace).Update(ctx, deploy, metav1.UpdateOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("更新Deployment失败")).Msg(err.Error())
-		return errors.New("更新Deployment失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Deployment失败")).Msg(err.Error())
+		return errors.Wrap(err, "更新Deployment失败")
 	}
 	return nil
 }
 
 // 获取每个namespace的deployment数量
-func (d *deployment) GetDeployNumPerNp() (deploysNps []*DeploysNp, err error) {
-	namespaceList, err := K8sClientSet.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+func (d *deployment) GetDeployNumPerNp(ctx context.Context) (deploysNps []*DeploysNp, err error) {
+	snapshot, err := Cache.Snapshot(ctx)
 	if err != nil {
 		return nil, err
 	}
-	for _, namespace := range namespaceList.Items {
-		deploymentList, err := K8sClientSet.AppsV1().Deployments(namespace.Name).List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			return nil, err
-		}
-
+	counts := Overview.countPerNamespace(snapshot)
+	for _, namespace := range snapshot.Namespaces {
 		deploysNp := &DeploysNp{
 			Namespace: namespace.Name,
-			DeployNum: len(deploymentList.Items),
+			DeployNum: counts.get(namespace.Name).Deployments,
 		}
 
 		deploysNps = append(deploysNps, deploysNp)
@@ -314,3 +330,68 @@ func (d *deployment) fromCells(cells []DataCell) []appsv1.Deployment {
 
 	return deployments
 }
+
+// 校验cpu和内存的格式，为空表示不设置
+func validateResources(cpu, memory string) error {
+	for _, value := range []string{cpu, memory} {
+		if value == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(value); err != nil {
+			return errcode.InvalidParam.Newf("资源格式不正确: %s", value)
+		}
+	}
+	return nil
+}
+
+// 将容器某种资源的requests和limits设置为相同的值，value为空或格式不正确时不修改，返回是否有变化
+func setContainerResource(container *corev1.Container, name corev1.ResourceName, value string) bool {
+	if value == "" {
+		return false
+	}
+	quantity, err := resource.ParseQuantity(value)
+	if err != nil {
+		return false
+	}
+	limit, hasLimit := container.Resources.Limits[name]
+	request, hasRequest := container.Resources.Requests[name]
+	if hasLimit && hasRequest && limit.Cmp(quantity) == 0 && request.Cmp(quantity) == 0 {
+		return false
+	}
+	if container.Resources.Limits == nil {
+		container.Resources.Limits = corev1.ResourceList{}
+	}
+	if container.Resources.Requests == nil {
+		container.Resources.Requests = corev1.ResourceList{}
+	}
+	container.Resources.Limits[name] = quantity
+	container.Resources.Requests[name] = quantity
+	return true
+}
+
+// 设置容器第一个端口的containerPort，没有端口时新增http端口，port不大于0时不修改，返回是否有变化
+func setContainerPort(container *corev1.Container, port int32) bool {
+	if port <= 0 {
+		return false
+	}
+	if len(container.Ports) == 0 {
+		container.Ports = []corev1.ContainerPort{{Name: "http", Protocol: corev1.ProtocolTCP, ContainerPort: port}}
+		return true
+	}
+	if container.Ports[0].ContainerPort == port {
+		return false
+	}
+	container.Ports[0].ContainerPort = port
+	return true
+}
+
+// 容器某种资源的值，优先取limits，没有设置时返回空字符串
+func containerResource(container *corev1.Container, name corev1.ResourceName) string {
+	if quantity, ok := container.Resources.Limits[name]; ok {
+		return quantity.String()
+	}
+	if quantity, ok := container.Resources.Requests[name]; ok {
+		return quantity.String()
+	}
+	return ""
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"k8s-server/dao"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// 环境，按顺序排列的命名空间，例如dev、staging、prod，workflow按顺序逐个环境提升
+var Environment environment
+
+type environment struct{}
+
+// 定义EnvironmentCreate结构体，用于新增和修改环境，ID为0时新增
+// Hosts中的{name}在提升时替换为workflow名，例如{name}.staging.example.com
+type EnvironmentCreate struct {
+	ID           int      `json:"id"`
+	Name         string   `json:"name"`
+	Namespace    string   `json:"namespace"`
+	Position     int      `json:"position"`
+	Replicas     *int32   `json:"replicas"`
+	Cpu          string   `json:"cpu"`
+	Memory       string   `json:"memory"`
+	Hosts        []string `json:"hosts"`
+	ApproverRole string   `json:"approver_role"`
+}
+
+// 定义EnvironmentDetail结构体，Hosts为解析后的域名列表
+type EnvironmentDetail struct {
+	*model.Environment
+	Hosts []string `json:"hosts"`
+}
+
+// 获取所有环境，按提升顺序排列
+func (e *environment) GetList(ctx context.Context) ([]*EnvironmentDetail, error) {
+	environments, err := dao.Environment.GetList(ctx)
+	if err != nil {
+		return nil, err
+	}
+	details := make([]*EnvironmentDetail, 0, len(environments))
+	for _, env := range environments {
+		details = append(details, environmentDetail(env))
+	}
+	return details, nil
+}
+
+// 新增环境
+func (e *environment) Create(ctx context.Context, data *EnvironmentCreate) (*EnvironmentDetail, error) {
+	data.ID = 0
+	return e.save(ctx, &model.Environment{}, data)
+}
+
+// 修改环境，已提升到该环境的workflow不受影响，下次提升时使用新的覆盖参数
+func (e *environment) Update(ctx context.Context, data *EnvironmentCreate) (*EnvironmentDetail, error) {
+	env, err := dao.Environment.GetById(ctx, data.ID)
+	if err != nil {
+		return nil, err
+	}
+	if env == nil {
+		return nil, errcode.NotFound.Newf("环境不存在: %d", data.ID)
+	}
+	return e.save(ctx, env, data)
+}
+
+// 删除环境
+func (e *environment) Delete(ctx context.Context, id int) error {
+	env, err := dao.Environment.GetById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if env == nil {
+		return errcode.NotFound.Newf("环境不存在: %d", id)
+	}
+	return dao.Environment.Delete(ctx, id)
+}
+
+// 校验参数并保存环境
+func (e *environment) save(ctx context.Context, env *model.Environment, data *EnvironmentCreate) (*EnvironmentDetail, error) {
+	//环境名会作为workflow名的后缀
+	if errs := validation.IsDNS1123Label(data.Name); len(errs) > 0 {
+		return nil, errcode.InvalidParam.Newf("环境名称不合法: %s, %s", data.Name, strings.Join(errs, "; "))
+	}
+	if data.Namespace == "" {
+		return nil, errcode.InvalidParam.New("命名空间不能为空")
+	}
+	if data.Replicas != nil && *data.Replicas < 0 {
+		return nil, errcode.InvalidParam.New("副本数不能小于0")
+	}
+	if err := validateResources(data.Cpu, data.Memory); err != nil {
+		return nil, err
+	}
+	for _, host := range data.Hosts {
+		if host == "" {
+			return nil, errcode.InvalidParam.New("域名不能为空")
+		}
+	}
+	if _, err := K8sClientSet.CoreV1().Namespaces().Get(ctx, data.Namespace, metav1.GetOptions{}); err != nil {
+		return nil, errors.Wrap(err, "获取命名空间失败")
+	}
+
+	hosts, _ := json.Marshal(data.Hosts)
+	env.Name = data.Name
+	env.Namespace = data.Namespace
+	env.Position = data.Position
+	env.Replicas = data.Replicas
+	env.Cpu = data.Cpu
+	env.Memory = data.Memory
+	env.Hosts = string(hosts)
+	env.ApproverRole = data.ApproverRole
+	if err := dao.Environment.Save(ctx, env); err != nil {
+		return nil, err
+	}
+	return environmentDetail(env), nil
+}
+
+// 解析环境中json格式的字段
+func environmentDetail(env *model.Environment) *EnvironmentDetail {
+	detail := &EnvironmentDetail{Environment: env}
+	_ = json.Unmarshal([]byte(env.Hosts), &detail.Hosts)
+	return detail
+}
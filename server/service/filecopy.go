@@ -0,0 +1,207 @@
+package service
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"k8s-server/config"
+	"k8s-server/errcode"
+	"k8s-server/utils"
+	"mime/multipart"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// 与kubectl cp一样，通过在容器中执行tar命令实现文件的上传和下载，要求容器中存在tar命令
+var FileCopy fileCopy
+
+type fileCopy struct{}
+
+// 超过下载大小上限时返回的错误
+var errDownloadTooLarge = errors.New("下载文件超过大小限制")
+
+// 上传文件大小上限，单位字节，配置文件中单位为MB
+func (f *fileCopy) MaxUploadSize() int64 {
+	return config.Config.GetInt64("FileCopy.maxuploadsize") << 20
+}
+
+// 下载文件大小上限(压缩前)，单位字节，配置文件中单位为MB
+func (f *fileCopy) maxDownloadSize() int64 {
+	return config.Config.GetInt64("FileCopy.maxdownloadsize") << 20
+}
+
+// 文件拷贝的超时时间
+func (f *fileCopy) timeout() time.Duration {
+	timeout := config.Config.GetInt("FileCopy.timeout")
+	if timeout <= 0 {
+		timeout = 300
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// 上传文件到容器的destDir目录中
+// multipart会去掉文件名中的目录，上传目录时通过paths传入每个文件的相对路径(例如 dir/sub/file)，
+// 会在destDir下创建对应的目录，paths为空时使用文件名
+func (f *fileCopy) Upload(ctx context.Context, namespace, podName, containerName, destDir string, files []*multipart.FileHeader, paths []string) (err error) {
+	destDir, err = sanitizeContainerPath(destDir)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return errcode.InvalidParam.New("上传文件不能为空")
+	}
+	//先校验所有文件名和总大小，避免上传一半失败
+	var total int64
+	names := make([]string, len(files))
+	if len(paths) > 0 && len(paths) != len(files) {
+		return errcode.InvalidParam.New("paths的数量与上传文件数量不一致")
+	}
+	for i, file := range files {
+		name := file.Filename
+		if len(paths) > 0 {
+			name = paths[i]
+		}
+		names[i], err = sanitizeArchiveName(name)
+		if err != nil {
+			return err
+		}
+		total += file.Size
+	}
+	if max := f.MaxUploadSize(); max > 0 && total > max {
+		return errcode.InvalidParam.Newf("上传文件总大小%d字节超过限制%d字节", total, max)
+	}
+
+	//边打包边通过exec的stdin写入容器，不在内存中保存整个tar包
+	reader, writer := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(writer)
+		for i, file := range files {
+			if err := writeTarEntry(tw, names[i], file); err != nil {
+				writer.CloseWithError(err)
+				return
+			}
+		}
+		writer.CloseWithError(tw.Close())
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, f.timeout())
+	defer cancel()
+	var stderr bytes.Buffer
+	//-m不还原文件修改时间，避免容器内时间不一致时tar报警告
+	err = streamExec(ctx, namespace, podName, containerName, []string{"tar", "-xmf", "-", "-C", destDir}, remotecommand.StreamOptions{
+		Stdin:  reader,
+		Stderr: &stderr,
+	})
+	reader.Close()
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("上传文件失败")).Str("stderr", stderr.String()).Msg(err.Error())
+		return errors.New("上传文件失败, " + strings.TrimSpace(err.Error()+" "+stderr.String()))
+	}
+	utils.Log(ctx).Info().Str("namespace", namespace).Str("pod", podName).Str("container", containerName).
+		Str("dest", destDir).Strs("files", names).Int64("size", total).Msg("上传文件成功")
+	return nil
+}
+
+// 从容器中下载文件或目录，以tar.gz格式写入w
+func (f *fileCopy) Download(ctx context.Context, namespace, podName, containerName, srcPath string, w io.Writer) (err error) {
+	srcPath, err = sanitizeContainerPath(srcPath)
+	if err != nil {
+		return err
+	}
+	if srcPath == "/" {
+		return errcode.InvalidParam.New("不支持下载根目录")
+	}
+	//切换到父目录打包，这样tar包里只包含要下载的文件或目录本身
+	//文件名加上./前缀，避免以-开头的文件名被tar当作参数
+	dir, base := path.Split(srcPath)
+
+	gw := gzip.NewWriter(w)
+	lw := &limitWriter{w: gw, limit: f.maxDownloadSize()}
+	ctx, cancel := context.WithTimeout(ctx, f.timeout())
+	defer cancel()
+	var stderr bytes.Buffer
+	err = streamExec(ctx, namespace, podName, containerName, []string{"tar", "-cf", "-", "-C", dir, "./" + base}, remotecommand.StreamOptions{
+		Stdout: lw,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		if lw.exceeded {
+			err = errDownloadTooLarge
+		}
+		utils.Log(ctx).Error().Stack().Err(errors.New("下载文件失败")).Str("stderr", stderr.String()).Msg(err.Error())
+		return errors.New("下载文件失败, " + strings.TrimSpace(err.Error()+" "+stderr.String()))
+	}
+	if err := gw.Close(); err != nil {
+		return errors.Wrap(err, "下载文件失败")
+	}
+	utils.Log(ctx).Info().Str("namespace", namespace).Str("pod", podName).Str("container", containerName).
+		Str("src", srcPath).Int64("size", lw.written).Msg("下载文件成功")
+	return nil
+}
+
+// 将上传的文件写入tar包
+func writeTarEntry(tw *tar.Writer, name string, file *multipart.FileHeader) error {
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	err = tw.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    file.Size,
+		ModTime: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, src)
+	return err
+}
+
+// 校验容器内的路径，必须是绝对路径，返回清理后的路径
+func sanitizeContainerPath(p string) (string, error) {
+	if p == "" || !strings.HasPrefix(p, "/") {
+		return "", errcode.InvalidParam.New("容器内路径必须是绝对路径: " + p)
+	}
+	if strings.ContainsRune(p, 0) {
+		return "", errcode.InvalidParam.New("容器内路径不合法: " + p)
+	}
+	return path.Clean(p), nil
+}
+
+// 校验tar包中的文件名，只允许相对路径且不能跳出目标目录
+func sanitizeArchiveName(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	cleaned := path.Clean(strings.TrimLeft(name, "/"))
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") || strings.ContainsRune(cleaned, 0) {
+		return "", errcode.InvalidParam.New("文件名不合法: " + name)
+	}
+	return cleaned, nil
+}
+
+// limitWriter 限制写入的字节数，超过限制时返回错误，用于中断下载
+type limitWriter struct {
+	w        io.Writer
+	limit    int64
+	written  int64
+	exceeded bool
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	//limit小于等于0表示不限制
+	if l.limit > 0 && l.written+int64(len(p)) > l.limit {
+		l.exceeded = true
+		return 0, errDownloadTooLarge
+	}
+	n, err := l.w.Write(p)
+	l.written += int64(n)
+	return n, err
+}
@@ -3,8 +3,10 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"sort"
 
 	"github.com/pkg/errors"
+	"k8s-server/errcode"
 	"k8s-server/utils"
 
 	nwv1 "k8s.io/api/networking/v1"
@@ -37,12 +39,12 @@ type HttpPath struct {
 }
 
 // 获取ingress列表，支持过滤、排序、分页
-func (i *ingress) GetIngresses(filterName, namespace string, limit, page int) (ingressesResp *IngressesResp, err error) {
+func (i *ingress) GetIngresses(ctx context.Context, filterName, namespace string, limit, page int) (ingressesResp *IngressesResp, err error) {
 	//获取ingressList类型的ingress列表
-	ingressList, err := K8sClientSet.NetworkingV1().Ingresses(namespace).List(context.TODO(), metav1.ListOptions{})
+	ingressList, err := K8sClientSet.NetworkingV1().Ingresses(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Ingress列表失败")).Msg(err.Error())
-		return nil, errors.New("获取Ingress列表失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Ingress列表失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Ingress列表失败")
 	}
 	//将ingressList中的ingress列表(Items)，放进dataselector对象中，进行排序
 	selectableData := &DataSelector{
@@ -69,22 +71,32 @@ func (i *ingress) GetIngresses(filterName, namespace string, limit, page int) (i
 }
 
 // 获取ingress详情
-func (i *ingress) GetIngresstDetail(ingressName, namespace string) (ingress *nwv1.Ingress, err error) {
-	ingress, err = K8sClientSet.NetworkingV1().Ingresses(namespace).Get(context.TODO(), ingressName, metav1.GetOptions{})
+func (i *ingress) GetIngresstDetail(ctx context.Context, ingressName, namespace string) (ingress *nwv1.Ingress, err error) {
+	ingress, err = K8sClientSet.NetworkingV1().Ingresses(namespace).Get(ctx, ingressName, metav1.GetOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Ingress详情失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Ingress详情失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Ingress详情失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Ingress详情失败")
 	}
 
 	return ingress, nil
 }
 
 // 创建ingress
-func (i *ingress) CreateIngress(data *IngressCreate) (err error) {
-	//声明nwv1.IngressRule和nwv1.HTTPIngressPath变量，后面组装数据于鏊用到
-	var ingressRules []nwv1.IngressRule
-	var httpIngressPATHs []nwv1.HTTPIngressPath
+func (i *ingress) CreateIngress(ctx context.Context, data *IngressCreate) (err error) {
 	//将data中的数据组装成nwv1.Ingress对象
+	ingress := buildIngress(data)
+	//创建ingress
+	_, err = K8sClientSet.NetworkingV1().Ingresses(data.Namespace).Create(ctx, ingress, metav1.CreateOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("创建Ingress失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "创建Ingress失败")
+	}
+
+	return nil
+}
+
+// 将IngressCreate组装成nwv1.Ingress对象，创建ingress和生成workflow的manifest时使用
+func buildIngress(data *IngressCreate) *nwv1.Ingress {
 	ingress := &nwv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      data.Name,
@@ -93,9 +105,26 @@ func (i *ingress) CreateIngress(data *IngressCreate) (err error) {
 		},
 		Status: nwv1.IngressStatus{},
 	}
+	//将host和path组装成ingress的规则
+	ingress.Spec.Rules = buildIngressRules(data.Hosts)
+	return ingress
+}
+
+// 将host和path组装成ingress的规则，创建ingress和更新workflow时使用
+func buildIngressRules(hosts map[string][]*HttpPath) []nwv1.IngressRule {
+	var ingressRules []nwv1.IngressRule
+	//按host排序，保证同样的参数生成的规则顺序一致
+	keys := make([]string, 0, len(hosts))
+	for key := range hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
 	//第一层for循环是将host组装成nwv1.IngressRule类型的对象
 	// 一个host对应一个ingressrule，每个ingressrule中包含一个host和多个path
-	for key, value := range data.Hosts {
+	for _, key := range keys {
+		value := hosts[key]
+		//每个host的path单独组装，避免不同host的path混在一起
+		var httpIngressPATHs []nwv1.HTTPIngressPath
 		ir := nwv1.IngressRule{
 			Host: key,
 			//这里现将nwv1.HTTPIngressRuleValue类型中的Paths置为空，后面组装好数据再赋值
@@ -125,43 +154,40 @@ func (i *ingress) CreateIngress(data *IngressCreate) (err error) {
 		//将每个ir对象组装成数组，这个ir对象就是IngressRule，每个元素是一个host和多个path
 		ingressRules = append(ingressRules, ir)
 	}
-	//将ingressRules对象加入到ingress的规则中
-	ingress.Spec.Rules = ingressRules
-	//创建ingress
-	_, err = K8sClientSet.NetworkingV1().Ingresses(data.Namespace).Create(context.TODO(), ingress, metav1.CreateOptions{})
-	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("创建Ingress失败, ")).Msg(err.Error())
-		return errors.New("创建Ingress失败, " + err.Error())
-	}
-
-	return nil
+	return ingressRules
 }
 
 // 删除ingress
-func (i *ingress) DeleteIngress(ingressName, namespace string) (err error) {
-	err = K8sClientSet.NetworkingV1().Ingresses(namespace).Delete(context.TODO(), ingressName, metav1.DeleteOptions{})
+func (i *ingress) DeleteIngress(ctx context.Context, ingressName, namespace string) (err error) {
+	//删除前保存快照到回收站，删除失败时丢弃快照
+	item, err := Trash.Snapshot(ctx, nwv1.SchemeGroupVersion.WithResource("ingresses"), namespace, ingressName)
+	if err != nil {
+		return err
+	}
+	err = K8sClientSet.NetworkingV1().Ingresses(namespace).Delete(ctx, ingressName, metav1.DeleteOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("删除Ingress失败, ")).Msg(err.Error())
-		return errors.New("删除Ingress失败, " + err.Error())
+		Trash.Discard(ctx, item)
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除Ingress失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "删除Ingress失败")
 	}
 
 	return nil
 }
 
 // 更新ingress
-func (i *ingress) UpdateIngress(namespace, content string) (err error) {
+func (i *ingress) UpdateIngress(ctx context.Context, namespace, content string) (err error) {
 	var ingress = &nwv1.Ingress{}
 
 	err = json.Unmarshal([]byte(content), ingress)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("反序列化失败, ")).Msg(err.Error())
-		return errors.New("反序列化失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("反序列化失败, ")).Msg(err.Error())
+		return errcode.InvalidParam.Wrapf(err, "反序列化失败")
 	}
 
-	_, err = K8sClientSet.NetworkingV1().Ingresses(namespace).Update(context.TODO(), ingress, metav1.UpdateOptions{})
+	_, err = K8sClientSet.NetworkingV1().Ingresses(namespace).Update(ctx, ingress, metav1.UpdateOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("更新ingress失败, ")).Msg(err.Error())
-		return errors.New("更新ingress失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新ingress失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "更新ingress失败")
 	}
 	return nil
 }
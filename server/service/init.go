@@ -11,17 +11,34 @@ import (
 	"fmt"
 	"k8s-server/config"
 
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
+	metricsclientset "k8s.io/metrics/pkg/client/clientset/versioned"
 )
 
 var K8sClientSet *kubernetes.Clientset
 
+// MetricsClientSet 用于查询metrics-server提供的metrics.k8s.io接口
+var MetricsClientSet *metricsclientset.Clientset
+
+// K8sDynamicClient 用于操作任意类型的资源，回收站保存和恢复资源时使用
+var K8sDynamicClient *dynamic.DynamicClient
+
+// K8sRestConfig 保存k8s的rest配置，exec、port-forward等需要建立SPDY连接的功能会用到
+var K8sRestConfig *rest.Config
+
 func InitK8sClientSet() {
 	conf, err := clientcmd.BuildConfigFromFlags("", config.Config.GetString("Kubenertes.config"))
 	if err != nil {
 		fmt.Println("创建k8s配置失败, " + err.Error())
 	}
+	if conf != nil {
+		//记录k8s client请求的prometheus指标
+		conf.Wrap(instrumentTransport)
+	}
+	K8sRestConfig = conf
 
 	clientSet, err := kubernetes.NewForConfig(conf)
 	if err != nil {
@@ -31,4 +48,18 @@ func InitK8sClientSet() {
 
 		K8sClientSet = clientSet
 	}
+
+	dynamicClient, err := dynamic.NewForConfig(conf)
+	if err != nil {
+		fmt.Println("创建k8s dynamic client失败, " + err.Error())
+	} else {
+		K8sDynamicClient = dynamicClient
+	}
+
+	metricsClientSet, err := metricsclientset.NewForConfig(conf)
+	if err != nil {
+		fmt.Println("创建metrics clientSet失败, " + err.Error())
+	} else {
+		MetricsClientSet = metricsClientSet
+	}
 }
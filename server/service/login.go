@@ -2,8 +2,8 @@ package service
 
 import (
 	"k8s-server/config"
-
-	"github.com/pkg/errors"
+	"k8s-server/errcode"
+	"k8s-server/utils"
 
 	"github.com/wonderivan/logger"
 )
@@ -12,13 +12,26 @@ var Login login
 
 type login struct{}
 
-// 验证账号密码
-func (l *login) Auth(username, password string) (err error) {
+// 验证账号密码，验证通过后返回token
+func (l *login) Auth(username, password string) (token string, err error) {
 	if username == config.Config.GetString("User.adminuser") && password == config.Config.GetString("User.adminpwd") {
-		return nil
+		return utils.JWTToken.GenToken(username)
 	} else {
 		logger.Error("登录失败, 用户名或密码错误")
-		return errors.New("登录失败, 用户名或密码错误")
+		return "", errcode.Unauthorized.New("登录失败, 用户名或密码错误")
 	}
 	// return nil
 }
+
+// 用户是否拥有指定角色，角色及其用户在配置文件的Roles中定义
+func (l *login) HasRole(username, role string) bool {
+	if username == "" || role == "" {
+		return false
+	}
+	for _, user := range config.Config.GetStringSlice("Roles." + role) {
+		if user == username {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"k8s-server/utils"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// 通过metrics.k8s.io接口(metrics-server)获取pod和node的实时cpu、内存使用量
+// 集群未安装metrics-server时不报错，只返回Available为false，调用方照常返回其他数据
+var Metrics = &metrics{}
+
+type metrics struct {
+	mu sync.Mutex
+	//metrics接口不可用时，在此时间之前不再请求，避免每次列表请求都等待一次失败的调用
+	unavailableUntil time.Time
+}
+
+// metrics接口不可用后，重新探测的间隔
+const metricsRetryInterval = time.Minute
+
+// 定义ResourceUsage结构体，cpu单位为毫核，memory单位为字节
+type ResourceUsage struct {
+	CPU    int64 `json:"cpu"`
+	Memory int64 `json:"memory"`
+}
+
+// 定义MetricsResp结构体，Usage的key为资源名，pod为"namespace/name"格式
+type MetricsResp struct {
+	Available bool                      `json:"available"`
+	Usage     map[string]*ResourceUsage `json:"usage"`
+}
+
+// 获取pod的资源使用量，namespace为空时获取所有namespace，pod的使用量为所有容器之和
+func (m *metrics) GetPodMetrics(ctx context.Context, namespace string) (data *MetricsResp) {
+	data = &MetricsResp{Usage: map[string]*ResourceUsage{}}
+	if !m.available() {
+		return data
+	}
+	podMetricsList, err := MetricsClientSet.MetricsV1beta1().PodMetricses(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		m.markUnavailable(ctx, err)
+		return data
+	}
+	for _, podMetrics := range podMetricsList.Items {
+		usage := &ResourceUsage{}
+		for _, container := range podMetrics.Containers {
+			usage.CPU += container.Usage.Cpu().MilliValue()
+			usage.Memory += container.Usage.Memory().Value()
+		}
+		data.Usage[podMetrics.Namespace+"/"+podMetrics.Name] = usage
+	}
+	data.Available = true
+	return data
+}
+
+// 获取node的资源使用量
+func (m *metrics) GetNodeMetrics(ctx context.Context) (data *MetricsResp) {
+	data = &MetricsResp{Usage: map[string]*ResourceUsage{}}
+	if !m.available() {
+		return data
+	}
+	nodeMetricsList, err := MetricsClientSet.MetricsV1beta1().NodeMetricses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		m.markUnavailable(ctx, err)
+		return data
+	}
+	for _, nodeMetrics := range nodeMetricsList.Items {
+		data.Usage[nodeMetrics.Name] = &ResourceUsage{
+			CPU:    nodeMetrics.Usage.Cpu().MilliValue(),
+			Memory: nodeMetrics.Usage.Memory().Value(),
+		}
+	}
+	data.Available = true
+	return data
+}
+
+// metrics接口当前是否可用
+func (m *metrics) available() bool {
+	if MetricsClientSet == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return time.Now().After(m.unavailableUntil)
+}
+
+// 记录metrics接口不可用，一段时间内不再请求
+// 请求被取消或超时是调用方的原因，不影响其他请求
+func (m *metrics) markUnavailable(ctx context.Context, err error) {
+	if ctx.Err() != nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		utils.Log(ctx).Info().Msg("获取metrics被取消, " + err.Error())
+		return
+	}
+	m.mu.Lock()
+	m.unavailableUntil = time.Now().Add(metricsRetryInterval)
+	m.mu.Unlock()
+	utils.Log(ctx).Warn().Err(errors.New("获取metrics失败, metrics-server可能未安装")).Msg(err.Error())
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"k8s-server/config"
+	"k8s-server/dao"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 资源使用量的历史数据，后台按固定间隔采样pod、node和namespace的使用量并写入数据库，用于绘制图表
+// namespace的使用量为其中所有pod使用量之和，超过保留时间的采样会被定期清理
+var MetricsHistory = &metricsHistory{}
+
+type metricsHistory struct {
+	once sync.Once
+}
+
+// 清理过期采样的间隔
+const metricsPurgeInterval = time.Hour
+
+// 定义MetricSeries结构体，返回一个对象在时间范围内降采样后的使用量，Step为每个数据点的时间窗口，单位秒
+type MetricSeries struct {
+	Kind      string             `json:"kind"`
+	Namespace string             `json:"namespace"`
+	Name      string             `json:"name"`
+	Start     time.Time          `json:"start"`
+	End       time.Time          `json:"end"`
+	Step      int                `json:"step"`
+	Points    []*dao.MetricPoint `json:"points"`
+}
+
+// 启动后台采样，配置中未开启时不启动
+func (m *metricsHistory) Start() {
+	if !config.Config.GetBool("Metrics.history") {
+		return
+	}
+	m.once.Do(func() {
+		go m.run()
+		utils.Logger.Info().Dur("interval", m.interval()).Dur("retention", m.retention()).Msg("资源使用量采样已启动")
+	})
+}
+
+// 采样间隔
+func (m *metricsHistory) interval() time.Duration {
+	interval := config.Config.GetInt("Metrics.collectinterval")
+	if interval <= 0 {
+		interval = 60
+	}
+	return time.Duration(interval) * time.Second
+}
+
+// 采样保留时间
+func (m *metricsHistory) retention() time.Duration {
+	retention := config.Config.GetInt("Metrics.retention")
+	if retention <= 0 {
+		retention = 48
+	}
+	return time.Duration(retention) * time.Hour
+}
+
+func (m *metricsHistory) run() {
+	ticker := time.NewTicker(m.interval())
+	defer ticker.Stop()
+	var lastPurge time.Time
+	for range ticker.C {
+		//每次采样的超时时间不超过采样间隔，避免apiserver或数据库卡住时采样堆积
+		ctx, cancel := context.WithTimeout(context.Background(), m.interval())
+		m.collect(ctx)
+		if time.Since(lastPurge) > metricsPurgeInterval {
+			m.purge(ctx)
+			lastPurge = time.Now()
+		}
+		cancel()
+	}
+}
+
+// 采样一次，metrics-server不可用时跳过
+func (m *metricsHistory) collect(ctx context.Context) {
+	now := time.Now().Truncate(time.Second)
+	podMetrics := Metrics.GetPodMetrics(ctx, "")
+	nodeMetrics := Metrics.GetNodeMetrics(ctx)
+	if !podMetrics.Available && !nodeMetrics.Available {
+		return
+	}
+
+	samples := make([]*model.MetricSample, 0, len(podMetrics.Usage)+len(nodeMetrics.Usage))
+	namespaceUsage := map[string]*ResourceUsage{}
+	for key, usage := range podMetrics.Usage {
+		namespace, name, _ := strings.Cut(key, "/")
+		samples = append(samples, &model.MetricSample{
+			Kind: model.MetricKindPod, Namespace: namespace, Name: name,
+			CPU: usage.CPU, Memory: usage.Memory, Timestamp: now,
+		})
+		if _, ok := namespaceUsage[namespace]; !ok {
+			namespaceUsage[namespace] = &ResourceUsage{}
+		}
+		namespaceUsage[namespace].CPU += usage.CPU
+		namespaceUsage[namespace].Memory += usage.Memory
+	}
+	for namespace, usage := range namespaceUsage {
+		samples = append(samples, &model.MetricSample{
+			Kind: model.MetricKindNamespace, Namespace: namespace,
+			CPU: usage.CPU, Memory: usage.Memory, Timestamp: now,
+		})
+	}
+	for name, usage := range nodeMetrics.Usage {
+		samples = append(samples, &model.MetricSample{
+			Kind: model.MetricKindNode, Name: name,
+			CPU: usage.CPU, Memory: usage.Memory, Timestamp: now,
+		})
+	}
+	//错误已在dao中记录日志，下次采样继续
+	_ = dao.MetricSample.BatchAdd(ctx, samples)
+}
+
+// 清理超过保留时间的采样
+func (m *metricsHistory) purge(ctx context.Context) {
+	deleted, err := dao.MetricSample.DeleteBefore(ctx, time.Now().Add(-m.retention()))
+	if err != nil {
+		return
+	}
+	utils.Logger.Info().Int64("deleted", deleted).Msg("清理过期资源使用量采样")
+}
+
+// 获取一个对象最近一段时间的使用量，rangeStr为时间范围，例如1h、24h，默认1h
+// 数据点数量不超过配置的maxpoints，每个数据点的时间窗口不小于采样间隔
+func (m *metricsHistory) GetSeries(ctx context.Context, kind, namespace, name, rangeStr string) (series *MetricSeries, err error) {
+	switch kind {
+	case model.MetricKindPod:
+		if namespace == "" || name == "" {
+			return nil, errcode.InvalidParam.New("查询pod使用量需要指定namespace和name")
+		}
+	case model.MetricKindNode:
+		if name == "" {
+			return nil, errcode.InvalidParam.New("查询node使用量需要指定name")
+		}
+		namespace = ""
+	case model.MetricKindNamespace:
+		if namespace == "" {
+			return nil, errcode.InvalidParam.New("查询namespace使用量需要指定namespace")
+		}
+		name = ""
+	default:
+		return nil, errcode.InvalidParam.New("不支持的类型: " + kind)
+	}
+
+	duration := time.Hour
+	if rangeStr != "" {
+		duration, err = time.ParseDuration(rangeStr)
+		if err != nil || duration <= 0 {
+			return nil, errcode.InvalidParam.New("时间范围不合法: " + rangeStr)
+		}
+	}
+	if retention := m.retention(); duration > retention {
+		duration = retention
+	}
+
+	maxPoints := config.Config.GetInt("Metrics.maxpoints")
+	if maxPoints <= 0 {
+		maxPoints = 120
+	}
+	step := int((duration + time.Duration(maxPoints)*time.Second - 1) / (time.Duration(maxPoints) * time.Second))
+	if minStep := int(m.interval() / time.Second); step < minStep {
+		step = minStep
+	}
+
+	end := time.Now()
+	start := end.Add(-duration)
+	points, err := dao.MetricSample.GetSeries(ctx, kind, namespace, name, start, end, step)
+	if err != nil {
+		return nil, err
+	}
+	return &MetricSeries{
+		Kind:      kind,
+		Namespace: namespace,
+		Name:      name,
+		Start:     start,
+		End:       end,
+		Step:      step,
+		Points:    points,
+	}, nil
+}
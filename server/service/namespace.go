@@ -20,12 +20,12 @@ type NamespacesResp struct {
 }
 
 // 获取namespace列表，支持过滤、排序、分页
-func (n *namespace) GetNamespaces(filterName string, limit, page int) (namespacesResp *NamespacesResp, err error) {
+func (n *namespace) GetNamespaces(ctx context.Context, filterName string, limit, page int) (namespacesResp *NamespacesResp, err error) {
 	//获取namespaceList类型的namespace列表
-	namespaceList, err := K8sClientSet.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+	namespaceList, err := K8sClientSet.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Namespace列表失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Namespace列表失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Namespace列表失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Namespace列表失败")
 	}
 	//将namespaceList中的namespace列表(Items)，放进dataselector对象中，进行排序
 	selectableData := &DataSelector{
@@ -52,22 +52,28 @@ func (n *namespace) GetNamespaces(filterName string, limit, page int) (namespace
 }
 
 // 获取namespace详情
-func (n *namespace) GetNamespaceDetail(namespaceName string) (namespace *corev1.Namespace, err error) {
-	namespace, err = K8sClientSet.CoreV1().Namespaces().Get(context.TODO(), namespaceName, metav1.GetOptions{})
+func (n *namespace) GetNamespaceDetail(ctx context.Context, namespaceName string) (namespace *corev1.Namespace, err error) {
+	namespace, err = K8sClientSet.CoreV1().Namespaces().Get(ctx, namespaceName, metav1.GetOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Namespace详情失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Namespace详情失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Namespace详情失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Namespace详情失败")
 	}
 
 	return namespace, nil
 }
 
 // 删除namespace
-func (n *namespace) DeleteNamespace(namespaceName string) (err error) {
-	err = K8sClientSet.CoreV1().Namespaces().Delete(context.TODO(), namespaceName, metav1.DeleteOptions{})
+func (n *namespace) DeleteNamespace(ctx context.Context, namespaceName string) (err error) {
+	//删除前保存namespace及其中所有资源的快照到回收站，删除失败时丢弃快照
+	item, err := Trash.SnapshotNamespace(ctx, namespaceName)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("删除Namespace失败, ")).Msg(err.Error())
-		return errors.New("删除Namespace失败, " + err.Error())
+		return err
+	}
+	err = K8sClientSet.CoreV1().Namespaces().Delete(ctx, namespaceName, metav1.DeleteOptions{})
+	if err != nil {
+		Trash.Discard(ctx, item)
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除Namespace失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "删除Namespace失败")
 	}
 
 	return nil
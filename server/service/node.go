@@ -2,12 +2,27 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"k8s-server/config"
+	"k8s-server/errcode"
 	"k8s-server/utils"
+	"math"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 
 	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
 )
 
 var Node node
@@ -17,15 +32,47 @@ type node struct{}
 type NodesResp struct {
 	Items []corev1.Node `json:"items"`
 	Total int           `json:"total"`
+	//Usage是Items中node的cpu、内存使用量，key为node名，MetricsAvailable为false时表示未安装metrics-server
+	Usage            map[string]*ResourceUsage `json:"usage"`
+	MetricsAvailable bool                      `json:"metrics_available"`
+}
+
+// 定义NodeDetail结构体，在node原始数据的基础上增加node上的pod和资源分配情况，类似kubectl describe node
+// 内嵌的*corev1.Node序列化时字段会展开到顶层，保持与原来返回corev1.Node时的格式兼容
+type NodeDetail struct {
+	*corev1.Node
+	Pods        []corev1.Pod            `json:"pods"`
+	Allocated   *NodeAllocatedResources `json:"allocated"`
+	PodCount    int                     `json:"pod_count"`
+	PodCapacity int64                   `json:"pod_capacity"`
+	Conditions  []corev1.NodeCondition  `json:"conditions"`
+	Taints      []corev1.Taint          `json:"taints"`
+}
+
+// 定义NodeAllocatedResources结构体，node上pod的requests和limits总和与allocatable的对比
+// cpu单位为毫核，memory单位为字节，百分比为相对于allocatable的百分比
+type NodeAllocatedResources struct {
+	CPURequests           int64   `json:"cpu_requests"`
+	CPURequestsPercent    float64 `json:"cpu_requests_percent"`
+	CPULimits             int64   `json:"cpu_limits"`
+	CPULimitsPercent      float64 `json:"cpu_limits_percent"`
+	CPUAllocatable        int64   `json:"cpu_allocatable"`
+	MemoryRequests        int64   `json:"memory_requests"`
+	MemoryRequestsPercent float64 `json:"memory_requests_percent"`
+	MemoryLimits          int64   `json:"memory_limits"`
+	MemoryLimitsPercent   float64 `json:"memory_limits_percent"`
+	MemoryAllocatable     int64   `json:"memory_allocatable"`
+	PodPercent            float64 `json:"pod_percent"`
 }
 
 // 获取node列表，支持过滤、排序、分页
-func (n *node) GetNodes(filterName string, limit, page int) (nodesResp *NodesResp, err error) {
+// sortBy为cpu或memory时按资源使用量倒序，否则按创建时间倒序
+func (n *node) GetNodes(ctx context.Context, filterName, sortBy string, limit, page int) (nodesResp *NodesResp, err error) {
 	//获取nodeList类型的node列表
-	nodeList, err := K8sClientSet.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
+	nodeList, err := K8sClientSet.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Node列表失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Node列表失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Node列表失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Node列表失败")
 	}
 	//将nodeList中的node列表(Items)，放进dataselector对象中，进行排序
 	selectableData := &DataSelector{
@@ -37,6 +84,12 @@ func (n *node) GetNodes(filterName string, limit, page int) (nodesResp *NodesRes
 			Page:  page,
 		},
 	}
+	//获取node的资源使用量，metrics-server不可用时使用量为空
+	nodeMetrics := Metrics.GetNodeMetrics(ctx)
+	selectableData.SortQuery = &SortQuery{
+		SortBy: sortBy,
+		Usage:  nodeMetrics.Usage,
+	}
 
 	filtered := selectableData.Filter()
 	total := len(filtered.GenericDataList)
@@ -44,22 +97,129 @@ func (n *node) GetNodes(filterName string, limit, page int) (nodesResp *NodesRes
 
 	//将[]DataCell类型的node列表转为v1.node列表
 	nodes := n.fromCells(data.GenericDataList)
+	//只返回当前页node的使用量
+	usage := map[string]*ResourceUsage{}
+	for _, node := range nodes {
+		if u, ok := nodeMetrics.Usage[node.Name]; ok {
+			usage[node.Name] = u
+		}
+	}
 
 	return &NodesResp{
-		Items: nodes,
-		Total: total,
+		Items:            nodes,
+		Total:            total,
+		Usage:            usage,
+		MetricsAvailable: nodeMetrics.Available,
 	}, nil
 }
 
-// 获取node详情
-func (n *node) GetNodeDetail(nodeName string) (node *corev1.Node, err error) {
-	node, err = K8sClientSet.CoreV1().Nodes().Get(context.TODO(), nodeName, metav1.GetOptions{})
+// 获取node详情，包括node上运行中的pod和资源分配情况
+func (n *node) GetNodeDetail(ctx context.Context, nodeName string) (detail *NodeDetail, err error) {
+	node, err := K8sClientSet.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Node详情失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Node详情失败")
+	}
+	//与kubectl describe node一致，只统计未结束的pod
+	podList, err := K8sClientSet.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.AndSelectors(
+			fields.OneTermEqualSelector("spec.nodeName", nodeName),
+			fields.OneTermNotEqualSelector("status.phase", string(corev1.PodSucceeded)),
+			fields.OneTermNotEqualSelector("status.phase", string(corev1.PodFailed)),
+		).String(),
+	})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Node详情失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Node详情失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Node上的Pod列表失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Node上的Pod列表失败")
+	}
+
+	return &NodeDetail{
+		Node:        node,
+		Pods:        podList.Items,
+		Allocated:   nodeAllocatedResources(node, podList.Items),
+		PodCount:    len(podList.Items),
+		PodCapacity: node.Status.Capacity.Pods().Value(),
+		Conditions:  node.Status.Conditions,
+		Taints:      node.Spec.Taints,
+	}, nil
+}
+
+// 统计pod的requests和limits总和，并计算相对于node allocatable的百分比
+func nodeAllocatedResources(node *corev1.Node, pods []corev1.Pod) *NodeAllocatedResources {
+	reqs, limits := corev1.ResourceList{}, corev1.ResourceList{}
+	for i := range pods {
+		podReqs, podLimits := podRequestsAndLimits(&pods[i])
+		addResourceList(reqs, podReqs)
+		addResourceList(limits, podLimits)
+	}
+	allocatable := node.Status.Allocatable
+	allocated := &NodeAllocatedResources{
+		CPURequests:       reqs.Cpu().MilliValue(),
+		CPULimits:         limits.Cpu().MilliValue(),
+		CPUAllocatable:    allocatable.Cpu().MilliValue(),
+		MemoryRequests:    reqs.Memory().Value(),
+		MemoryLimits:      limits.Memory().Value(),
+		MemoryAllocatable: allocatable.Memory().Value(),
+	}
+	allocated.CPURequestsPercent = percent(allocated.CPURequests, allocated.CPUAllocatable)
+	allocated.CPULimitsPercent = percent(allocated.CPULimits, allocated.CPUAllocatable)
+	allocated.MemoryRequestsPercent = percent(allocated.MemoryRequests, allocated.MemoryAllocatable)
+	allocated.MemoryLimitsPercent = percent(allocated.MemoryLimits, allocated.MemoryAllocatable)
+	allocated.PodPercent = percent(int64(len(pods)), node.Status.Capacity.Pods().Value())
+	return allocated
+}
+
+// 计算单个pod的requests和limits，规则与kubectl一致：
+// 普通容器求和，init容器取最大值，两者取较大者，再加上pod的overhead
+func podRequestsAndLimits(pod *corev1.Pod) (reqs, limits corev1.ResourceList) {
+	reqs, limits = corev1.ResourceList{}, corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		addResourceList(reqs, container.Resources.Requests)
+		addResourceList(limits, container.Resources.Limits)
+	}
+	for _, container := range pod.Spec.InitContainers {
+		maxResourceList(reqs, container.Resources.Requests)
+		maxResourceList(limits, container.Resources.Limits)
+	}
+	if pod.Spec.Overhead != nil {
+		addResourceList(reqs, pod.Spec.Overhead)
+		for name, quantity := range pod.Spec.Overhead {
+			if value, ok := limits[name]; ok {
+				value.Add(quantity)
+				limits[name] = value
+			}
+		}
 	}
+	return reqs, limits
+}
 
-	return node, nil
+// 将newList累加到list中
+func addResourceList(list, newList corev1.ResourceList) {
+	for name, quantity := range newList {
+		if value, ok := list[name]; !ok {
+			list[name] = quantity.DeepCopy()
+		} else {
+			value.Add(quantity)
+			list[name] = value
+		}
+	}
+}
+
+// list中的每项取list和newList中的较大值
+func maxResourceList(list, newList corev1.ResourceList) {
+	for name, quantity := range newList {
+		if value, ok := list[name]; !ok || quantity.Cmp(value) > 0 {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+}
+
+// 计算百分比，保留两位小数
+func percent(value, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(float64(value)/float64(total)*10000) / 100
 }
 
 func (n *node) toCells(std []corev1.Node) []DataCell {
@@ -78,3 +238,428 @@ func (n *node) fromCells(cells []DataCell) []corev1.Node {
 
 	return nodes
 }
+
+// 定义NodeDrain结构体，用于drain node需要的参数
+// DeleteEmptyDirData为false时，存在使用emptyDir的pod则drain失败，因为驱逐后emptyDir中的数据会丢失
+// Force为false时，存在不受控制器管理的pod则drain失败，因为这些pod驱逐后不会被重建
+// GracePeriodSeconds小于0时使用pod自身的terminationGracePeriodSeconds
+// Timeout为整个drain的超时时间，单位秒，小于等于0时使用默认值
+type NodeDrain struct {
+	NodeName           string `json:"node_name"`
+	DeleteEmptyDirData bool   `json:"delete_emptydir_data"`
+	Force              bool   `json:"force"`
+	GracePeriodSeconds int    `json:"grace_period_seconds"`
+	Timeout            int    `json:"timeout"`
+}
+
+// 驱逐被PDB拒绝时的重试间隔
+const evictionRetryInterval = 5 * time.Second
+
+// 设置node是否可调度，unschedulable为true时即cordon，false时即uncordon
+func (n *node) CordonNode(ctx context.Context, nodeName string, unschedulable bool) (err error) {
+	patchByte, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"unschedulable": unschedulable,
+		},
+	})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("json序列化失败")).Msg(err.Error())
+		return errors.Wrap(err, "json序列化失败")
+	}
+	_, err = K8sClientSet.CoreV1().Nodes().Patch(ctx, nodeName, types.StrategicMergePatchType, patchByte, metav1.PatchOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("设置Node调度状态失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "设置Node调度状态失败")
+	}
+	return nil
+}
+
+// drain node，先cordon，再通过Eviction API驱逐node上的pod
+// drain在后台执行，返回操作ID，进度通过Operation查询
+func (n *node) DrainNode(ctx context.Context, data *NodeDrain) (opID string, err error) {
+	//先把需要驱逐的pod找出来并检查，检查不通过时不做任何修改
+	pods, err := n.podsToEvict(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	if err := n.CordonNode(ctx, data.NodeName, true); err != nil {
+		return "", err
+	}
+
+	op := Operation.Start("drain", data.NodeName)
+	op.Log("Node %s 已设置为不可调度", data.NodeName)
+	op.SetTotal(len(pods))
+	go func() {
+		err := n.evictPods(op, data, pods)
+		if err == nil {
+			op.Log("Node %s drain完成", data.NodeName)
+		}
+		utils.Log(ctx).Info().Str("node", data.NodeName).Str("operation", op.ID).AnErr("result", err).Msg("drain node结束")
+		op.Finish(err)
+	}()
+	return op.ID, nil
+}
+
+// 获取node上需要驱逐的pod，DaemonSet管理的pod和静态pod(mirror pod)会被跳过
+func (n *node) podsToEvict(ctx context.Context, data *NodeDrain) (pods []corev1.Pod, err error) {
+	podList, err := K8sClientSet.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("spec.nodeName", data.NodeName).String(),
+	})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Node上的Pod列表失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Node上的Pod列表失败")
+	}
+	var problems []string
+	for _, pod := range podList.Items {
+		//静态pod由kubelet管理，无法通过apiserver删除
+		if _, ok := pod.Annotations[corev1.MirrorPodAnnotationKey]; ok {
+			continue
+		}
+		controller := metav1.GetControllerOf(&pod)
+		//DaemonSet的pod驱逐后会被立即重建在同一个node上
+		if controller != nil && controller.Kind == "DaemonSet" {
+			continue
+		}
+		//已结束的pod直接删除，不需要检查
+		if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+			if controller == nil && !data.Force {
+				problems = append(problems, fmt.Sprintf("%s/%s 不受控制器管理", pod.Namespace, pod.Name))
+			}
+			if hasEmptyDir(&pod) && !data.DeleteEmptyDirData {
+				problems = append(problems, fmt.Sprintf("%s/%s 使用了emptyDir", pod.Namespace, pod.Name))
+			}
+		}
+		pods = append(pods, pod)
+	}
+	if len(problems) > 0 {
+		return nil, errcode.Conflict.New("无法drain Node, " + strings.Join(problems, "; "))
+	}
+	return pods, nil
+}
+
+// 并发驱逐pod，并等待pod被删除
+func (n *node) evictPods(op *OperationStatus, data *NodeDrain, pods []corev1.Pod) error {
+	timeout := data.Timeout
+	if timeout <= 0 {
+		timeout = config.Config.GetInt("Node.draintimeout")
+	}
+	if timeout <= 0 {
+		timeout = 600
+	}
+	//drain在后台执行，请求返回后仍要继续，不使用请求的context
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []string
+	)
+	for i := range pods {
+		wg.Add(1)
+		go func(pod *corev1.Pod) {
+			defer wg.Done()
+			if err := n.evictPod(ctx, op, pod, data.GracePeriodSeconds); err != nil {
+				op.Log("驱逐Pod %s/%s 失败: %v", pod.Namespace, pod.Name, err)
+				mu.Lock()
+				failures = append(failures, pod.Namespace+"/"+pod.Name)
+				mu.Unlock()
+				return
+			}
+			op.Step()
+		}(&pods[i])
+	}
+	wg.Wait()
+	if len(failures) > 0 {
+		return errors.New("部分Pod驱逐失败: " + strings.Join(failures, ", "))
+	}
+	return nil
+}
+
+// 驱逐单个pod，被PDB拒绝(429)时重试，直到超时，驱逐成功后等待pod被删除
+func (n *node) evictPod(ctx context.Context, op *OperationStatus, pod *corev1.Pod, gracePeriodSeconds int) error {
+	eviction := &policyv1.Eviction{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+		},
+		DeleteOptions: &metav1.DeleteOptions{},
+	}
+	if gracePeriodSeconds >= 0 {
+		grace := int64(gracePeriodSeconds)
+		eviction.DeleteOptions.GracePeriodSeconds = &grace
+	}
+	for {
+		err := K8sClientSet.PolicyV1().Evictions(pod.Namespace).Evict(ctx, eviction)
+		if err == nil || apierrors.IsNotFound(err) {
+			break
+		}
+		if !apierrors.IsTooManyRequests(err) {
+			return err
+		}
+		op.Log("Pod %s/%s 的驱逐被PodDisruptionBudget拒绝，%s后重试", pod.Namespace, pod.Name, evictionRetryInterval)
+		select {
+		case <-ctx.Done():
+			return errors.New("等待PodDisruptionBudget允许驱逐超时")
+		case <-time.After(evictionRetryInterval):
+		}
+	}
+	op.Log("Pod %s/%s 已驱逐", pod.Namespace, pod.Name)
+
+	//pod被删除或者同名pod被重建(UID变化)都认为驱逐完成
+	err := wait.PollUntilContextCancel(ctx, time.Second, true, func(ctx context.Context) (bool, error) {
+		p, err := K8sClientSet.CoreV1().Pods(pod.Namespace).Get(ctx, pod.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) || (err == nil && p.UID != pod.UID) {
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return errors.New("等待Pod删除超时")
+	}
+	return nil
+}
+
+// 判断pod是否使用了emptyDir
+func hasEmptyDir(pod *corev1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// 定义NodeTaint结构体，用于添加、更新、删除node的污点
+type NodeTaint struct {
+	Key    string             `json:"key"`
+	Value  string             `json:"value"`
+	Effect corev1.TaintEffect `json:"effect"`
+}
+
+// 修改node标签、注解的操作类型
+const (
+	nodeMetaAdd    = "add"
+	nodeMetaUpdate = "update"
+	nodeMetaRemove = "remove"
+)
+
+// 添加node标签，标签已存在时报错
+func (n *node) AddNodeLabels(ctx context.Context, nodeName string, labels map[string]string) (err error) {
+	return n.patchNodeMeta(ctx, nodeName, "labels", nodeMetaAdd, labels)
+}
+
+// 更新node标签，标签不存在时报错
+func (n *node) UpdateNodeLabels(ctx context.Context, nodeName string, labels map[string]string) (err error) {
+	return n.patchNodeMeta(ctx, nodeName, "labels", nodeMetaUpdate, labels)
+}
+
+// 删除node标签
+func (n *node) RemoveNodeLabels(ctx context.Context, nodeName string, keys []string) (err error) {
+	return n.patchNodeMeta(ctx, nodeName, "labels", nodeMetaRemove, keysToMap(keys))
+}
+
+// 添加node注解，注解已存在时报错
+func (n *node) AddNodeAnnotations(ctx context.Context, nodeName string, annotations map[string]string) (err error) {
+	return n.patchNodeMeta(ctx, nodeName, "annotations", nodeMetaAdd, annotations)
+}
+
+// 更新node注解，注解不存在时报错
+func (n *node) UpdateNodeAnnotations(ctx context.Context, nodeName string, annotations map[string]string) (err error) {
+	return n.patchNodeMeta(ctx, nodeName, "annotations", nodeMetaUpdate, annotations)
+}
+
+// 删除node注解
+func (n *node) RemoveNodeAnnotations(ctx context.Context, nodeName string, keys []string) (err error) {
+	return n.patchNodeMeta(ctx, nodeName, "annotations", nodeMetaRemove, keysToMap(keys))
+}
+
+// 通过merge patch修改node的标签或注解，只会修改传入的key，不会覆盖kubelet维护的其他key
+// patch中带上resourceVersion，node在此期间被修改时apiserver会返回Conflict，此时重新获取node后重试
+func (n *node) patchNodeMeta(ctx context.Context, nodeName, field, op string, items map[string]string) (err error) {
+	if len(items) == 0 {
+		return errcode.InvalidParam.New("修改内容不能为空")
+	}
+	for key, value := range items {
+		if err := validateNodeMetaKey(key); err != nil {
+			return err
+		}
+		if field == "labels" && op != nodeMetaRemove {
+			if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+				return errcode.InvalidParam.New("标签值不合法: " + value + ", " + strings.Join(errs, "; "))
+			}
+		}
+	}
+
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := K8sClientSet.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		current := node.Labels
+		if field == "annotations" {
+			current = node.Annotations
+		}
+		//merge patch中值为null表示删除该key
+		changes := map[string]interface{}{}
+		for key, value := range items {
+			_, exists := current[key]
+			switch {
+			case op == nodeMetaAdd && exists:
+				return errcode.AlreadyExists.New("key已存在: " + key)
+			case op == nodeMetaUpdate && !exists:
+				return errcode.NotFound.New("key不存在: " + key)
+			case op == nodeMetaRemove:
+				changes[key] = nil
+			default:
+				changes[key] = value
+			}
+		}
+		patchByte, err := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"resourceVersion": node.ResourceVersion,
+				field:             changes,
+			},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = K8sClientSet.CoreV1().Nodes().Patch(ctx, nodeName, types.MergePatchType, patchByte, metav1.PatchOptions{})
+		return err
+	})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("修改Node " + field + "失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "修改Node "+field+"失败")
+	}
+	return nil
+}
+
+// 添加node污点，key和effect相同的污点已存在时报错
+func (n *node) AddNodeTaint(ctx context.Context, nodeName string, taint *NodeTaint) (err error) {
+	if err := validateNodeTaint(taint); err != nil {
+		return err
+	}
+	return n.patchNodeTaints(ctx, nodeName, func(taints []corev1.Taint) ([]corev1.Taint, error) {
+		if findTaint(taints, taint.Key, taint.Effect) >= 0 {
+			return nil, errcode.AlreadyExists.New("污点已存在: " + taint.Key + ":" + string(taint.Effect))
+		}
+		return append(taints, corev1.Taint{Key: taint.Key, Value: taint.Value, Effect: taint.Effect}), nil
+	})
+}
+
+// 更新node污点的值，按key和effect匹配
+func (n *node) UpdateNodeTaint(ctx context.Context, nodeName string, taint *NodeTaint) (err error) {
+	if err := validateNodeTaint(taint); err != nil {
+		return err
+	}
+	return n.patchNodeTaints(ctx, nodeName, func(taints []corev1.Taint) ([]corev1.Taint, error) {
+		i := findTaint(taints, taint.Key, taint.Effect)
+		if i < 0 {
+			return nil, errcode.NotFound.New("污点不存在: " + taint.Key + ":" + string(taint.Effect))
+		}
+		taints[i].Value = taint.Value
+		return taints, nil
+	})
+}
+
+// 删除node污点，effect为空时删除该key的所有污点
+func (n *node) RemoveNodeTaint(ctx context.Context, nodeName string, taint *NodeTaint) (err error) {
+	return n.patchNodeTaints(ctx, nodeName, func(taints []corev1.Taint) ([]corev1.Taint, error) {
+		result := []corev1.Taint{}
+		for _, t := range taints {
+			if t.Key == taint.Key && (taint.Effect == "" || t.Effect == taint.Effect) {
+				continue
+			}
+			result = append(result, t)
+		}
+		if len(result) == len(taints) {
+			return nil, errcode.NotFound.New("污点不存在: " + taint.Key)
+		}
+		return result, nil
+	})
+}
+
+// 通过json patch修改node的污点
+// taints列表没有合并策略，所以先test resourceVersion再替换整个列表，node在此期间被修改时重试
+func (n *node) patchNodeTaints(ctx context.Context, nodeName string, mutate func([]corev1.Taint) ([]corev1.Taint, error)) (err error) {
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		node, err := K8sClientSet.CoreV1().Nodes().Get(ctx, nodeName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		taints, err := mutate(append([]corev1.Taint{}, node.Spec.Taints...))
+		if err != nil {
+			return err
+		}
+		patchByte, err := json.Marshal([]map[string]interface{}{
+			{"op": "test", "path": "/metadata/resourceVersion", "value": node.ResourceVersion},
+			{"op": "add", "path": "/spec/taints", "value": taints},
+		})
+		if err != nil {
+			return err
+		}
+		_, err = K8sClientSet.CoreV1().Nodes().Patch(ctx, nodeName, types.JSONPatchType, patchByte, metav1.PatchOptions{})
+		//test失败时apiserver返回422，转换为Conflict以便重试
+		if apierrors.IsInvalid(err) && strings.Contains(err.Error(), "test operation") {
+			return apierrors.NewConflict(corev1.Resource("nodes"), nodeName, err)
+		}
+		return err
+	})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("修改Node污点失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "修改Node污点失败")
+	}
+	return nil
+}
+
+// 校验标签、注解的key，kubernetes.io和k8s.io前缀由kubelet等组件维护，只允许修改node-role和node-restriction前缀
+func validateNodeMetaKey(key string) error {
+	if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+		return errcode.InvalidParam.New("key格式不合法: " + key + ", " + strings.Join(errs, "; "))
+	}
+	prefix, _, found := strings.Cut(key, "/")
+	if !found {
+		return nil
+	}
+	if prefix == "node-role.kubernetes.io" || prefix == "node-restriction.kubernetes.io" {
+		return nil
+	}
+	if prefix == "kubernetes.io" || prefix == "k8s.io" || strings.HasSuffix(prefix, ".kubernetes.io") || strings.HasSuffix(prefix, ".k8s.io") {
+		return errcode.Forbidden.New("不允许修改系统保留的key: " + key)
+	}
+	return nil
+}
+
+// 校验污点的key、value和effect
+func validateNodeTaint(taint *NodeTaint) error {
+	if errs := validation.IsQualifiedName(taint.Key); len(errs) > 0 {
+		return errcode.InvalidParam.New("污点key格式不合法: " + taint.Key + ", " + strings.Join(errs, "; "))
+	}
+	if errs := validation.IsValidLabelValue(taint.Value); len(errs) > 0 {
+		return errcode.InvalidParam.New("污点value格式不合法: " + taint.Value + ", " + strings.Join(errs, "; "))
+	}
+	switch taint.Effect {
+	case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		return nil
+	}
+	return errcode.InvalidParam.New("污点effect不合法: " + string(taint.Effect) + ", 可选值为NoSchedule、PreferNoSchedule、NoExecute")
+}
+
+// 查找key和effect相同的污点，返回下标，不存在时返回-1
+func findTaint(taints []corev1.Taint, key string, effect corev1.TaintEffect) int {
+	for i, t := range taints {
+		if t.Key == key && t.Effect == effect {
+			return i
+		}
+	}
+	return -1
+}
+
+// 将key列表转换为map，用于删除操作
+func keysToMap(keys []string) map[string]string {
+	m := make(map[string]string, len(keys))
+	for _, key := range keys {
+		m[key] = ""
+	}
+	return m
+}
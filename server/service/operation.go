@@ -0,0 +1,187 @@
+package service
+
+import (
+	"fmt"
+	"k8s-server/errcode"
+	"sort"
+	"sync"
+	"time"
+
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+)
+
+// 长时间运行的操作(例如node drain)，在后台goroutine中执行，web端通过操作ID轮询或订阅进度
+// 操作记录只保存在内存中，服务重启后丢失，结束的操作保留operationRetention后清理
+var Operation = &operation{ops: map[string]*OperationStatus{}}
+
+type operation struct {
+	mu  sync.RWMutex
+	ops map[string]*OperationStatus
+}
+
+// 结束的操作在内存中保留的时间
+const operationRetention = time.Hour
+
+// 操作的状态
+const (
+	OperationRunning   = "Running"
+	OperationSucceeded = "Succeeded"
+	OperationFailed    = "Failed"
+)
+
+// 定义OperationStatus结构体，保存操作的进度和日志
+type OperationStatus struct {
+	ID         string          `json:"id"`
+	Type       string          `json:"type"`
+	Target     string          `json:"target"`
+	Status     string          `json:"status"`
+	Total      int             `json:"total"`
+	Done       int             `json:"done"`
+	Error      string          `json:"error"`
+	Logs       []*OperationLog `json:"logs"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at"`
+
+	//订阅进度变化的channel
+	watchers map[chan struct{}]struct{}
+}
+
+// 定义OperationLog结构体，操作过程中的一条日志
+type OperationLog struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// 定义OperationsResp结构体，返回操作列表
+type OperationsResp struct {
+	Items []*OperationStatus `json:"items"`
+	Total int                `json:"total"`
+}
+
+// 创建一个操作，返回的OperationStatus用于在后台任务中更新进度
+func (o *operation) Start(opType, target string) *OperationStatus {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.purge()
+	op := &OperationStatus{
+		ID:        fmt.Sprintf("%s-%d-%s", opType, time.Now().Unix(), utilrand.String(5)),
+		Type:      opType,
+		Target:    target,
+		Status:    OperationRunning,
+		Logs:      []*OperationLog{},
+		StartedAt: time.Now(),
+		watchers:  map[chan struct{}]struct{}{},
+	}
+	o.ops[op.ID] = op
+	return op
+}
+
+// 获取操作详情，返回的是快照，可以安全地序列化
+func (o *operation) Get(id string) (*OperationStatus, error) {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	op, ok := o.ops[id]
+	if !ok {
+		return nil, errcode.NotFound.New("操作不存在: " + id)
+	}
+	return op.snapshot(), nil
+}
+
+// 获取操作列表，按开始时间倒序，opType为空时返回所有类型
+func (o *operation) List(opType string) *OperationsResp {
+	o.mu.RLock()
+	defer o.mu.RUnlock()
+	items := []*OperationStatus{}
+	for _, op := range o.ops {
+		if opType == "" || op.Type == opType {
+			items = append(items, op.snapshot())
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].StartedAt.After(items[j].StartedAt)
+	})
+	return &OperationsResp{Items: items, Total: len(items)}
+}
+
+// 订阅操作的进度变化，每次变化都会向channel发送一个信号，调用cancel取消订阅
+func (o *operation) Watch(id string) (ch <-chan struct{}, cancel func(), err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	op, ok := o.ops[id]
+	if !ok {
+		return nil, nil, errcode.NotFound.New("操作不存在: " + id)
+	}
+	c := make(chan struct{}, 1)
+	op.watchers[c] = struct{}{}
+	return c, func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		delete(op.watchers, c)
+	}, nil
+}
+
+// 清理过期的操作，调用方需持有写锁
+func (o *operation) purge() {
+	for id, op := range o.ops {
+		if op.FinishedAt != nil && time.Since(*op.FinishedAt) > operationRetention {
+			delete(o.ops, id)
+		}
+	}
+}
+
+// 更新操作，并通知订阅者
+func (op *OperationStatus) update(f func()) {
+	Operation.mu.Lock()
+	defer Operation.mu.Unlock()
+	f()
+	for c := range op.watchers {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// 记录一条日志
+func (op *OperationStatus) Log(format string, args ...interface{}) {
+	op.update(func() {
+		op.Logs = append(op.Logs, &OperationLog{Time: time.Now(), Message: fmt.Sprintf(format, args...)})
+	})
+}
+
+// 设置总数
+func (op *OperationStatus) SetTotal(total int) {
+	op.update(func() {
+		op.Total = total
+	})
+}
+
+// 完成一项
+func (op *OperationStatus) Step() {
+	op.update(func() {
+		op.Done++
+	})
+}
+
+// 结束操作，err为空表示成功
+func (op *OperationStatus) Finish(err error) {
+	op.update(func() {
+		now := time.Now()
+		op.FinishedAt = &now
+		if err != nil {
+			op.Status = OperationFailed
+			op.Error = err.Error()
+			op.Logs = append(op.Logs, &OperationLog{Time: now, Message: err.Error()})
+		} else {
+			op.Status = OperationSucceeded
+		}
+	})
+}
+
+// 复制一份操作状态，调用方需持有读锁
+func (op *OperationStatus) snapshot() *OperationStatus {
+	cp := *op
+	cp.Logs = append([]*OperationLog{}, op.Logs...)
+	cp.watchers = nil
+	return &cp
+}
@@ -0,0 +1,267 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"k8s-server/utils"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+)
+
+// 集群概览，所有数据来自一次集群资源快照和一次Warning事件的List
+var Overview overview
+
+type overview struct{}
+
+// 定义ClusterOverview结构体，集群概览的返回内容
+type ClusterOverview struct {
+	//每个namespace中各类资源的数量，按namespace名排序
+	Namespaces []*NamespaceOverview `json:"namespaces"`
+	//所有namespace的合计
+	Total *NamespaceOverview `json:"total"`
+	//pod按phase统计的数量
+	PodPhases map[corev1.PodPhase]int `json:"pod_phases"`
+	Nodes     *NodeReadiness          `json:"nodes"`
+	//集群的资源分配情况，requests和limits只统计调度到node上且未结束的pod
+	Resources *NodeAllocatedResources `json:"resources"`
+	//Warning事件数量，事件默认只保留1小时
+	WarningEvents      int                  `json:"warning_events"`
+	UnhealthyWorkloads []*UnhealthyWorkload `json:"unhealthy_workloads"`
+	//数据是否来自informer缓存
+	FromCache bool `json:"from_cache"`
+}
+
+// 定义NamespaceOverview结构体，一个namespace中各类资源的数量
+type NamespaceOverview struct {
+	Namespace     string `json:"namespace"`
+	Pods          int    `json:"pods"`
+	Deployments   int    `json:"deployments"`
+	StatefulSets  int    `json:"statefulsets"`
+	DaemonSets    int    `json:"daemonsets"`
+	Jobs          int    `json:"jobs"`
+	CronJobs      int    `json:"cronjobs"`
+	WarningEvents int    `json:"warning_events"`
+}
+
+// 定义NodeReadiness结构体，node的就绪情况
+type NodeReadiness struct {
+	Total         int `json:"total"`
+	Ready         int `json:"ready"`
+	NotReady      int `json:"not_ready"`
+	Unschedulable int `json:"unschedulable"`
+}
+
+// 定义UnhealthyWorkload结构体，副本未全部就绪或运行失败的工作负载
+type UnhealthyWorkload struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Desired   int32  `json:"desired"`
+	Ready     int32  `json:"ready"`
+	Reason    string `json:"reason"`
+}
+
+// 获取集群概览
+func (o *overview) GetOverview(ctx context.Context) (data *ClusterOverview, err error) {
+	snapshot, err := Cache.Snapshot(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "获取集群概览失败")
+	}
+	eventList, err := K8sClientSet.CoreV1().Events("").List(ctx, metav1.ListOptions{
+		FieldSelector: fields.OneTermEqualSelector("type", corev1.EventTypeWarning).String(),
+	})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Event列表失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Event列表失败")
+	}
+
+	data = &ClusterOverview{
+		Total:              &NamespaceOverview{},
+		PodPhases:          map[corev1.PodPhase]int{},
+		UnhealthyWorkloads: []*UnhealthyWorkload{},
+		FromCache:          snapshot.FromCache,
+	}
+	namespaces := o.countPerNamespace(snapshot)
+	for _, event := range eventList.Items {
+		namespaces.get(event.Namespace).WarningEvents++
+	}
+	data.WarningEvents = len(eventList.Items)
+	for _, ns := range namespaces {
+		data.Namespaces = append(data.Namespaces, ns)
+		data.Total.Pods += ns.Pods
+		data.Total.Deployments += ns.Deployments
+		data.Total.StatefulSets += ns.StatefulSets
+		data.Total.DaemonSets += ns.DaemonSets
+		data.Total.Jobs += ns.Jobs
+		data.Total.CronJobs += ns.CronJobs
+		data.Total.WarningEvents += ns.WarningEvents
+	}
+	sort.Slice(data.Namespaces, func(i, j int) bool {
+		return data.Namespaces[i].Namespace < data.Namespaces[j].Namespace
+	})
+
+	for _, pod := range snapshot.Pods {
+		data.PodPhases[pod.Status.Phase]++
+	}
+	data.Nodes = nodeReadiness(snapshot.Nodes)
+	data.Resources = clusterAllocatedResources(snapshot.Nodes, snapshot.Pods)
+	data.UnhealthyWorkloads = unhealthyWorkloads(snapshot)
+	return data, nil
+}
+
+// namespaceCounts 按namespace名保存资源数量
+type namespaceCounts map[string]*NamespaceOverview
+
+// 获取namespace的统计，不存在时创建，例如namespace正在删除时其中的资源仍然存在
+func (n namespaceCounts) get(namespace string) *NamespaceOverview {
+	if _, ok := n[namespace]; !ok {
+		n[namespace] = &NamespaceOverview{Namespace: namespace}
+	}
+	return n[namespace]
+}
+
+// 统计每个namespace中各类资源的数量，没有资源的namespace数量为0
+func (o *overview) countPerNamespace(snapshot *clusterSnapshot) namespaceCounts {
+	counts := namespaceCounts{}
+	for _, ns := range snapshot.Namespaces {
+		counts.get(ns.Name)
+	}
+	for _, pod := range snapshot.Pods {
+		counts.get(pod.Namespace).Pods++
+	}
+	for _, deployment := range snapshot.Deployments {
+		counts.get(deployment.Namespace).Deployments++
+	}
+	for _, statefulSet := range snapshot.StatefulSets {
+		counts.get(statefulSet.Namespace).StatefulSets++
+	}
+	for _, daemonSet := range snapshot.DaemonSets {
+		counts.get(daemonSet.Namespace).DaemonSets++
+	}
+	for _, job := range snapshot.Jobs {
+		counts.get(job.Namespace).Jobs++
+	}
+	for _, cronJob := range snapshot.CronJobs {
+		counts.get(cronJob.Namespace).CronJobs++
+	}
+	return counts
+}
+
+// 统计node的就绪情况
+func nodeReadiness(nodes []*corev1.Node) *NodeReadiness {
+	readiness := &NodeReadiness{Total: len(nodes)}
+	for _, node := range nodes {
+		ready := false
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == corev1.NodeReady {
+				ready = condition.Status == corev1.ConditionTrue
+			}
+		}
+		if ready {
+			readiness.Ready++
+		} else {
+			readiness.NotReady++
+		}
+		if node.Spec.Unschedulable {
+			readiness.Unschedulable++
+		}
+	}
+	return readiness
+}
+
+// 统计集群的资源分配情况，allocatable为所有node之和
+func clusterAllocatedResources(nodes []*corev1.Node, pods []*corev1.Pod) *NodeAllocatedResources {
+	allocatable, capacity := corev1.ResourceList{}, corev1.ResourceList{}
+	for _, node := range nodes {
+		addResourceList(allocatable, node.Status.Allocatable)
+		addResourceList(capacity, node.Status.Capacity)
+	}
+	cluster := &corev1.Node{Status: corev1.NodeStatus{Allocatable: allocatable, Capacity: capacity}}
+	scheduled := []corev1.Pod{}
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		scheduled = append(scheduled, *pod)
+	}
+	return nodeAllocatedResources(cluster, scheduled)
+}
+
+// 找出副本未全部就绪或运行失败的工作负载
+func unhealthyWorkloads(snapshot *clusterSnapshot) []*UnhealthyWorkload {
+	workloads := []*UnhealthyWorkload{}
+	for _, d := range snapshot.Deployments {
+		if w := unhealthyDeployment(d); w != nil {
+			workloads = append(workloads, w)
+		}
+	}
+	for _, s := range snapshot.StatefulSets {
+		desired := int32(1)
+		if s.Spec.Replicas != nil {
+			desired = *s.Spec.Replicas
+		}
+		if s.Status.ReadyReplicas < desired {
+			workloads = append(workloads, &UnhealthyWorkload{
+				Kind: "StatefulSet", Namespace: s.Namespace, Name: s.Name,
+				Desired: desired, Ready: s.Status.ReadyReplicas,
+				Reason: fmt.Sprintf("%d/%d个副本就绪", s.Status.ReadyReplicas, desired),
+			})
+		}
+	}
+	for _, ds := range snapshot.DaemonSets {
+		if ds.Status.NumberReady < ds.Status.DesiredNumberScheduled {
+			workloads = append(workloads, &UnhealthyWorkload{
+				Kind: "DaemonSet", Namespace: ds.Namespace, Name: ds.Name,
+				Desired: ds.Status.DesiredNumberScheduled, Ready: ds.Status.NumberReady,
+				Reason: fmt.Sprintf("%d/%d个副本就绪", ds.Status.NumberReady, ds.Status.DesiredNumberScheduled),
+			})
+		}
+	}
+	for _, job := range snapshot.Jobs {
+		for _, condition := range job.Status.Conditions {
+			if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+				workloads = append(workloads, &UnhealthyWorkload{
+					Kind: "Job", Namespace: job.Namespace, Name: job.Name,
+					Reason: condition.Reason + ", " + condition.Message,
+				})
+			}
+		}
+	}
+	sort.Slice(workloads, func(i, j int) bool {
+		if workloads[i].Namespace != workloads[j].Namespace {
+			return workloads[i].Namespace < workloads[j].Namespace
+		}
+		return workloads[i].Name < workloads[j].Name
+	})
+	return workloads
+}
+
+// 判断deployment是否不健康，滚动更新超时或可用副本数不足时返回原因
+func unhealthyDeployment(d *appsv1.Deployment) *UnhealthyWorkload {
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	workload := &UnhealthyWorkload{
+		Kind: "Deployment", Namespace: d.Namespace, Name: d.Name,
+		Desired: desired, Ready: d.Status.AvailableReplicas,
+	}
+	for _, condition := range d.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Status == corev1.ConditionFalse {
+			workload.Reason = condition.Reason + ", " + condition.Message
+			return workload
+		}
+	}
+	if d.Status.AvailableReplicas < desired {
+		workload.Reason = fmt.Sprintf("%d/%d个副本可用", d.Status.AvailableReplicas, desired)
+		return workload
+	}
+	return nil
+}
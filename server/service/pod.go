@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"k8s-server/errcode"
 	"k8s-server/config"
 	"k8s-server/utils"
 
@@ -23,6 +24,9 @@ type pod struct{}
 type PodsResp struct {
 	Items []corev1.Pod `json:"items"`
 	Total int          `json:"total"`
+	//Usage是Items中pod的cpu、内存使用量，key为"namespace/name"，MetricsAvailable为false时表示未安装metrics-server
+	Usage            map[string]*ResourceUsage `json:"usage"`
+	MetricsAvailable bool                      `json:"metrics_available"`
 }
 
 // 定义PodsNs类型，返回namespace中pod的数量
@@ -32,12 +36,13 @@ type PodsNp struct {
 }
 
 // 获取pod列表，支持过滤、排序、分页
-func (p *pod) GetPods(filterName, namespace string, limit, page int) (podsResp *PodsResp, err error) {
+// sortBy为cpu或memory时按资源使用量倒序，否则按创建时间倒序
+func (p *pod) GetPods(ctx context.Context, filterName, namespace, sortBy string, limit, page int) (podsResp *PodsResp, err error) {
 	//获取podList类型的pod列表
-	podList, err := K8sClientSet.CoreV1().Pods(namespace).List(context.TODO(), metav1.ListOptions{})
+	podList, err := K8sClientSet.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Pod列表失败")).Msg(err.Error())
-		return nil, errors.New("获取Pod列表失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Pod列表失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Pod列表失败")
 	}
 	//实例化DataSelector对象
 	selectableData := &DataSelector{
@@ -49,6 +54,12 @@ func (p *pod) GetPods(filterName, namespace string, limit, page int) (podsResp *
 			Page:  page,
 		},
 	}
+	//获取pod的资源使用量，metrics-server不可用时使用量为空
+	podMetrics := Metrics.GetPodMetrics(ctx, namespace)
+	selectableData.SortQuery = &SortQuery{
+		SortBy: sortBy,
+		Usage:  podMetrics.Usage,
+	}
 	//先过滤
 	filtered := selectableData.Filter()
 	total := len(filtered.GenericDataList)
@@ -57,30 +68,46 @@ func (p *pod) GetPods(filterName, namespace string, limit, page int) (podsResp *
 
 	//将[]DataCell类型的pod列表转为v1.pod列表
 	pods := p.fromCells(data.GenericDataList)
+	//只返回当前页pod的使用量
+	usage := map[string]*ResourceUsage{}
+	for _, cell := range data.GenericDataList {
+		key := usageKey(cell)
+		if u, ok := podMetrics.Usage[key]; ok {
+			usage[key] = u
+		}
+	}
 
 	return &PodsResp{
-		Items: pods,
-		Total: total,
+		Items:            pods,
+		Total:            total,
+		Usage:            usage,
+		MetricsAvailable: podMetrics.Available,
 	}, nil
 }
 
 // 获取pod详情
-func (p *pod) GetPodDetail(podName, namespace string) (pod *corev1.Pod, err error) {
-	pod, err = K8sClientSet.CoreV1().Pods(namespace).Get(context.TODO(), podName, metav1.GetOptions{})
+func (p *pod) GetPodDetail(ctx context.Context, podName, namespace string) (pod *corev1.Pod, err error) {
+	pod, err = K8sClientSet.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Pod详情失败")).Msg(err.Error())
-		return nil, errors.New("获取Pod详情失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Pod详情失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Pod详情失败")
 	}
 
 	return pod, nil
 }
 
 // 删除pod
-func (p *pod) DeletePod(podName, namespace string) (err error) {
-	err = K8sClientSet.CoreV1().Pods(namespace).Delete(context.TODO(), podName, metav1.DeleteOptions{})
+func (p *pod) DeletePod(ctx context.Context, podName, namespace string) (err error) {
+	//删除前保存快照到回收站，删除失败时丢弃快照
+	item, err := Trash.Snapshot(ctx, corev1.SchemeGroupVersion.WithResource("pods"), namespace, podName)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("删除pod失败")).Msg(err.Error())
-		return errors.New("删除pod失败, " + err.Error())
+		return err
+	}
+	err = K8sClientSet.CoreV1().Pods(namespace).Delete(ctx, podName, metav1.DeleteOptions{})
+	if err != nil {
+		Trash.Discard(ctx, item)
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除pod失败")).Msg(err.Error())
+		return errors.Wrap(err, "删除pod失败")
 	}
 
 	return nil
@@ -88,27 +115,27 @@ func (p *pod) DeletePod(podName, namespace string) (err error) {
 
 // 更新pod
 // content参数是请求中传入的pod对象的json数据
-func (p *pod) UpdatePod(podName, namespace, content string) (err error) {
+func (p *pod) UpdatePod(ctx context.Context, podName, namespace, content string) (err error) {
 	var pod = &corev1.Pod{}
 	//反序列化为pod对象
 	err = json.Unmarshal([]byte(content), pod)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("反序列化失败")).Msg(err.Error())
-		return errors.New("反序列化失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("反序列化失败")).Msg(err.Error())
+		return errcode.InvalidParam.Wrapf(err, "反序列化失败")
 	}
 	//更新pod
-	_, err = K8sClientSet.CoreV1().Pods(namespace).Update(context.TODO(), pod, metav1.UpdateOptions{})
+	_, err = K8sClientSet.CoreV1().Pods(namespace).Update(ctx, pod, metav1.UpdateOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("更新Pod失败")).Msg(err.Error())
-		return errors.New("更新Pod失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Pod失败")).Msg(err.Error())
+		return errors.Wrap(err, "更新Pod失败")
 	}
 	return nil
 }
 
 // 获取pod容器
-func (p *pod) GetPodContainer(podName, namespace string) (containers []string, err error) {
+func (p *pod) GetPodContainer(ctx context.Context, podName, namespace string) (containers []string, err error) {
 	//获取pod详情
-	pod, err := p.GetPodDetail(podName, namespace)
+	pod, err := p.GetPodDetail(ctx, podName, namespace)
 	if err != nil {
 		return nil, err
 	}
@@ -121,7 +148,7 @@ func (p *pod) GetPodContainer(podName, namespace string) (containers []string, e
 }
 
 // 获取pod内容器日志
-func (p *pod) GetPodLog(containerName, podName, namespace string) (logs string, err error) {
+func (p *pod) GetPodLog(ctx context.Context, containerName, podName, namespace string) (logs string, err error) {
 	//设置日志的配置，容器名、tail的行数
 	lineLimit := int64(config.Config.GetInt("Kubenertes.podlogtailline"))
 	option := &corev1.PodLogOptions{
@@ -131,40 +158,35 @@ func (p *pod) GetPodLog(containerName, podName, namespace string) (logs string,
 	//获取request实例
 	req := K8sClientSet.CoreV1().Pods(namespace).GetLogs(podName, option)
 	//发起request请求，返回一个io.ReadCloser类型（等同于response.body）
-	podLogs, err := req.Stream(context.TODO())
+	podLogs, err := req.Stream(ctx)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取PodLog失败")).Msg(err.Error())
-		return "", errors.New("获取PodLog失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取PodLog失败")).Msg(err.Error())
+		return "", errors.Wrap(err, "获取PodLog失败")
 	}
 	defer podLogs.Close()
 	//将response body写入到缓冲区，目的是为了转成string返回
 	buf := new(bytes.Buffer)
 	_, err = io.Copy(buf, podLogs)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("复制PodLog失败")).Msg(err.Error())
-		return "", errors.New("复制PodLog失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("复制PodLog失败")).Msg(err.Error())
+		return "", errors.Wrap(err, "复制PodLog失败")
 	}
 
 	return buf.String(), nil
 }
 
-// 获取每个namespace的pod数量
-func (p *pod) GetPodNumPerNp() (podsNps []*PodsNp, err error) {
-	//获取namespace列表
-	namespaceList, err := K8sClientSet.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+// 获取每个namespace的pod数量，数据来自集群资源快照，不再逐个namespace获取pod列表
+func (p *pod) GetPodNumPerNp(ctx context.Context) (podsNps []*PodsNp, err error) {
+	snapshot, err := Cache.Snapshot(ctx)
 	if err != nil {
 		return nil, err
 	}
-	for _, namespace := range namespaceList.Items {
-		//获取pod列表
-		podList, err := K8sClientSet.CoreV1().Pods(namespace.Name).List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			return nil, err
-		}
+	counts := Overview.countPerNamespace(snapshot)
+	for _, namespace := range snapshot.Namespaces {
 		//组装数据
 		podsNp := &PodsNp{
 			Namespace: namespace.Name,
-			PodNum:    len(podList.Items),
+			PodNum:    counts.get(namespace.Name).Pods,
 		}
 		//添加到podsNps数组中
 		podsNps = append(podsNps, podsNp)
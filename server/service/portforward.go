@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"io"
+	"k8s-server/config"
+	"k8s-server/errcode"
+	"k8s-server/utils"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+var PortForward portForward
+
+type portForward struct{}
+
+// port-forward websocket使用的子协议，命令行客户端可以直接设置Authorization header
+const portForwardSubprotocol = "k8s-portforward"
+
+// port-forward使用单独的upgrader，数据以二进制消息传输
+var portForwardUpgrader = websocket.Upgrader{
+	HandshakeTimeout: time.Second * 2,
+	CheckOrigin:      checkOrigin,
+	Subprotocols:     []string{portForwardSubprotocol},
+	ReadBufferSize:   32 * 1024,
+	WriteBufferSize:  32 * 1024,
+}
+
+// 定义websocket的handler方法，每个websocket连接对应pod端口上的一个TCP连接
+// websocket中的二进制消息原样转发到pod端口，pod端口返回的数据也以二进制消息写回
+func (p *portForward) WsHandler(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		return
+	}
+	namespace := r.Form.Get("namespace")
+	podName := r.Form.Get("pod_name")
+	port, err := strconv.Atoi(r.Form.Get("port"))
+	if err != nil || port <= 0 || port > 65535 {
+		http.Error(w, "端口不合法", http.StatusBadRequest)
+		return
+	}
+	//鉴权和授权都在升级websocket之前完成，失败时直接返回http错误码
+	username, err := wsUser(r)
+	if err != nil {
+		utils.Log(r.Context()).Info().Str("pod", podName).Str("ip", r.RemoteAddr).Msg("port-forward鉴权失败, " + err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := p.authorize(r.Context(), namespace, podName, port); err != nil {
+		utils.Log(r.Context()).Info().Str("user", username).Str("namespace", namespace).Str("pod", podName).Int("port", port).Msg("port-forward授权失败, " + err.Error())
+		http.Error(w, err.Error(), errcode.From(err).Status)
+		return
+	}
+
+	streamConn, err := p.dial(r.Context(), namespace, podName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer streamConn.Close()
+
+	wsConn, err := portForwardUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		utils.Log(r.Context()).Error().Stack().Err(errors.New("升级websocket失败")).Msg(err.Error())
+		return
+	}
+	defer wsConn.Close()
+
+	start := time.Now()
+	utils.Log(r.Context()).Info().Str("user", username).Str("namespace", namespace).Str("pod", podName).Int("port", port).Str("ip", r.RemoteAddr).Msg("port-forward连接建立")
+	sent, received, err := p.tunnel(wsConn, streamConn, port)
+	event := utils.Log(r.Context()).Info()
+	if err != nil {
+		event = utils.Log(r.Context()).Error().Err(err)
+	}
+	event.Str("user", username).Str("namespace", namespace).Str("pod", podName).Int("port", port).Str("ip", r.RemoteAddr).
+		Int64("bytes_sent", sent).Int64("bytes_received", received).Dur("duration", time.Since(start)).
+		Msg("port-forward连接关闭")
+}
+
+// 授权检查：pod必须处于Running状态，namespace和端口需要在配置的允许列表中(未配置则不限制)
+func (p *portForward) authorize(ctx context.Context, namespace, podName string, port int) error {
+	if !config.Config.GetBool("PortForward.enabled") {
+		return errcode.Forbidden.New("port-forward功能未开启")
+	}
+	if allowed := config.Config.GetStringSlice("PortForward.allowednamespaces"); len(allowed) > 0 && !containsString(allowed, namespace) {
+		return errcode.Forbidden.New("不允许对该namespace进行port-forward: " + namespace)
+	}
+	if allowed := config.Config.GetIntSlice("PortForward.allowedports"); len(allowed) > 0 && !containsInt(allowed, port) {
+		return errcode.Forbidden.Newf("不允许对该端口进行port-forward: %d", port)
+	}
+	pod, err := K8sClientSet.CoreV1().Pods(namespace).Get(ctx, podName, metav1.GetOptions{})
+	if err != nil {
+		return errors.Wrap(err, "获取Pod详情失败")
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		return errcode.Conflict.New("Pod未处于Running状态: " + string(pod.Status.Phase))
+	}
+	return nil
+}
+
+// 与apiserver建立pods/portforward的SPDY连接
+func (p *portForward) dial(ctx context.Context, namespace, podName string) (httpstream.Connection, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(K8sRestConfig)
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("创建SPDY RoundTripper失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "创建SPDY RoundTripper失败")
+	}
+	req := K8sClientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(podName).
+		SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
+	streamConn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("建立port-forward连接失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "建立port-forward连接失败")
+	}
+	return streamConn, nil
+}
+
+// 在websocket和pod端口之间双向转发数据，返回发送和接收的字节数
+// 与client-go的portforward实现一致，每个TCP连接需要创建一个error stream和一个data stream
+func (p *portForward) tunnel(wsConn *websocket.Conn, streamConn httpstream.Connection, port int) (sent, received int64, err error) {
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, strconv.Itoa(port))
+	headers.Set(corev1.PortForwardRequestIDHeader, "0")
+	errorStream, err := streamConn.CreateStream(headers)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "创建error stream失败")
+	}
+	//error stream只读不写
+	errorStream.Close()
+	defer streamConn.RemoveStreams(errorStream)
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := streamConn.CreateStream(headers)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "创建data stream失败")
+	}
+	defer streamConn.RemoveStreams(dataStream)
+
+	var (
+		sentBytes, receivedBytes atomic.Int64
+		once                     sync.Once
+		tunnelErr                error
+		done                     = make(chan struct{})
+	)
+	finish := func(e error) {
+		once.Do(func() {
+			tunnelErr = e
+			close(done)
+		})
+	}
+
+	//pod端口返回的错误，例如端口未监听
+	go func() {
+		message, err := io.ReadAll(errorStream)
+		switch {
+		case err != nil:
+			finish(errors.Wrap(err, "读取error stream失败"))
+		case len(message) > 0:
+			finish(errors.New("port-forward错误, " + string(message)))
+		}
+	}()
+
+	//websocket -> pod
+	go func() {
+		for {
+			messageType, data, err := wsConn.ReadMessage()
+			if err != nil {
+				//客户端关闭连接是正常结束
+				finish(nil)
+				return
+			}
+			if messageType != websocket.BinaryMessage {
+				continue
+			}
+			if _, err := dataStream.Write(data); err != nil {
+				finish(errors.Wrap(err, "写入pod失败"))
+				return
+			}
+			sentBytes.Add(int64(len(data)))
+		}
+	}()
+
+	//pod -> websocket
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := dataStream.Read(buf)
+			if n > 0 {
+				if werr := wsConn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					finish(nil)
+					return
+				}
+				receivedBytes.Add(int64(n))
+			}
+			if err != nil {
+				if err != io.EOF {
+					finish(errors.Wrap(err, "读取pod数据失败"))
+					return
+				}
+				//pod端关闭连接，通知客户端
+				wsConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "remote closed"), time.Now().Add(time.Second))
+				finish(nil)
+				return
+			}
+		}
+	}()
+
+	<-done
+	return sentBytes.Load(), receivedBytes.Load(), tunnelErr
+}
+
+// 判断字符串是否在列表中
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断整数是否在列表中
+func containsInt(list []int, i int) bool {
+	for _, item := range list {
+		if item == i {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// k8s client请求、终端会话和informer缓存的prometheus指标
+var (
+	k8sRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "k8s_server_kube_requests_total",
+		Help: "k8s apiserver请求数，code为apiserver返回的状态码，请求失败时为error",
+	}, []string{"verb", "resource", "code"})
+	k8sRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "k8s_server_kube_request_duration_seconds",
+		Help:    "k8s apiserver请求耗时，watch和exec等长连接只统计到返回响应头",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"verb", "resource"})
+	_ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "k8s_server_terminal_sessions",
+		Help: "当前打开的终端会话数，包括调试容器的会话",
+	}, func() float64 {
+		return float64(terminalSessions.total())
+	})
+	cacheSyncedDesc = prometheus.NewDesc("k8s_server_cache_synced",
+		"informer缓存是否同步完成，1为已同步，缓存未开启时没有数据", []string{"resource"}, nil)
+)
+
+func init() {
+	prometheus.MustRegister(cacheSyncCollector{})
+}
+
+// cacheSyncCollector 在采集时读取每种资源的informer同步状态
+type cacheSyncCollector struct{}
+
+func (cacheSyncCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- cacheSyncedDesc
+}
+
+func (cacheSyncCollector) Collect(ch chan<- prometheus.Metric) {
+	for resource, synced := range Cache.Status() {
+		value := 0.0
+		if synced {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(cacheSyncedDesc, prometheus.GaugeValue, value, resource)
+	}
+}
+
+// instrumentedTransport 包装k8s client的http.RoundTripper，记录每个请求的verb、resource、状态码和耗时
+type instrumentedTransport struct {
+	rt http.RoundTripper
+}
+
+// 用于rest.Config.Wrap，需要在创建clientSet之前调用
+func instrumentTransport(rt http.RoundTripper) http.RoundTripper {
+	return &instrumentedTransport{rt: rt}
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.rt.RoundTrip(req)
+	verb, resource := parseK8sRequest(req)
+	k8sRequestDuration.WithLabelValues(verb, resource).Observe(time.Since(start).Seconds())
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	k8sRequestsTotal.WithLabelValues(verb, resource, code).Inc()
+	return resp, err
+}
+
+// 从请求中解析k8s的verb和resource，resource带上子资源，例如pods/exec
+// 路径格式为 /api/v1/[namespaces/{ns}/]{resource}[/{name}[/{subresource}]]
+// 或 /apis/{group}/{version}/[namespaces/{ns}/]{resource}[/{name}[/{subresource}]]
+func parseK8sRequest(req *http.Request) (verb, resource string) {
+	parts := strings.Split(strings.Trim(req.URL.Path, "/"), "/")
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return strings.ToLower(req.Method), "other"
+	}
+	//namespaces/{ns}/{resource}...，只有namespaces/{ns}时resource就是namespaces
+	if len(parts) >= 3 && parts[0] == "namespaces" {
+		parts = parts[2:]
+	}
+	if len(parts) == 0 {
+		return strings.ToLower(req.Method), "other"
+	}
+	resource = parts[0]
+	hasName := len(parts) >= 2
+	if len(parts) >= 3 {
+		resource += "/" + parts[2]
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		switch {
+		case req.URL.Query().Get("watch") == "true":
+			verb = "watch"
+		case hasName:
+			verb = "get"
+		default:
+			verb = "list"
+		}
+	case http.MethodPost:
+		verb = "create"
+	case http.MethodPut:
+		verb = "update"
+	case http.MethodPatch:
+		verb = "patch"
+	case http.MethodDelete:
+		if hasName {
+			verb = "delete"
+		} else {
+			verb = "deletecollection"
+		}
+	default:
+		verb = strings.ToLower(req.Method)
+	}
+	return verb, resource
+}
@@ -20,12 +20,12 @@ type PvsResp struct {
 }
 
 // 获取pv列表，支持过滤、排序、分页
-func (p *pv) GetPvs(filterName string, limit, page int) (pvsResp *PvsResp, err error) {
+func (p *pv) GetPvs(ctx context.Context, filterName string, limit, page int) (pvsResp *PvsResp, err error) {
 	//获取pvList类型的pv列表
-	pvList, err := K8sClientSet.CoreV1().PersistentVolumes().List(context.TODO(), metav1.ListOptions{})
+	pvList, err := K8sClientSet.CoreV1().PersistentVolumes().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Pv列表失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Pv列表失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Pv列表失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Pv列表失败")
 	}
 	//将pvList中的pv列表(Items)，放进dataselector对象中，进行排序
 	selectableData := &DataSelector{
@@ -52,22 +52,28 @@ func (p *pv) GetPvs(filterName string, limit, page int) (pvsResp *PvsResp, err e
 }
 
 // 获取pv详情
-func (p *pv) GetPvDetail(pvName string) (pv *corev1.PersistentVolume, err error) {
-	pv, err = K8sClientSet.CoreV1().PersistentVolumes().Get(context.TODO(), pvName, metav1.GetOptions{})
+func (p *pv) GetPvDetail(ctx context.Context, pvName string) (pv *corev1.PersistentVolume, err error) {
+	pv, err = K8sClientSet.CoreV1().PersistentVolumes().Get(ctx, pvName, metav1.GetOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Pv详情失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Pv详情失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Pv详情失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Pv详情失败")
 	}
 
 	return pv, nil
 }
 
 // 删除pv
-func (p *pv) DeletePv(pvName string) (err error) {
-	err = K8sClientSet.CoreV1().PersistentVolumes().Delete(context.TODO(), pvName, metav1.DeleteOptions{})
+func (p *pv) DeletePv(ctx context.Context, pvName string) (err error) {
+	//删除前保存快照到回收站，删除失败时丢弃快照
+	item, err := Trash.Snapshot(ctx, corev1.SchemeGroupVersion.WithResource("persistentvolumes"), "", pvName)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("删除Pv失败, ")).Msg(err.Error())
-		return errors.New("删除Pv失败, " + err.Error())
+		return err
+	}
+	err = K8sClientSet.CoreV1().PersistentVolumes().Delete(ctx, pvName, metav1.DeleteOptions{})
+	if err != nil {
+		Trash.Discard(ctx, item)
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除Pv失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "删除Pv失败")
 	}
 
 	return nil
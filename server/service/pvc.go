@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"k8s-server/errcode"
 	"k8s-server/utils"
 
 	"github.com/pkg/errors"
@@ -21,12 +22,12 @@ type PvcsResp struct {
 }
 
 // 获取pvc列表，支持过滤、排序、分页
-func (p *pvc) GetPvcs(filterName, namespace string, limit, page int) (pvcsResp *PvcsResp, err error) {
+func (p *pvc) GetPvcs(ctx context.Context, filterName, namespace string, limit, page int) (pvcsResp *PvcsResp, err error) {
 	//获取pvcList类型的pvc列表
-	pvcList, err := K8sClientSet.CoreV1().PersistentVolumeClaims(namespace).List(context.TODO(), metav1.ListOptions{})
+	pvcList, err := K8sClientSet.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Pvc列表失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Pvc列表失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Pvc列表失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Pvc列表失败")
 	}
 	//将pvcList中的pvc列表(Items)，放进dataselector对象中，进行排序
 	selectableData := &DataSelector{
@@ -53,41 +54,47 @@ func (p *pvc) GetPvcs(filterName, namespace string, limit, page int) (pvcsResp *
 }
 
 // 获取pvc详情
-func (p *pvc) GetPvcDetail(pvcName, namespace string) (pvc *corev1.PersistentVolumeClaim, err error) {
-	pvc, err = K8sClientSet.CoreV1().PersistentVolumeClaims(namespace).Get(context.TODO(), pvcName, metav1.GetOptions{})
+func (p *pvc) GetPvcDetail(ctx context.Context, pvcName, namespace string) (pvc *corev1.PersistentVolumeClaim, err error) {
+	pvc, err = K8sClientSet.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, pvcName, metav1.GetOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Pvc详情失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Pvc详情失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Pvc详情失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Pvc详情失败")
 	}
 
 	return pvc, nil
 }
 
 // 删除pvc
-func (p *pvc) DeletePvc(pvcName, namespace string) (err error) {
-	err = K8sClientSet.CoreV1().PersistentVolumeClaims(namespace).Delete(context.TODO(), pvcName, metav1.DeleteOptions{})
+func (p *pvc) DeletePvc(ctx context.Context, pvcName, namespace string) (err error) {
+	//删除前保存快照到回收站，删除失败时丢弃快照
+	item, err := Trash.Snapshot(ctx, corev1.SchemeGroupVersion.WithResource("persistentvolumeclaims"), namespace, pvcName)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("删除Pvc失败, ")).Msg(err.Error())
-		return errors.New("删除Pvc失败, " + err.Error())
+		return err
+	}
+	err = K8sClientSet.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, pvcName, metav1.DeleteOptions{})
+	if err != nil {
+		Trash.Discard(ctx, item)
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除Pvc失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "删除Pvc失败")
 	}
 
 	return nil
 }
 
 // 更新pvc
-func (p *pvc) UpdatePvc(namespace, content string) (err error) {
+func (p *pvc) UpdatePvc(ctx context.Context, namespace, content string) (err error) {
 	var pvc = &corev1.PersistentVolumeClaim{}
 
 	err = json.Unmarshal([]byte(content), pvc)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("反序列化失败, ")).Msg(err.Error())
-		return errors.New("反序列化失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("反序列化失败, ")).Msg(err.Error())
+		return errcode.InvalidParam.Wrapf(err, "反序列化失败")
 	}
 
-	_, err = K8sClientSet.CoreV1().PersistentVolumeClaims(namespace).Update(context.TODO(), pvc, metav1.UpdateOptions{})
+	_, err = K8sClientSet.CoreV1().PersistentVolumeClaims(namespace).Update(ctx, pvc, metav1.UpdateOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("更新Pvc失败, ")).Msg(err.Error())
-		return errors.New("更新Pvc失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Pvc失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "更新Pvc失败")
 	}
 	return nil
 }
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8s-server/config"
+	"k8s-server/dao"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+var Recorder recorder
+
+type recorder struct{}
+
+// asciicast v2格式的文件头，格式说明见 https://docs.asciinema.org/manual/asciicast/v2/
+type castHeader struct {
+	Version   int               `json:"version"`
+	Width     uint16            `json:"width"`
+	Height    uint16            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// 录像的事件类型，o为输出，i为输入，r为终端大小变化
+const (
+	castEventOutput = "o"
+	castEventInput  = "i"
+	castEventResize = "r"
+)
+
+// 默认的终端宽高，web端连接后会立即发送resize消息
+const (
+	defaultCastWidth  = 80
+	defaultCastHeight = 24
+)
+
+// TerminalRecorder 将一个终端会话的输入、输出和resize事件写入asciicast v2文件
+// 每行一个事件，格式为 [相对开始时间(秒), 事件类型, 数据]
+type TerminalRecorder struct {
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	start  time.Time
+	record *model.TerminalRecord
+	closed bool
+}
+
+// 是否开启终端录像
+func (r *recorder) Enabled() bool {
+	return config.Config.GetBool("Terminal.record")
+}
+
+// 录像文件的根目录，可以是本地磁盘目录，也可以是挂载的对象存储目录(s3fs、ossfs等)
+func (r *recorder) dir() string {
+	dir := config.Config.GetString("Terminal.recorddir")
+	if dir == "" {
+		dir = "records"
+	}
+	return dir
+}
+
+// 开始录像，创建录像文件并在数据库中写入索引
+func (r *recorder) Start(ctx context.Context, username, namespace, podName, containerName, shell string) (tr *TerminalRecorder, err error) {
+	//namespace、pod和container来自请求参数，校验后才能拼接到文件路径中
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return nil, errcode.InvalidParam.Newf("命名空间不合法: %s, %s", namespace, strings.Join(errs, "; "))
+	}
+	if errs := validation.IsDNS1123Subdomain(podName); len(errs) > 0 {
+		return nil, errcode.InvalidParam.Newf("Pod名不合法: %s, %s", podName, strings.Join(errs, "; "))
+	}
+	if containerName != "" {
+		if errs := validation.IsDNS1123Label(containerName); len(errs) > 0 {
+			return nil, errcode.InvalidParam.Newf("容器名不合法: %s, %s", containerName, strings.Join(errs, "; "))
+		}
+	}
+	now := time.Now()
+	//按日期分目录，文件名包含namespace、pod、container和时间，方便在对象存储中按前缀查找
+	relPath := filepath.Join(
+		now.Format("2006"), now.Format("01"), now.Format("02"),
+		fmt.Sprintf("%s_%s_%s_%d.cast", namespace, podName, containerName, now.UnixNano()),
+	)
+	fullPath := filepath.Join(r.dir(), relPath)
+	//与GetFile相同，防止路径跳出录像目录
+	if !strings.HasPrefix(fullPath, filepath.Clean(r.dir())+string(os.PathSeparator)) {
+		return nil, errcode.InvalidParam.New("录像文件路径不合法")
+	}
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("创建录像目录失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "创建录像目录失败")
+	}
+	file, err := os.OpenFile(fullPath, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0644)
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("创建录像文件失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "创建录像文件失败")
+	}
+	tr = &TerminalRecorder{
+		file:   file,
+		writer: bufio.NewWriter(file),
+		start:  now,
+		record: &model.TerminalRecord{
+			UserName:  username,
+			Namespace: namespace,
+			Pod:       podName,
+			Container: containerName,
+			Shell:     shell,
+			FilePath:  relPath,
+			StartedAt: &now,
+		},
+	}
+	header, _ := json.Marshal(castHeader{
+		Version:   2,
+		Width:     defaultCastWidth,
+		Height:    defaultCastHeight,
+		Timestamp: now.Unix(),
+		Title:     fmt.Sprintf("%s/%s/%s", namespace, podName, containerName),
+		Env:       map[string]string{"TERM": "xterm", "SHELL": shell},
+	})
+	tr.writer.Write(header)
+	tr.writer.WriteByte('\n')
+
+	if err := dao.TerminalRecord.Add(ctx, tr.record); err != nil {
+		file.Close()
+		os.Remove(fullPath)
+		return nil, err
+	}
+	return tr, nil
+}
+
+// 获取录像文件的绝对路径，用于回放
+func (r *recorder) GetFile(ctx context.Context, id int) (record *model.TerminalRecord, path string, err error) {
+	record, err = dao.TerminalRecord.GetById(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	//防止数据库中的路径跳出录像目录
+	path = filepath.Join(r.dir(), filepath.Clean("/"+record.FilePath))
+	if !strings.HasPrefix(path, filepath.Clean(r.dir())+string(os.PathSeparator)) {
+		return nil, "", errcode.InvalidParam.New("录像文件路径不合法")
+	}
+	if _, err := os.Stat(path); err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("录像文件不存在")).Msg(err.Error())
+		return nil, "", errors.Wrap(err, "录像文件不存在")
+	}
+	return record, path, nil
+}
+
+// 获取录像列表
+func (r *recorder) GetList(ctx context.Context, username, namespace, pod string, page, limit int) (data *dao.TerminalRecordResp, err error) {
+	return dao.TerminalRecord.GetList(ctx, username, namespace, pod, page, limit)
+}
+
+// 记录一个事件
+func (t *TerminalRecorder) writeEvent(eventType, data string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return
+	}
+	elapsed := time.Since(t.start).Seconds()
+	line, err := json.Marshal([]interface{}{elapsed, eventType, data})
+	if err != nil {
+		return
+	}
+	t.writer.Write(line)
+	t.writer.WriteByte('\n')
+}
+
+// 记录终端输出
+func (t *TerminalRecorder) Output(data string) {
+	t.writeEvent(castEventOutput, data)
+}
+
+// 记录终端输入
+func (t *TerminalRecorder) Input(data string) {
+	t.writeEvent(castEventInput, data)
+}
+
+// 记录终端大小变化，格式为 "列x行"
+func (t *TerminalRecorder) Resize(cols, rows uint16) {
+	t.writeEvent(castEventResize, fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// 结束录像，关闭文件并更新数据库中的时长和文件大小
+func (t *TerminalRecorder) Close() error {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.closed = true
+	t.writer.Flush()
+	var size int64
+	if info, err := t.file.Stat(); err == nil {
+		size = info.Size()
+	}
+	t.file.Close()
+	t.mu.Unlock()
+
+	end := time.Now()
+	t.record.EndedAt = &end
+	t.record.Duration = end.Sub(t.start).Seconds()
+	t.record.Size = size
+	//会话结束时请求的context已经取消，这里不使用请求的context
+	return dao.TerminalRecord.Update(context.Background(), t.record)
+}
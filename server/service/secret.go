@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"k8s-server/errcode"
 	"k8s-server/utils"
 
 	"github.com/pkg/errors"
@@ -21,12 +22,12 @@ type SecretsResp struct {
 }
 
 // 获取secret列表，支持过滤、排序、分页
-func (s *secret) GetSecrets(filterName, namespace string, limit, page int) (secretsResp *SecretsResp, err error) {
+func (s *secret) GetSecrets(ctx context.Context, filterName, namespace string, limit, page int) (secretsResp *SecretsResp, err error) {
 	//获取secretList类型的secret列表
-	secretList, err := K8sClientSet.CoreV1().Secrets(namespace).List(context.TODO(), metav1.ListOptions{})
+	secretList, err := K8sClientSet.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Secret列表失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Secret列表失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Secret列表失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Secret列表失败")
 	}
 	//将secretList中的secret列表(Items)，放进dataselector对象中，进行排序
 	selectableData := &DataSelector{
@@ -53,41 +54,47 @@ func (s *secret) GetSecrets(filterName, namespace string, limit, page int) (secr
 }
 
 // 获取secret详情
-func (s *secret) GetSecretDetail(secretName, namespace string) (secret *corev1.Secret, err error) {
-	secret, err = K8sClientSet.CoreV1().Secrets(namespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+func (s *secret) GetSecretDetail(ctx context.Context, secretName, namespace string) (secret *corev1.Secret, err error) {
+	secret, err = K8sClientSet.CoreV1().Secrets(namespace).Get(ctx, secretName, metav1.GetOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Secret详情失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Secret详情失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Secret详情失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Secret详情失败")
 	}
 
 	return secret, nil
 }
 
 // 删除secret
-func (s *secret) DeleteSecret(secretName, namespace string) (err error) {
-	err = K8sClientSet.CoreV1().Secrets(namespace).Delete(context.TODO(), secretName, metav1.DeleteOptions{})
+func (s *secret) DeleteSecret(ctx context.Context, secretName, namespace string) (err error) {
+	//删除前保存快照到回收站，删除失败时丢弃快照
+	item, err := Trash.Snapshot(ctx, corev1.SchemeGroupVersion.WithResource("secrets"), namespace, secretName)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("删除Secret失败, ")).Msg(err.Error())
-		return errors.New("删除Secret失败, " + err.Error())
+		return err
+	}
+	err = K8sClientSet.CoreV1().Secrets(namespace).Delete(ctx, secretName, metav1.DeleteOptions{})
+	if err != nil {
+		Trash.Discard(ctx, item)
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除Secret失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "删除Secret失败")
 	}
 
 	return nil
 }
 
 // 更新secret
-func (s *secret) UpdateSecret(namespace, content string) (err error) {
+func (s *secret) UpdateSecret(ctx context.Context, namespace, content string) (err error) {
 	var secret = &corev1.Secret{}
 
 	err = json.Unmarshal([]byte(content), secret)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("反序列化失败, ")).Msg(err.Error())
-		return errors.New("反序列化失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("反序列化失败, ")).Msg(err.Error())
+		return errcode.InvalidParam.Wrapf(err, "反序列化失败")
 	}
 
-	_, err = K8sClientSet.CoreV1().Secrets(namespace).Update(context.TODO(), secret, metav1.UpdateOptions{})
+	_, err = K8sClientSet.CoreV1().Secrets(namespace).Update(ctx, secret, metav1.UpdateOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("更新Secret失败, ")).Msg(err.Error())
-		return errors.New("更新Secret失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Secret失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "更新Secret失败")
 	}
 	return nil
 }
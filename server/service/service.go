@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"k8s-server/errcode"
 	"k8s-server/utils"
 
 	"github.com/pkg/errors"
@@ -32,12 +33,12 @@ type ServiceCreate struct {
 }
 
 // 获取service列表，支持过滤、排序、分页
-func (s *servicev1) GetServices(filterName, namespace string, limit, page int) (servicesResp *ServicesResp, err error) {
+func (s *servicev1) GetServices(ctx context.Context, filterName, namespace string, limit, page int) (servicesResp *ServicesResp, err error) {
 	//获取serviceList类型的service列表
-	serviceList, err := K8sClientSet.CoreV1().Services(namespace).List(context.TODO(), metav1.ListOptions{})
+	serviceList, err := K8sClientSet.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Service列表失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Service列表失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Service列表失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Service列表失败")
 	}
 	//将serviceList中的service列表(Items)，放进dataselector对象中，进行排序
 	selectableData := &DataSelector{
@@ -64,19 +65,32 @@ func (s *servicev1) GetServices(filterName, namespace string, limit, page int) (
 }
 
 // 获取service详情
-func (s *servicev1) GetServicetDetail(serviceName, namespace string) (service *corev1.Service, err error) {
-	service, err = K8sClientSet.CoreV1().Services(namespace).Get(context.TODO(), serviceName, metav1.GetOptions{})
+func (s *servicev1) GetServicetDetail(ctx context.Context, serviceName, namespace string) (service *corev1.Service, err error) {
+	service, err = K8sClientSet.CoreV1().Services(namespace).Get(ctx, serviceName, metav1.GetOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取Service详情失败, ")).Msg(err.Error())
-		return nil, errors.New("获取Service详情失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Service详情失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Service详情失败")
 	}
 
 	return service, nil
 }
 
 // 创建service,,接收ServiceCreate对象
-func (s *servicev1) CreateService(data *ServiceCreate) (err error) {
+func (s *servicev1) CreateService(ctx context.Context, data *ServiceCreate) (err error) {
 	//将data中的数据组装成corev1.Service对象
+	service := buildService(data)
+	//创建Service
+	_, err = K8sClientSet.CoreV1().Services(data.Namespace).Create(ctx, service, metav1.CreateOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("创建Service失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "创建Service失败")
+	}
+
+	return nil
+}
+
+// 将ServiceCreate组装成corev1.Service对象，创建service和生成workflow的manifest时使用
+func buildService(data *ServiceCreate) *corev1.Service {
 	service := &corev1.Service{
 		//ObjectMeta中定义资源名、命名空间以及标签
 		ObjectMeta: metav1.ObjectMeta{
@@ -105,41 +119,40 @@ func (s *servicev1) CreateService(data *ServiceCreate) (err error) {
 	if data.NodePort != 0 && data.Type == "NodePort" {
 		service.Spec.Ports[0].NodePort = data.NodePort
 	}
-	//创建Service
-	_, err = K8sClientSet.CoreV1().Services(data.Namespace).Create(context.TODO(), service, metav1.CreateOptions{})
-	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("创建Service失败, ")).Msg(err.Error())
-		return errors.New("创建Service失败, " + err.Error())
-	}
-
-	return nil
+	return service
 }
 
 // 删除service
-func (s *servicev1) DeleteService(serviceName, namespace string) (err error) {
-	err = K8sClientSet.CoreV1().Services(namespace).Delete(context.TODO(), serviceName, metav1.DeleteOptions{})
+func (s *servicev1) DeleteService(ctx context.Context, serviceName, namespace string) (err error) {
+	//删除前保存快照到回收站，删除失败时丢弃快照
+	item, err := Trash.Snapshot(ctx, corev1.SchemeGroupVersion.WithResource("services"), namespace, serviceName)
+	if err != nil {
+		return err
+	}
+	err = K8sClientSet.CoreV1().Services(namespace).Delete(ctx, serviceName, metav1.DeleteOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("删除Service失败, ")).Msg(err.Error())
-		return errors.New("删除Service失败, " + err.Error())
+		Trash.Discard(ctx, item)
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除Service失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "删除Service失败")
 	}
 
 	return nil
 }
 
 // 更新service
-func (s *servicev1) UpdateService(namespace, content string) (err error) {
+func (s *servicev1) UpdateService(ctx context.Context, namespace, content string) (err error) {
 	var service = &corev1.Service{}
 
 	err = json.Unmarshal([]byte(content), service)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("反序列化失败, ")).Msg(err.Error())
-		return errors.New("反序列化失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("反序列化失败, ")).Msg(err.Error())
+		return errcode.InvalidParam.Wrapf(err, "反序列化失败")
 	}
 
-	_, err = K8sClientSet.CoreV1().Services(namespace).Update(context.TODO(), service, metav1.UpdateOptions{})
+	_, err = K8sClientSet.CoreV1().Services(namespace).Update(ctx, service, metav1.UpdateOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("更新service失败, ")).Msg(err.Error())
-		return errors.New("更新service失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新service失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "更新service失败")
 	}
 	return nil
 }
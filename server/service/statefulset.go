@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"k8s-server/errcode"
 	"k8s-server/utils"
 
 	"github.com/pkg/errors"
@@ -21,12 +22,12 @@ type StatusfulSetsResp struct {
 }
 
 // 获取statefulset列表，支持过滤、排序、分页
-func (s *statefulSet) GetStatefulSets(filterName, namespace string, limit, page int) (statusfulSetsResp *StatusfulSetsResp, err error) {
+func (s *statefulSet) GetStatefulSets(ctx context.Context, filterName, namespace string, limit, page int) (statusfulSetsResp *StatusfulSetsResp, err error) {
 	//获取statefulSetList类型的statefulSet列表
-	statefulSetList, err := K8sClientSet.AppsV1().StatefulSets(namespace).List(context.TODO(), metav1.ListOptions{})
+	statefulSetList, err := K8sClientSet.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取StatefulSet列表失败, ")).Msg(err.Error())
-		return nil, errors.New("获取StatefulSet列表失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取StatefulSet列表失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取StatefulSet列表失败")
 	}
 	//将statefulSetList中的StatefulSet列表(Items)，放进dataselector对象中，进行排序
 	selectableData := &DataSelector{
@@ -53,41 +54,47 @@ func (s *statefulSet) GetStatefulSets(filterName, namespace string, limit, page
 }
 
 // 获取statefulset详情
-func (s *statefulSet) GetStatefulSetDetail(statefulSetName, namespace string) (statefulSet *appsv1.StatefulSet, err error) {
-	statefulSet, err = K8sClientSet.AppsV1().StatefulSets(namespace).Get(context.TODO(), statefulSetName, metav1.GetOptions{})
+func (s *statefulSet) GetStatefulSetDetail(ctx context.Context, statefulSetName, namespace string) (statefulSet *appsv1.StatefulSet, err error) {
+	statefulSet, err = K8sClientSet.AppsV1().StatefulSets(namespace).Get(ctx, statefulSetName, metav1.GetOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("获取StatefulSet详情失败, ")).Msg(err.Error())
-		return nil, errors.New("获取StatefulSet详情失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取StatefulSet详情失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取StatefulSet详情失败")
 	}
 
 	return statefulSet, nil
 }
 
 // 删除statefulset
-func (s *statefulSet) DeleteStatefulSet(statefulSetName, namespace string) (err error) {
-	err = K8sClientSet.AppsV1().StatefulSets(namespace).Delete(context.TODO(), statefulSetName, metav1.DeleteOptions{})
+func (s *statefulSet) DeleteStatefulSet(ctx context.Context, statefulSetName, namespace string) (err error) {
+	//删除前保存快照到回收站，删除失败时丢弃快照
+	item, err := Trash.Snapshot(ctx, appsv1.SchemeGroupVersion.WithResource("statefulsets"), namespace, statefulSetName)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("删除StatefulSet失败, ")).Msg(err.Error())
-		return errors.New("删除StatefulSet失败, " + err.Error())
+		return err
+	}
+	err = K8sClientSet.AppsV1().StatefulSets(namespace).Delete(ctx, statefulSetName, metav1.DeleteOptions{})
+	if err != nil {
+		Trash.Discard(ctx, item)
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除StatefulSet失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "删除StatefulSet失败")
 	}
 
 	return nil
 }
 
 // 更新statefulset
-func (s *statefulSet) UpdateStatefulSet(namespace, content string) (err error) {
+func (s *statefulSet) UpdateStatefulSet(ctx context.Context, namespace, content string) (err error) {
 	var statefulSet = &appsv1.StatefulSet{}
 
 	err = json.Unmarshal([]byte(content), statefulSet)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("反序列化失败, ")).Msg(err.Error())
-		return errors.New("反序列化失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("反序列化失败, ")).Msg(err.Error())
+		return errcode.InvalidParam.Wrapf(err, "反序列化失败")
 	}
 
-	_, err = K8sClientSet.AppsV1().StatefulSets(namespace).Update(context.TODO(), statefulSet, metav1.UpdateOptions{})
+	_, err = K8sClientSet.AppsV1().StatefulSets(namespace).Update(ctx, statefulSet, metav1.UpdateOptions{})
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("更新StatefulSet失败, ")).Msg(err.Error())
-		return errors.New("更新StatefulSet失败, " + err.Error())
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新StatefulSet失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "更新StatefulSet失败")
 	}
 	return nil
 }
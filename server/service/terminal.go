@@ -1,11 +1,18 @@
 package service
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"k8s-server/config"
+	"k8s-server/errcode"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 	"github.com/pkg/errors"
 	"k8s-server/utils"
@@ -13,40 +20,186 @@ import (
 	"github.com/gorilla/websocket"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes/scheme"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/tools/remotecommand"
+	utilexec "k8s.io/client-go/util/exec"
 )
 
 var Terminal terminal
 
 type terminal struct{}
 
+// 可选的shell列表，未指定shell或指定的shell在容器中不存在时，按顺序回退
+var validShells = []string{"bash", "sh"}
+
+// 定义ExecResult结构体，用于返回一次性执行命令的结果
+type ExecResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exit_code"`
+	TimedOut bool   `json:"timed_out"`
+}
+
+// 终端websocket使用的子协议
+// 浏览器无法为websocket设置header，可以通过 new WebSocket(url, ["k8s-terminal", token]) 的方式传递token
+const terminalSubprotocol = "k8s-terminal"
+
+// websocket关闭码，4000-4999为应用自定义的关闭码，web端可以从close事件中拿到code和reason
+const (
+	closeCodeIdleTimeout     = 4000
+	closeCodeTooManySessions = 4001
+)
+
+// 每个用户当前打开的终端会话数，用于限制单个用户的并发会话
+var terminalSessions = &sessionCounter{count: map[string]int{}}
+
+type sessionCounter struct {
+	mu    sync.Mutex
+	count map[string]int
+}
+
+// 占用一个会话名额，超过上限时返回false
+func (s *sessionCounter) acquire(username string, max int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if max > 0 && s.count[username] >= max {
+		return false
+	}
+	s.count[username]++
+	return true
+}
+
+// 所有用户的会话总数
+func (s *sessionCounter) total() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	total := 0
+	for _, count := range s.count {
+		total += count
+	}
+	return total
+}
+
+// 释放一个会话名额
+func (s *sessionCounter) release(username string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.count[username]--
+	if s.count[username] <= 0 {
+		delete(s.count, username)
+	}
+}
+
 // 定义websocket的handler方法
 func (t *terminal) WsHandler(w http.ResponseWriter, r *http.Request) {
-	//加载k8s配置
-	conf, err := clientcmd.BuildConfigFromFlags("", config.Config.GetString("Kubenertes.config"))
-	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("创建k8s配置失败, ")).Msg(err.Error())
-	}
-	//解析form入参，获取namespace、podName、containerName参数
+	//解析form入参，获取namespace、podName、containerName、shell参数
 	if err := r.ParseForm(); err != nil {
 		return
 	}
 	namespace := r.Form.Get("namespace")
 	podName := r.Form.Get("pod_name")
 	containerName := r.Form.Get("container_name")
-	utils.Logger.Info().Str("exec pod",podName).Str("container", containerName).Str("namespace",namespace).Msg("")
+	shell := r.Form.Get("shell")
+	//升级websocket之前校验token，校验失败直接返回401
+	username, err := wsUser(r)
+	if err != nil {
+		utils.Log(r.Context()).Info().Str("pod", podName).Str("ip", r.RemoteAddr).Msg("终端鉴权失败, " + err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	utils.Log(r.Context()).Info().Str("exec pod", podName).Str("container", containerName).Str("namespace", namespace).Str("shell", shell).Str("user", username).Msg("")
 	//new一个TerminalSession类型的pty实例
 	pty, err := NewTerminalSession(w, r, nil)
 	if err != nil {
-		utils.Logger.Error().Stack().Err(errors.New("get pty failed")).Msg(err.Error())
+		utils.Log(r.Context()).Error().Stack().Err(errors.New("get pty failed")).Msg(err.Error())
 		return
 	}
 	//处理关闭
 	defer func() {
-		utils.Logger.Info().Msg("close session.")
+		utils.Log(r.Context()).Info().Msg("close session.")
 		pty.Close()
 	}()
+	//限制单个用户的并发会话数，超过上限时告知web端原因后关闭连接
+	release, ok := pty.acquire(username)
+	if !ok {
+		return
+	}
+	defer release()
+
+	t.attachShell(r.Context(), pty, username, namespace, podName, containerName, shell)
+}
+
+// 在已建立的websocket会话中启动容器shell，开启录像时同时录像，出错时将报错返回给web端
+func (t *terminal) attachShell(ctx context.Context, pty *TerminalSession, username, namespace, podName, containerName, shell string) {
+	var err error
+	//开启录像时，会话的输入输出都会写入录像文件
+	if Recorder.Enabled() {
+		pty.recorder, err = Recorder.Start(ctx, username, namespace, podName, containerName, shell)
+		if err != nil {
+			pty.Write([]byte("开启终端录像失败, " + err.Error()))
+			pty.Done()
+			return
+		}
+	}
+
+	err = t.startShell(ctx, namespace, podName, containerName, shell, pty)
+	if err != nil {
+		msg := fmt.Sprintf("Exec to pod error! err: %v", err)
+		utils.Log(ctx).Info().Msg(msg)
+		//将报错返回出去
+		pty.Write([]byte(msg))
+		//标记退出stream流
+		pty.Done()
+	}
+}
+
+// 启动容器中的shell，指定的shell不存在时按validShells的顺序回退，例如bash不存在时回退到sh
+func (t *terminal) startShell(ctx context.Context, namespace, podName, containerName, shell string, pty *TerminalSession) (err error) {
+	shells := validShells
+	if shell != "" {
+		if !isValidShell(shell) {
+			return errcode.InvalidParam.New("不支持的shell: " + shell)
+		}
+		//指定的shell放在第一位，其余的作为回退
+		shells = []string{shell}
+		for _, s := range validShells {
+			if s != shell {
+				shells = append(shells, s)
+			}
+		}
+	}
+	s, err := t.probeShell(ctx, namespace, podName, containerName, shells)
+	if err != nil {
+		return err
+	}
+	return t.startProcess(ctx, namespace, podName, containerName, []string{s}, pty)
+}
+
+// 探测shell是否可用的超时时间
+const shellProbeTimeout = 10 * time.Second
+
+// 按顺序探测容器中第一个可用的shell
+// 探测使用不带tty和stdin的exec，不读取websocket，避免启动失败的exec残留读取websocket的goroutine，吞掉web端的输入
+func (t *terminal) probeShell(ctx context.Context, namespace, podName, containerName string, shells []string) (shell string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, shellProbeTimeout)
+	defer cancel()
+	for _, s := range shells {
+		var stderr bytes.Buffer
+		err = streamExec(ctx, namespace, podName, containerName, []string{s, "-c", "exit 0"}, remotecommand.StreamOptions{
+			Stderr: &stderr,
+		})
+		if err == nil {
+			return s, nil
+		}
+		if !isShellNotFound(err) {
+			return "", err
+		}
+		utils.Log(ctx).Info().Str("shell", s).Str("pod", podName).Msg("shell不存在，尝试下一个shell")
+	}
+	return "", err
+}
+
+// 在容器中启动进程，并将进程的输入输出与pty绑定
+func (t *terminal) startProcess(ctx context.Context, namespace, podName, containerName string, cmd []string, pty *TerminalSession) error {
 	// 初始化pod所在的corev1资源组
 	// PodExecOptions struct 包括Container stdout stdout  Command 等结构
 	// scheme.ParameterCodec 应该是pod 的GVK （GroupVersion & Kind）之类的
@@ -59,36 +212,155 @@ func (t *terminal) WsHandler(w http.ResponseWriter, r *http.Request) {
 		SubResource("exec").
 		VersionedParams(&v1.PodExecOptions{
 			Container: containerName,
-			Command:   []string{"/bin/bash"},
+			Command:   cmd,
 			Stdin:     true,
 			Stdout:    true,
 			Stderr:    true,
 			TTY:       true,
 		}, scheme.ParameterCodec)
-	fmt.Println(req.URL())
 
 	//remotecommand 主要实现了http 转 SPDY 添加X-Stream-Protocol-Version相关header 并发送请求
-	executor, err := remotecommand.NewSPDYExecutor(conf, "POST", req.URL())
+	executor, err := remotecommand.NewSPDYExecutor(K8sRestConfig, "POST", req.URL())
 	if err != nil {
-		return
+		return err
 	}
 	// 建立链接之后从请求的sream中发送、读取数据
-	err = executor.Stream(remotecommand.StreamOptions{
+	return executor.Stream(remotecommand.StreamOptions{
 		Stdin:             pty,
 		Stdout:            pty,
 		Stderr:            pty,
 		TerminalSizeQueue: pty,
 		Tty:               true,
 	})
+}
 
+// 在容器中执行一次性命令，返回stdout、stderr和退出码，timeout为超时时间(秒)
+func (t *terminal) Exec(ctx context.Context, namespace, podName, containerName string, command []string, timeout int) (result *ExecResult, err error) {
+	if len(command) == 0 {
+		return nil, errcode.InvalidParam.New("执行命令不能为空")
+	}
+	//未传入超时时间则使用默认值，超过上限则使用上限
+	if timeout <= 0 {
+		timeout = config.Config.GetInt("Terminal.exectimeout")
+	}
+	if maxTimeout := config.Config.GetInt("Terminal.maxexectimeout"); maxTimeout > 0 && timeout > maxTimeout {
+		timeout = maxTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	err = streamExec(ctx, namespace, podName, containerName, command, remotecommand.StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	result = &ExecResult{}
+	//命令以非0退出码退出时，err为ExitError类型，此时不视为调用失败
+	var exitErr utilexec.ExitError
+	switch {
+	case err == nil:
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitStatus()
+	case ctx.Err() == context.DeadlineExceeded:
+		result.ExitCode = -1
+		result.TimedOut = true
+	default:
+		utils.Log(ctx).Error().Stack().Err(errors.New("执行命令失败")).Msg(err.Error())
+		return nil, errors.Wrap(err, "执行命令失败")
+	}
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+	utils.Log(ctx).Info().Str("pod", podName).Str("container", containerName).Str("namespace", namespace).
+		Strs("command", command).Int("exit_code", result.ExitCode).Bool("timed_out", result.TimedOut).Msg("exec命令执行完成")
+
+	return result, nil
+}
+
+// 校验websocket请求携带的token并返回用户名，终端和port-forward共用
+// token可以放在token参数、Authorization header中，也可以作为websocket子协议传递
+func wsUser(r *http.Request) (username string, err error) {
+	token := r.Form.Get("token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		for _, protocol := range websocket.Subprotocols(r) {
+			if protocol != terminalSubprotocol && protocol != portForwardSubprotocol {
+				token = protocol
+				break
+			}
+		}
+	}
+	if token == "" {
+		return "", errors.New("请求未携带token，无权限访问")
+	}
+	claims, err := utils.JWTToken.ParseToken(token)
 	if err != nil {
-		msg := fmt.Sprintf("Exec to pod error! err: %v", err)
-		utils.Logger.Info().Msg(msg)
-		//将报错返回出去
-		pty.Write([]byte(msg))
-		//标记退出stream流
-		pty.Done()
+		return "", err
+	}
+	return claims.UserName, nil
+}
+
+// 校验websocket请求的Origin，Terminal.allowedorigins中配置允许的Origin，"*"表示允许所有
+// 未配置时只允许同源请求
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	allowed := config.Config.GetStringSlice("Terminal.allowedorigins")
+	if len(allowed) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, r.Host)
+	}
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	utils.Log(r.Context()).Info().Str("origin", origin).Str("ip", r.RemoteAddr).Msg("websocket Origin不在允许列表中")
+	return false
+}
+
+// 在容器中执行非交互命令，stdin、stdout、stderr由调用方传入，用于一次性命令和文件拷贝
+func streamExec(ctx context.Context, namespace, podName, containerName string, command []string, opts remotecommand.StreamOptions) error {
+	req := K8sClientSet.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Name(podName).
+		Namespace(namespace).
+		SubResource("exec").
+		VersionedParams(&v1.PodExecOptions{
+			Container: containerName,
+			Command:   command,
+			Stdin:     opts.Stdin != nil,
+			Stdout:    opts.Stdout != nil,
+			Stderr:    opts.Stderr != nil,
+		}, scheme.ParameterCodec)
+	executor, err := remotecommand.NewSPDYExecutor(K8sRestConfig, "POST", req.URL())
+	if err != nil {
+		return errors.Wrap(err, "创建Executor失败")
+	}
+	return executor.StreamWithContext(ctx, opts)
+}
+
+// 判断shell是否在可选列表中
+func isValidShell(shell string) bool {
+	for _, s := range validShells {
+		if s == shell {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断exec的报错是否是因为shell在容器中不存在
+// 不同容器运行时的报错不一样，这里只匹配常见的报错内容和退出码
+func isShellNotFound(err error) bool {
+	var exitErr utilexec.ExitError
+	if errors.As(err, &exitErr) && (exitErr.ExitStatus() == 126 || exitErr.ExitStatus() == 127) {
+		return true
 	}
+	msg := err.Error()
+	return strings.Contains(msg, "executable file not found") || strings.Contains(msg, "no such file or directory")
 }
 
 const END_OF_TRANSMISSION = "\u0004"
@@ -108,9 +380,9 @@ type TerminalMessage struct {
 var upgrader = func() websocket.Upgrader {
 	upgrader := websocket.Upgrader{}
 	upgrader.HandshakeTimeout = time.Second * 2
-	upgrader.CheckOrigin = func(r *http.Request) bool {
-		return true
-	}
+	upgrader.CheckOrigin = checkOrigin
+	//客户端通过子协议传递token时，需要回应同样的子协议，否则浏览器会断开连接
+	upgrader.Subprotocols = []string{terminalSubprotocol}
 	return upgrader
 }()
 
@@ -118,10 +390,18 @@ var upgrader = func() websocket.Upgrader {
 // wsConn是websocket连接
 // sizeChan用来定义终端输入和输出的宽和高
 // doneChan用于标记退出终端
+// recorder不为空时，会话的输入、输出和resize事件都会被录像
+// lastActive记录web端最后一次输入的时间，用于空闲超时
 type TerminalSession struct {
-	wsConn   *websocket.Conn
-	sizeChan chan remotecommand.TerminalSize
-	doneChan chan struct{}
+	wsConn     *websocket.Conn
+	sizeChan   chan remotecommand.TerminalSize
+	doneChan   chan struct{}
+	closeChan  chan struct{}
+	doneOnce   sync.Once
+	closeOnce  sync.Once
+	writeMu    sync.Mutex
+	lastActive atomic.Int64
+	recorder   *TerminalRecorder
 }
 
 // 该方法用于升级http协议至websocket，并new一个TerminalSession类型的对象返回
@@ -131,16 +411,69 @@ func NewTerminalSession(w http.ResponseWriter, r *http.Request, responseHeader h
 		return nil, err
 	}
 	session := &TerminalSession{
-		wsConn:   conn,
-		sizeChan: make(chan remotecommand.TerminalSize),
-		doneChan: make(chan struct{}),
+		wsConn:    conn,
+		sizeChan:  make(chan remotecommand.TerminalSize),
+		doneChan:  make(chan struct{}),
+		closeChan: make(chan struct{}),
 	}
+	session.lastActive.Store(time.Now().UnixNano())
 	return session, nil
 }
 
 // 关闭doneChan，关闭后触发退出终端
 func (t *TerminalSession) Done() {
-	close(t.doneChan)
+	t.doneOnce.Do(func() {
+		close(t.doneChan)
+	})
+}
+
+// 定时检查会话是否空闲，超过timeout没有输入时通知web端并关闭连接
+func (t *TerminalSession) watchIdle(timeout time.Duration) {
+	interval := timeout / 10
+	if interval > 10*time.Second {
+		interval = 10 * time.Second
+	}
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.closeChan:
+			return
+		case <-ticker.C:
+			idle := time.Since(time.Unix(0, t.lastActive.Load()))
+			if idle < timeout {
+				continue
+			}
+			utils.Logger.Info().Dur("idle", idle).Msg("终端会话空闲超时, 关闭连接")
+			t.Write([]byte(fmt.Sprintf("\r\n会话空闲超过%s, 连接已关闭\r\n", timeout)))
+			t.CloseWithReason(closeCodeIdleTimeout, "idle timeout")
+			t.Done()
+			return
+		}
+	}
+}
+
+// 占用用户的会话名额并开启空闲检查，超过并发上限时告知web端原因后关闭连接
+func (t *TerminalSession) acquire(username string) (release func(), ok bool) {
+	if !terminalSessions.acquire(username, config.Config.GetInt("Terminal.maxsessionsperuser")) {
+		t.CloseWithReason(closeCodeTooManySessions, "too many terminal sessions")
+		return nil, false
+	}
+	//空闲超时后关闭会话
+	if idle := config.Config.GetInt("Terminal.idletimeout"); idle > 0 {
+		go t.watchIdle(time.Duration(idle) * time.Second)
+	}
+	return func() { terminalSessions.release(username) }, true
+}
+
+// 发送websocket关闭帧告知web端关闭原因，然后关闭连接
+func (t *TerminalSession) CloseWithReason(code int, reason string) {
+	msg := websocket.FormatCloseMessage(code, reason)
+	t.wsConn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	t.wsConn.Close()
 }
 
 // 获取web端是否resize，以及是否退出终端
@@ -169,8 +502,15 @@ func (t *TerminalSession) Read(p []byte) (int, error) {
 
 	switch msg.Operation {
 	case "stdin":
+		t.lastActive.Store(time.Now().UnixNano())
+		if t.recorder != nil {
+			t.recorder.Input(msg.Data)
+		}
 		return copy(p, msg.Data), nil
 	case "resize":
+		if t.recorder != nil {
+			t.recorder.Resize(msg.Cols, msg.Rows)
+		}
 		t.sizeChan <- remotecommand.TerminalSize{Width: msg.Cols, Height: msg.Rows}
 		return 0, nil
 	case "ping":
@@ -184,6 +524,9 @@ func (t *TerminalSession) Read(p []byte) (int, error) {
 
 // 用于向web端输出，接收web端的指令后，将结果返回出去
 func (t *TerminalSession) Write(p []byte) (int, error) {
+	if t.recorder != nil {
+		t.recorder.Output(string(p))
+	}
 	msg, err := json.Marshal(TerminalMessage{
 		Operation: "stdout",
 		Data:      string(p),
@@ -192,6 +535,9 @@ func (t *TerminalSession) Write(p []byte) (int, error) {
 		log.Printf("write parse message err: %v", err)
 		return 0, err
 	}
+	//空闲检查和exec输出可能同时写入，websocket不支持并发写
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
 	if err := t.wsConn.WriteMessage(websocket.TextMessage, msg); err != nil {
 		log.Printf("write message err: %v", err)
 		return 0, err
@@ -199,7 +545,15 @@ func (t *TerminalSession) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
-// 用于关闭websocket连接
+// 用于关闭websocket连接，同时结束录像
 func (t *TerminalSession) Close() error {
+	t.closeOnce.Do(func() {
+		close(t.closeChan)
+	})
+	if t.recorder != nil {
+		if err := t.recorder.Close(); err != nil {
+			utils.Logger.Error().Stack().Err(errors.New("结束终端录像失败")).Msg(err.Error())
+		}
+	}
 	return t.wsConn.Close()
 }
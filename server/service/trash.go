@@ -0,0 +1,526 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8s-server/config"
+	"k8s-server/dao"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+)
+
+// 回收站，通过接口删除资源前先将去掉集群生成字段的快照保存到数据库，可以从回收站重新创建
+// 删除namespace时保存其中所有类型的资源(通过discovery获取)，由其他资源管理的资源(有ownerReferences)和集群自动生成的资源不保存
+// 单独删除没有selector的service时只保存service本身，手动维护的endpoints需要恢复后重新创建
+// 超过保留时间的快照由后台定期清理
+var Trash = &trash{}
+
+type trash struct {
+	once sync.Once
+}
+
+// 清理过期快照的间隔
+const trashPurgeInterval = time.Hour
+
+// namespace的GroupVersionResource
+var namespaceResource = corev1.SchemeGroupVersion.WithResource("namespaces")
+
+// 删除namespace时不保存的资源类型，事件和由控制器自动维护的资源，没有selector的service的endpoints除外
+var trashSkipKinds = map[string]bool{
+	"Event":         true,
+	"Endpoints":     true,
+	"EndpointSlice": true,
+	"Lease":         true,
+}
+
+// 恢复namespace时资源的创建顺序，被引用的资源先创建，未列出的类型在service之后、hpa之前创建
+var trashRestoreOrder = map[string]int{
+	"ServiceAccount":          0,
+	"Secret":                  0,
+	"ConfigMap":               0,
+	"LimitRange":              0,
+	"ResourceQuota":           0,
+	"PersistentVolumeClaim":   1,
+	"Role":                    1,
+	"RoleBinding":             2,
+	"Service":                 3,
+	"HorizontalPodAutoscaler": 5,
+}
+
+// 定义TrashDetail结构体，namespace记录包含其中所有资源的快照
+type TrashDetail struct {
+	*model.TrashItem
+	Children []*model.TrashItem `json:"children"`
+}
+
+// 定义TrashRestore结构体，恢复回收站中的资源
+// Name不为空时使用该名称恢复；同名资源已存在且Rename为true时自动在名称后添加随机后缀，否则返回AlreadyExists
+// 恢复namespace时名称指namespace的名称，其中的资源保持原名
+type TrashRestore struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Rename bool   `json:"rename"`
+}
+
+// 定义TrashRestoreResult结构体，返回恢复后的名称，恢复namespace时Skipped为未能恢复的资源及原因
+type TrashRestoreResult struct {
+	Kind      string   `json:"kind"`
+	Namespace string   `json:"namespace"`
+	Name      string   `json:"name"`
+	Restored  int      `json:"restored"`
+	Skipped   []string `json:"skipped"`
+}
+
+// context中标记删除时不保存快照的key
+type skipTrashKey struct{}
+
+// workflow、发布等内部流程删除自己创建的资源时不保存快照，这些资源由workflow的历史版本管理
+func withoutTrash(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipTrashKey{}, true)
+}
+
+// 是否需要保存快照，回收站未开启或context标记为不保存时不保存
+func trashEnabled(ctx context.Context) bool {
+	skip, _ := ctx.Value(skipTrashKey{}).(bool)
+	return !skip && config.Config.GetBool("Trash.enabled")
+}
+
+// 启动后台清理，配置中未开启回收站时不启动
+func (t *trash) Start() {
+	if !config.Config.GetBool("Trash.enabled") {
+		return
+	}
+	t.once.Do(func() {
+		go t.run()
+		utils.Logger.Info().Dur("retention", t.retention()).Msg("回收站清理已启动")
+	})
+}
+
+// 快照保留时间
+func (t *trash) retention() time.Duration {
+	retention := config.Config.GetInt("Trash.retention")
+	if retention <= 0 {
+		retention = 168
+	}
+	return time.Duration(retention) * time.Hour
+}
+
+func (t *trash) run() {
+	ticker := time.NewTicker(trashPurgeInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), trashPurgeInterval)
+		t.purge(ctx)
+		cancel()
+	}
+}
+
+// 清理超过保留时间的快照
+func (t *trash) purge(ctx context.Context) {
+	deleted, err := dao.TrashItem.DeleteBefore(ctx, time.Now().Add(-t.retention()))
+	if err != nil {
+		return
+	}
+	utils.Logger.Info().Int64("deleted", deleted).Msg("清理过期回收站快照")
+}
+
+// 删除资源前保存快照，返回的记录在删除失败时传给Discard
+// 回收站未开启或内部流程删除时不保存，返回nil
+func (t *trash) Snapshot(ctx context.Context, gvr schema.GroupVersionResource, namespace, name string) (*model.TrashItem, error) {
+	if !trashEnabled(ctx) {
+		return nil, nil
+	}
+	obj, err := K8sDynamicClient.Resource(gvr).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "获取%s %s失败", gvr.Resource, name)
+	}
+	now := time.Now()
+	item, err := newTrashItem(ctx, gvr.Resource, obj, now)
+	if err != nil {
+		return nil, err
+	}
+	if err = dao.TrashItem.Add(ctx, item, nil); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// 删除namespace前保存namespace及其中所有资源的快照，任何一种资源获取失败时不删除namespace
+func (t *trash) SnapshotNamespace(ctx context.Context, name string) (*model.TrashItem, error) {
+	if !trashEnabled(ctx) {
+		return nil, nil
+	}
+	obj, err := K8sDynamicClient.Resource(namespaceResource).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "获取Namespace失败")
+	}
+	now := time.Now()
+	item, err := newTrashItem(ctx, namespaceResource.Resource, obj, now)
+	if err != nil {
+		return nil, err
+	}
+
+	resources, err := namespacedResources(ctx)
+	if err != nil {
+		return nil, err
+	}
+	//先获取所有资源，没有selector的service的endpoints由用户维护，需要与service一起保存
+	lists := make([]*unstructured.UnstructuredList, len(resources))
+	manualEndpoints := map[string]bool{}
+	for i, gvr := range resources {
+		list, err := K8sDynamicClient.Resource(gvr).Namespace(name).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			utils.Log(ctx).Error().Stack().Err(errors.New("保存Namespace快照失败, ")).Str("resource", gvr.String()).Msg(err.Error())
+			return nil, errors.Wrapf(err, "获取%s列表失败", gvr.Resource)
+		}
+		lists[i] = list
+		for j := range list.Items {
+			obj := &list.Items[j]
+			if obj.GetKind() != "Service" {
+				continue
+			}
+			if selector, _, _ := unstructured.NestedStringMap(obj.Object, "spec", "selector"); len(selector) == 0 {
+				manualEndpoints[obj.GetName()] = true
+			}
+		}
+	}
+	var children []*model.TrashItem
+	for i, list := range lists {
+		for j := range list.Items {
+			if !shouldSnapshot(&list.Items[j], manualEndpoints) {
+				continue
+			}
+			child, err := newTrashItem(ctx, resources[i].Resource, &list.Items[j], now)
+			if err != nil {
+				return nil, err
+			}
+			children = append(children, child)
+		}
+	}
+	if err = dao.TrashItem.Add(ctx, item, children); err != nil {
+		return nil, err
+	}
+	utils.Log(ctx).Info().Str("namespace", name).Int("resources", len(children)).Msg("保存Namespace快照")
+	return item, nil
+}
+
+// 删除失败时丢弃保存的快照，item为nil时不处理
+func (t *trash) Discard(ctx context.Context, item *model.TrashItem) {
+	if item == nil {
+		return
+	}
+	discardCtx, cancel := detachedContext(ctx)
+	defer cancel()
+	//错误已在dao中记录日志
+	_ = dao.TrashItem.Delete(discardCtx, item.ID)
+}
+
+// 获取回收站列表
+func (t *trash) GetList(ctx context.Context, kind, namespace, name string, page, limit int) (*dao.TrashItemResp, error) {
+	return dao.TrashItem.GetList(ctx, kind, namespace, name, page, limit)
+}
+
+// 获取回收站记录详情，namespace记录包含其中所有资源的快照
+func (t *trash) GetDetail(ctx context.Context, id int) (*TrashDetail, error) {
+	item, err := t.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	detail := &TrashDetail{TrashItem: redactTrashItem(item)}
+	if item.Kind == "Namespace" {
+		children, err := dao.TrashItem.GetChildren(ctx, item.ID)
+		if err != nil {
+			return nil, err
+		}
+		detail.Children = make([]*model.TrashItem, 0, len(children))
+		for _, child := range children {
+			detail.Children = append(detail.Children, redactTrashItem(child))
+		}
+	}
+	return detail, nil
+}
+
+// 返回隐藏了secret内容的回收站记录副本，数据库中的快照保持不变，用于恢复
+func redactTrashItem(item *model.TrashItem) *model.TrashItem {
+	redacted := *item
+	if item.Kind != "Secret" {
+		return &redacted
+	}
+	obj := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(item.Manifest), &obj); err != nil {
+		return &redacted
+	}
+	redactSecret(obj)
+	if manifest, err := json.Marshal(obj); err == nil {
+		redacted.Manifest = string(manifest)
+	}
+	return &redacted
+}
+
+// 彻底删除回收站记录
+func (t *trash) Delete(ctx context.Context, id int) error {
+	item, err := t.get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err = dao.TrashItem.Delete(ctx, item.ID); err != nil {
+		return err
+	}
+	utils.Log(ctx).Info().Str("kind", item.Kind).Str("namespace", item.Namespace).Str("name", item.Name).Msg("彻底删除回收站记录")
+	return nil
+}
+
+// 从快照重新创建资源，namespace记录会重新创建namespace及其中的资源
+func (t *trash) Restore(ctx context.Context, data *TrashRestore) (*TrashRestoreResult, error) {
+	item, err := t.get(ctx, data.ID)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := decodeTrashItem(item)
+	if err != nil {
+		return nil, err
+	}
+	name := item.Name
+	if data.Name != "" {
+		name = data.Name
+	}
+	if name, err = createTrashObject(ctx, item, obj, item.Namespace, name, data.Rename && data.Name == ""); err != nil {
+		return nil, err
+	}
+	result := &TrashRestoreResult{Kind: item.Kind, Namespace: item.Namespace, Name: name, Restored: 1}
+	if item.Kind == "Namespace" {
+		result.Namespace = ""
+		if err = t.restoreChildren(ctx, item, name, result); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	item.RestoredName = name
+	item.RestoredBy = utils.UserFromContext(ctx)
+	item.RestoredAt = &now
+	if err = dao.TrashItem.Save(ctx, item); err != nil {
+		return nil, err
+	}
+	utils.Log(ctx).Info().Str("kind", item.Kind).Str("namespace", item.Namespace).Str("name", item.Name).
+		Str("restored_name", name).Int("restored", result.Restored).Msg("从回收站恢复资源")
+	return result, nil
+}
+
+// 在恢复的namespace中按顺序创建资源，单个资源失败时记录原因并继续
+func (t *trash) restoreChildren(ctx context.Context, item *model.TrashItem, namespace string, result *TrashRestoreResult) error {
+	children, err := dao.TrashItem.GetChildren(ctx, item.ID)
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(children, func(i, j int) bool {
+		return trashRestoreRank(children[i].Kind) < trashRestoreRank(children[j].Kind)
+	})
+	for _, child := range children {
+		obj, err := decodeTrashItem(child)
+		if err == nil {
+			_, err = createTrashObject(ctx, child, obj, namespace, child.Name, false)
+		}
+		if err != nil {
+			result.Skipped = append(result.Skipped, fmt.Sprintf("%s/%s: %s", child.Kind, child.Name, err.Error()))
+			continue
+		}
+		result.Restored++
+	}
+	return nil
+}
+
+// 获取回收站记录
+func (t *trash) get(ctx context.Context, id int) (*model.TrashItem, error) {
+	item, err := dao.TrashItem.GetById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if item == nil {
+		return nil, errcode.NotFound.Newf("回收站记录不存在: %d", id)
+	}
+	return item, nil
+}
+
+// 获取集群中所有可以list、create、delete的namespace级别资源
+// 部分聚合接口不可用时跳过这些接口，其中的资源无法保存
+func namespacedResources(ctx context.Context) ([]schema.GroupVersionResource, error) {
+	lists, err := K8sClientSet.Discovery().ServerPreferredNamespacedResources()
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, errors.Wrap(err, "获取集群资源类型失败")
+		}
+		utils.Log(ctx).Warn().Err(err).Msg("部分资源类型获取失败, 这些资源不会保存到回收站")
+	}
+	lists = discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"list", "create", "delete"}}, lists)
+	gvrs, err := discovery.GroupVersionResources(lists)
+	if err != nil {
+		return nil, errors.Wrap(err, "解析集群资源类型失败")
+	}
+	resources := make([]schema.GroupVersionResource, 0, len(gvrs))
+	for gvr := range gvrs {
+		resources = append(resources, gvr)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].String() < resources[j].String()
+	})
+	return resources, nil
+}
+
+// 删除namespace时是否保存该资源，由其他资源管理或集群自动生成的资源不保存
+// manualEndpoints为没有selector的service，它们的endpoints由用户维护，需要保存
+func shouldSnapshot(obj *unstructured.Unstructured, manualEndpoints map[string]bool) bool {
+	if obj.GetKind() == "Endpoints" && len(obj.GetOwnerReferences()) == 0 {
+		return manualEndpoints[obj.GetName()]
+	}
+	if len(obj.GetOwnerReferences()) > 0 || trashSkipKinds[obj.GetKind()] {
+		return false
+	}
+	switch obj.GetKind() {
+	case "ServiceAccount":
+		return obj.GetName() != "default"
+	case "ConfigMap":
+		return obj.GetName() != "kube-root-ca.crt"
+	case "Secret":
+		secretType, _, _ := unstructured.NestedString(obj.Object, "type")
+		return secretType != string(corev1.SecretTypeServiceAccountToken)
+	}
+	return true
+}
+
+// 生成回收站记录，快照去掉集群生成的字段
+func newTrashItem(ctx context.Context, resource string, obj *unstructured.Unstructured, deletedAt time.Time) (*model.TrashItem, error) {
+	cleanTrashObject(obj)
+	manifest, err := json.Marshal(obj.Object)
+	if err != nil {
+		return nil, errors.Wrapf(err, "序列化%s %s失败", obj.GetKind(), obj.GetName())
+	}
+	return &model.TrashItem{
+		CreatedAt:  &deletedAt,
+		APIVersion: obj.GetAPIVersion(),
+		Kind:       obj.GetKind(),
+		Resource:   resource,
+		Namespace:  obj.GetNamespace(),
+		Name:       obj.GetName(),
+		Manifest:   string(manifest),
+		Operator:   utils.UserFromContext(ctx),
+	}, nil
+}
+
+// 去掉uid、resourceVersion、status等由集群生成的字段，以及重新创建时会冲突或失效的字段
+func cleanTrashObject(obj *unstructured.Unstructured) {
+	obj.SetUID("")
+	obj.SetResourceVersion("")
+	obj.SetGeneration(0)
+	obj.SetCreationTimestamp(metav1.Time{})
+	obj.SetDeletionTimestamp(nil)
+	obj.SetDeletionGracePeriodSeconds(nil)
+	obj.SetManagedFields(nil)
+	obj.SetSelfLink("")
+	obj.SetOwnerReferences(nil)
+	obj.SetFinalizers(nil)
+	unstructured.RemoveNestedField(obj.Object, "status")
+
+	annotations := obj.GetAnnotations()
+	for _, key := range []string{
+		"deployment.kubernetes.io/revision",
+		"pv.kubernetes.io/bind-completed",
+		"pv.kubernetes.io/bound-by-controller",
+		"volume.beta.kubernetes.io/storage-provisioner",
+		"volume.kubernetes.io/storage-provisioner",
+		"volume.kubernetes.io/selected-node",
+	} {
+		delete(annotations, key)
+	}
+	obj.SetAnnotations(annotations)
+
+	switch obj.GetKind() {
+	case "Namespace":
+		unstructured.RemoveNestedField(obj.Object, "spec")
+	case "Service":
+		//分配的IP恢复时可能冲突，去掉后重新分配；headless service的None需要保留
+		if clusterIP, _, _ := unstructured.NestedString(obj.Object, "spec", "clusterIP"); clusterIP != corev1.ClusterIPNone {
+			unstructured.RemoveNestedField(obj.Object, "spec", "clusterIP")
+			unstructured.RemoveNestedField(obj.Object, "spec", "clusterIPs")
+		}
+		unstructured.RemoveNestedField(obj.Object, "spec", "healthCheckNodePort")
+	case "PersistentVolumeClaim":
+		//原来的pv已经与被删除的pvc解绑，恢复后重新绑定或创建pv
+		unstructured.RemoveNestedField(obj.Object, "spec", "volumeName")
+	case "PersistentVolume":
+		unstructured.RemoveNestedField(obj.Object, "spec", "claimRef")
+	case "Pod":
+		unstructured.RemoveNestedField(obj.Object, "spec", "nodeName")
+	case "Job":
+		//job的selector和标签由集群按uid生成
+		unstructured.RemoveNestedField(obj.Object, "spec", "selector")
+		for _, key := range []string{"controller-uid", "batch.kubernetes.io/controller-uid"} {
+			unstructured.RemoveNestedField(obj.Object, "metadata", "labels", key)
+			unstructured.RemoveNestedField(obj.Object, "spec", "template", "metadata", "labels", key)
+		}
+	}
+}
+
+// 解析回收站记录中的资源快照
+func decodeTrashItem(item *model.TrashItem) (*unstructured.Unstructured, error) {
+	obj := &unstructured.Unstructured{}
+	if err := json.Unmarshal([]byte(item.Manifest), &obj.Object); err != nil {
+		return nil, errors.Wrap(err, "解析资源快照失败")
+	}
+	return obj, nil
+}
+
+// 在namespace中以name创建资源，同名资源已存在且rename为true时添加随机后缀后重试一次，返回创建的名称
+func createTrashObject(ctx context.Context, item *model.TrashItem, obj *unstructured.Unstructured, namespace, name string, rename bool) (string, error) {
+	gv, err := schema.ParseGroupVersion(item.APIVersion)
+	if err != nil {
+		return "", errors.Wrap(err, "解析apiVersion失败")
+	}
+	//namespace和pv等集群级别的资源不设置namespace
+	resource := K8sDynamicClient.Resource(gv.WithResource(item.Resource))
+	var client dynamic.ResourceInterface = resource
+	obj.SetNamespace("")
+	if item.Kind != "Namespace" && namespace != "" {
+		client = resource.Namespace(namespace)
+		obj.SetNamespace(namespace)
+	}
+	obj.SetName(name)
+	_, err = client.Create(ctx, obj, metav1.CreateOptions{})
+	if apierrors.IsAlreadyExists(err) && rename {
+		name = fmt.Sprintf("%s-restored-%s", item.Name, utilrand.String(5))
+		obj.SetName(name)
+		_, err = client.Create(ctx, obj, metav1.CreateOptions{})
+	}
+	if apierrors.IsAlreadyExists(err) {
+		return "", errcode.AlreadyExists.Newf("%s %s已存在, 可以指定新的名称或选择自动重命名", item.Kind, name)
+	}
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("恢复资源失败, ")).Str("kind", item.Kind).Str("name", name).Msg(err.Error())
+		return "", errors.Wrapf(err, "恢复%s %s失败", item.Kind, name)
+	}
+	return name, nil
+}
+
+// 资源在恢复namespace时的创建顺序
+func trashRestoreRank(kind string) int {
+	if rank, ok := trashRestoreOrder[kind]; ok {
+		return rank
+	}
+	return 4
+}
@@ -1,8 +1,17 @@
 package service
 
 import (
+	"context"
 	"k8s-server/dao"
+	"k8s-server/errcode"
 	"k8s-server/model"
+	"k8s-server/utils"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
 
 var Workflow workflow
@@ -25,74 +34,135 @@ type WorkflowCreate struct {
 	Port          int32                  `json:"port"`
 	NodePort      int32                  `json:"node_port"`
 	Hosts         map[string][]*HttpPath `json:"hosts"`
+	//附加资源，例如configmap、secret、pvc和hpa
+	Resources []*unstructured.Unstructured `json:"resources"`
+	//导入已有资源时沿用原有的资源名，为空时按命名约定生成
+	DeploymentName string `json:"deployment_name,omitempty"`
+	ServiceName    string `json:"service_name,omitempty"`
+	IngressName    string `json:"ingress_name,omitempty"`
 }
 
-// 获取列表分页查询
-func (w *workflow) GetList(name string, page, limit int) (data *dao.WorkflowResp, err error) {
-	data, err = dao.Workflow.GetList(name, page, limit)
+// 获取列表分页查询，未删除的workflow附带集群中的实时状态
+func (w *workflow) GetList(ctx context.Context, name string, page, limit int) (data *WorkflowsResp, err error) {
+	list, err := dao.Workflow.GetList(ctx, name, page, limit)
 	if err != nil {
 		return nil, err
 	}
-	return data, nil
+	return &WorkflowsResp{
+		Items: workflowDetails(ctx, list.Items),
+		Total: list.Total,
+	}, nil
 }
 
-// 查询workflow单条数据
-func (w *workflow) GetById(id int) (data *model.Workflow, err error) {
-	data, err = dao.Workflow.GetById(id)
+// 查询workflow单条数据，附带集群中的实时状态
+func (w *workflow) GetById(ctx context.Context, id int) (data *WorkflowDetail, err error) {
+	workflow, err := dao.Workflow.GetById(ctx, id)
 	if err != nil {
 		return nil, err
 	}
-	return data, nil
+	if workflow.ID == 0 {
+		return nil, errcode.NotFound.Newf("Workflow不存在: %d", id)
+	}
+	return &WorkflowDetail{Workflow: workflow, Spec: redactWorkflowSpec(workflow.Spec), Live: workflowLive(ctx, workflow)}, nil
 }
 
 // 创建workflow
-func (w *workflow) CreateWorkflow(data *WorkflowCreate) (err error) {
-	//若workflow不是ingress类型，传入空字符串即可
-	var ingressName string
-	if data.Type == "Ingress" {
-		ingressName = getIngressName(data.Name)
-	} else {
-		ingressName = ""
+// 先写入Creating状态的数据占住name，k8s资源全部创建成功后更新为Running，
+// 任一资源创建失败时删除已创建的资源，并将数据标记为Failed，记录失败原因
+func (w *workflow) CreateWorkflow(ctx context.Context, data *WorkflowCreate) (err error) {
+	return w.create(ctx, data, model.RevisionCreate, "", "", 0)
+}
+
+// 创建workflow，action和message记录在第一个版本中，通过模板创建时记录模板名和版本
+func (w *workflow) create(ctx context.Context, data *WorkflowCreate, action, message, templateName string, templateVersion int) (err error) {
+	if err = normalizeWorkflowResources(data.Namespace, data.Resources); err != nil {
+		return err
 	}
-	//组装mysql中workflow的单条数据
+	revision, err := newWorkflowRevision(ctx, data, action, message)
+	if err != nil {
+		return err
+	}
+	if err = w.reserveName(ctx, data.Name); err != nil {
+		return err
+	}
+	//组装mysql中workflow的单条数据，若workflow不是ingress类型，ingress为空字符串
 	workflow := &model.Workflow{
-		Name:       data.Name,
-		Namespace:  data.Namespace,
-		Replicas:   data.Replicas,
-		Deployment: data.Name,
-		Service:    getServiceName(data.Name),
-		Ingress:    ingressName,
-		Type:       data.Type,
+		Template:        templateName,
+		TemplateVersion: templateVersion,
 	}
+	setWorkflowSpec(workflow, data)
+	workflow.Status = model.WorkflowCreating
 	//调用dao层执行数据库的添加操作
-	err = dao.Workflow.Add(workflow)
+	err = dao.Workflow.Add(ctx, workflow)
 	if err != nil {
 		return err
 	}
 
-	//创建k8s资源
-	err = createWorkflowRes(data)
+	//创建k8s资源，失败时已创建的资源会被回滚
+	err = createWorkflowRes(ctx, data)
 	if err != nil {
+		w.markFailed(ctx, workflow.ID, err)
+		return err
+	}
+
+	//状态更新和保存第一个版本失败时集群与数据库不一致，同样回滚k8s资源
+	workflow.Status = model.WorkflowRunning
+	err = dao.Workflow.SaveWithRevision(ctx, workflow, revision)
+	if err != nil {
+		cleanupCtx, cancel := detachedContext(ctx)
+		defer cancel()
+		if rerr := delWorkflowRes(cleanupCtx, workflow); rerr != nil {
+			utils.Log(ctx).Error().Stack().Err(errors.New("回滚Workflow资源失败")).Msg(rerr.Error())
+			err = errors.Wrapf(err, "回滚Workflow资源失败, %s", rerr.Error())
+		}
+		w.markFailed(ctx, workflow.ID, err)
 		return err
 	}
 
 	return nil
 }
 
+// 同名的workflow创建失败或已删除时，清理旧数据，否则name上的唯一索引会导致无法重新创建
+func (w *workflow) reserveName(ctx context.Context, name string) (err error) {
+	old, err := dao.Workflow.GetByName(ctx, name)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		return nil
+	}
+	if old.DeletedAt == nil && old.Status != model.WorkflowFailed {
+		return errcode.AlreadyExists.New("Workflow已存在: " + name)
+	}
+	return dao.Workflow.Purge(ctx, old.ID)
+}
+
 // 删除workflow
-func (w *workflow) DelById(id int) (err error) {
+// 先标记为Deleting，k8s资源全部删除后再删除数据库数据，删除失败时标记为Failed，可以重试
+func (w *workflow) DelById(ctx context.Context, id int) (err error) {
 	//获取workflow数据
-	workflow, err := dao.Workflow.GetById(id)
+	workflow, err := dao.Workflow.GetById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if workflow.ID == 0 {
+		return errcode.NotFound.Newf("Workflow不存在: %d", id)
+	}
+	if workflow.Status == model.WorkflowReleasing {
+		return errcode.Conflict.New("Workflow正在发布, 请先确认或放弃发布")
+	}
+	err = dao.Workflow.UpdateStatus(ctx, workflow.ID, model.WorkflowDeleting, "")
 	if err != nil {
 		return err
 	}
-	//删除k8s资源
-	err = delWorkflowRes(workflow)
+	//删除k8s资源，已经不存在的资源会被忽略
+	err = delWorkflowRes(ctx, workflow)
 	if err != nil {
+		w.markFailed(ctx, workflow.ID, err)
 		return err
 	}
 	//删除数据库数据
-	err = dao.Workflow.DelById(id)
+	err = dao.Workflow.DelById(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -100,14 +170,95 @@ func (w *workflow) DelById(id int) (err error) {
 	return nil
 }
 
+// 将workflow标记为Failed并记录失败原因
+// 失败原因可能是请求超时，所以使用不会被取消的ctx更新数据库
+func (w *workflow) markFailed(ctx context.Context, id uint, cause error) {
+	cleanupCtx, cancel := detachedContext(ctx)
+	defer cancel()
+	if err := dao.Workflow.UpdateStatus(cleanupCtx, id, model.WorkflowFailed, cause.Error()); err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("标记Workflow失败状态失败")).Msg(err.Error())
+	}
+}
+
 // 封装创建workflow对应的k8s资源
 // 小写开头的函数，作用域只在当前包中，不支持跨包调用
-func createWorkflowRes(data *WorkflowCreate) (err error) {
-	//声明service类型
-	var serviceType string
+// 资源按附加资源、deployment、service、ingress、hpa的顺序创建，失败时按相反的顺序删除已创建的资源
+func createWorkflowRes(ctx context.Context, data *WorkflowCreate) (err error) {
+	//已创建资源的删除方法
+	var rollbacks []func(ctx context.Context) error
+	defer func() {
+		if err == nil || len(rollbacks) == 0 {
+			return
+		}
+		cleanupCtx, cancel := detachedContext(ctx)
+		defer cancel()
+		for i := len(rollbacks) - 1; i >= 0; i-- {
+			if rerr := rollbacks[i](cleanupCtx); rerr != nil {
+				utils.Log(ctx).Error().Stack().Err(errors.New("回滚Workflow资源失败")).Msg(rerr.Error())
+				err = errors.Wrapf(err, "回滚Workflow资源失败, %s", rerr.Error())
+			}
+		}
+	}()
+	//创建可能被pod引用的附加资源
+	for _, obj := range data.Resources {
+		if isPostDeployResource(obj) {
+			continue
+		}
+		if err = createWorkflowResource(ctx, obj); err != nil {
+			return err
+		}
+		rollbacks = append(rollbacks, func(ctx context.Context) error {
+			return deleteWorkflowResource(ctx, obj)
+		})
+	}
 	//组装DeployCreate类型的数据
-	dc := &DeployCreate{
-		Name:          data.Name,
+	dc := workflowDeployCreate(data)
+	//创建deployment
+	err = Deployment.CreateDeployment(ctx, dc)
+	if err != nil {
+		return err
+	}
+	rollbacks = append(rollbacks, func(ctx context.Context) error {
+		return ignoreNotFound(Deployment.DeleteDeployment(withoutTrash(ctx), dc.Name, data.Namespace))
+	})
+	//组装ServiceCreate类型的数据
+	sc := workflowServiceCreate(data)
+	err = Servicev1.CreateService(ctx, sc)
+	if err != nil {
+		return err
+	}
+	rollbacks = append(rollbacks, func(ctx context.Context) error {
+		return ignoreNotFound(Servicev1.DeleteService(withoutTrash(ctx), sc.Name, data.Namespace))
+	})
+	//创建ingress，只有ingress类型的workflow才有ingress资源，所以这里做了一层判断
+	if data.Type == "Ingress" {
+		err = createWorkflowIngress(ctx, data)
+		if err != nil {
+			return err
+		}
+		rollbacks = append(rollbacks, func(ctx context.Context) error {
+			return ignoreNotFound(Ingress.DeleteIngress(withoutTrash(ctx), data.ingressName(), data.Namespace))
+		})
+	}
+	//创建依赖deployment的附加资源
+	for _, obj := range data.Resources {
+		if !isPostDeployResource(obj) {
+			continue
+		}
+		if err = createWorkflowResource(ctx, obj); err != nil {
+			return err
+		}
+		rollbacks = append(rollbacks, func(ctx context.Context) error {
+			return deleteWorkflowResource(ctx, obj)
+		})
+	}
+	return nil
+}
+
+// 组装workflow的deployment参数
+func workflowDeployCreate(data *WorkflowCreate) *DeployCreate {
+	return &DeployCreate{
+		Name:          data.deploymentName(),
 		Namespace:     data.Namespace,
 		Replicas:      data.Replicas,
 		Image:         data.Image,
@@ -118,21 +269,17 @@ func createWorkflowRes(data *WorkflowCreate) (err error) {
 		HealthCheck:   data.HealthCheck,
 		HealthPath:    data.HealthPath,
 	}
-	//创建deployment
-	err = Deployment.CreateDeployment(dc)
-	if err != nil {
-		return err
-	}
+}
+
+// 组装workflow的service参数，Ingress类型的workflow使用ClusterIP类型的service
+func workflowServiceCreate(data *WorkflowCreate) *ServiceCreate {
 	//判断service类型
-	if data.Type != "Ingress" {
-		serviceType = data.Type
-	} else {
+	serviceType := data.Type
+	if data.Type == "Ingress" {
 		serviceType = "ClusterIP"
 	}
-
-	//组装ServiceCreate类型的数据
-	sc := &ServiceCreate{
-		Name:          getServiceName(data.Name),
+	return &ServiceCreate{
+		Name:          data.serviceName(),
 		Namespace:     data.Namespace,
 		Type:          serviceType,
 		ContainerPort: data.ContainerPort,
@@ -140,49 +287,67 @@ func createWorkflowRes(data *WorkflowCreate) (err error) {
 		NodePort:      data.NodePort,
 		Label:         data.Label,
 	}
-	err = Servicev1.CreateService(sc)
+}
+
+// 封装删除workflow对应的k8s资源
+// 按创建的相反顺序删除，已经不存在的资源视为删除成功，所以可以重复执行
+func delWorkflowRes(ctx context.Context, workflow *model.Workflow) (err error) {
+	resources, err := workflowResources(workflow)
 	if err != nil {
 		return err
 	}
-	//组装IngressCreate类型的数据，创建ingress，只有ingress类型的workflow才有ingress资源，所以这里做了一层判断
-	if data.Type == "Ingress" {
-		ic := &IngressCreate{
-			Name:      getIngressName(data.Name),
-			Namespace: data.Namespace,
-			Label:     data.Label,
-			Hosts:     data.Hosts,
+	for _, obj := range resources {
+		if isPostDeployResource(obj) {
+			if err = deleteWorkflowResource(ctx, obj); err != nil {
+				return err
+			}
 		}
-		err = Ingress.CreateIngress(ic)
+	}
+	//删除ingress，这里多了一层判断，因为只有type为ingress的workflow才有ingress资源
+	if workflow.Type == "Ingress" {
+		err = ignoreNotFound(Ingress.DeleteIngress(withoutTrash(ctx), recordIngressName(workflow), workflow.Namespace))
 		if err != nil {
 			return err
 		}
 	}
-	return nil
-}
-
-// 封装删除workflow对应的k8s资源
-func delWorkflowRes(workflow *model.Workflow) (err error) {
-	//删除deployment
-	err = Deployment.DeleteDeployment(workflow.Name, workflow.Namespace)
+	//删除service
+	err = ignoreNotFound(Servicev1.DeleteService(withoutTrash(ctx), recordServiceName(workflow), workflow.Namespace))
 	if err != nil {
 		return err
 	}
-	//删除service
-	err = Servicev1.DeleteService(getServiceName(workflow.Name), workflow.Namespace)
+	//删除deployment
+	err = ignoreNotFound(Deployment.DeleteDeployment(withoutTrash(ctx), recordDeploymentName(workflow), workflow.Namespace))
 	if err != nil {
 		return err
 	}
-	//删除ingress，这里多了一层判断，因为只有type为ingress的workflow才有ingress资源
-	if workflow.Type == "Ingress" {
-		err = Ingress.DeleteIngress(getIngressName(workflow.Name), workflow.Namespace)
-		if err != nil {
-			return err
+	//最后删除其他附加资源
+	for i := len(resources) - 1; i >= 0; i-- {
+		if !isPostDeployResource(resources[i]) {
+			if err = deleteWorkflowResource(ctx, resources[i]); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
+// 资源不存在的错误视为删除成功
+func ignoreNotFound(err error) error {
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// 回滚和记录失败状态的超时时间
+const workflowCleanupTimeout = 30 * time.Second
+
+// 返回一个不随请求取消的ctx，保留请求ctx中的日志等信息
+func detachedContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), workflowCleanupTimeout)
+}
+
 // workflow名字转换成service名字，添加-svc后缀
 func getServiceName(workflowName string) (serviceName string) {
 	return workflowName + "-svc"
@@ -192,3 +357,62 @@ func getServiceName(workflowName string) (serviceName string) {
 func getIngressName(workflowName string) (ingressName string) {
 	return workflowName + "-ing"
 }
+
+// workflow的deployment名，默认与workflow同名
+func (c *WorkflowCreate) deploymentName() string {
+	if c.DeploymentName != "" {
+		return c.DeploymentName
+	}
+	return c.Name
+}
+
+// workflow的service名，默认为workflow名加-svc后缀
+func (c *WorkflowCreate) serviceName() string {
+	if c.ServiceName != "" {
+		return c.ServiceName
+	}
+	return getServiceName(c.Name)
+}
+
+// workflow的ingress名，默认为workflow名加-ing后缀
+func (c *WorkflowCreate) ingressName() string {
+	if c.IngressName != "" {
+		return c.IngressName
+	}
+	return getIngressName(c.Name)
+}
+
+// workflow记录中的deployment名，导入的workflow沿用原有的资源名
+func recordDeploymentName(workflow *model.Workflow) string {
+	if workflow.Deployment != "" {
+		return workflow.Deployment
+	}
+	return workflow.Name
+}
+
+// workflow记录中的service名
+func recordServiceName(workflow *model.Workflow) string {
+	if workflow.Service != "" {
+		return workflow.Service
+	}
+	return getServiceName(workflow.Name)
+}
+
+// workflow记录中的ingress名，非Ingress类型的workflow记录中没有ingress名，按命名约定生成
+func recordIngressName(workflow *model.Workflow) string {
+	if workflow.Ingress != "" {
+		return workflow.Ingress
+	}
+	return getIngressName(workflow.Name)
+}
+
+// 参数中的名称和资源名以workflow记录为准
+func setSpecNames(spec *WorkflowCreate, workflow *model.Workflow) {
+	spec.Name = workflow.Name
+	spec.Namespace = workflow.Namespace
+	spec.DeploymentName = recordDeploymentName(workflow)
+	spec.ServiceName = recordServiceName(workflow)
+	if workflow.Ingress != "" {
+		spec.IngressName = workflow.Ingress
+	}
+}
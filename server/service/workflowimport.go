@@ -0,0 +1,388 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"k8s-server/dao"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	nwv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// 数据库中workflow名和资源名的最大长度，见model.Workflow的建表语句
+const workflowNameMaxLen = 32
+
+// 定义WorkflowImportCandidate结构体，发现的可以导入为workflow的已有资源
+// Service是selector与deployment的pod标签匹配的service，Ingress是后端指向该service的ingress
+// Conflict不为空时表示不能导入，内容为原因
+type WorkflowImportCandidate struct {
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+	Service    string `json:"service"`
+	Ingress    string `json:"ingress"`
+	Type       string `json:"type"`
+	Replicas   int32  `json:"replicas"`
+	Image      string `json:"image"`
+	Conflict   string `json:"conflict,omitempty"`
+
+	//资源本身导致的冲突，与名称无关，导入时指定新名称也不能导入
+	resourceConflict string
+}
+
+// 定义WorkflowImportItem结构体，确认导入的deployment，Name为空时使用发现时建议的workflow名
+type WorkflowImportItem struct {
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+	Name       string `json:"name"`
+}
+
+// 定义WorkflowImportResult结构体，单个deployment的导入结果，失败时Error为失败原因
+type WorkflowImportResult struct {
+	Namespace  string `json:"namespace"`
+	Deployment string `json:"deployment"`
+	Name       string `json:"name"`
+	ID         uint   `json:"id"`
+	Error      string `json:"error,omitempty"`
+}
+
+// 发现未被workflow管理的deployment，namespace为空时查询所有命名空间
+// 为每个deployment找到selector匹配的service和指向该service的ingress，生成建议的workflow记录
+func (w *workflow) DiscoverWorkflows(ctx context.Context, namespace string) (candidates []*WorkflowImportCandidate, err error) {
+	workflows, err := dao.Workflow.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deploys, err := K8sClientSet.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Deployment列表失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Deployment列表失败")
+	}
+	svcs, err := K8sClientSet.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Service列表失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Service列表失败")
+	}
+	ings, err := K8sClientSet.NetworkingV1().Ingresses(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Ingress列表失败, ")).Msg(err.Error())
+		return nil, errors.Wrap(err, "获取Ingress列表失败")
+	}
+
+	//已被workflow管理的资源和已使用的workflow名
+	managedDeploys := make(map[string]bool)
+	managedSvcs := make(map[string]bool)
+	names := make(map[string]bool)
+	for _, workflow := range workflows {
+		managedDeploys[workflow.Namespace+"/"+recordDeploymentName(workflow)] = true
+		managedSvcs[workflow.Namespace+"/"+recordServiceName(workflow)] = true
+		names[workflow.Name] = true
+	}
+
+	items := deploys.Items
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Namespace != items[j].Namespace {
+			return items[i].Namespace < items[j].Namespace
+		}
+		return items[i].Name < items[j].Name
+	})
+	candidates = make([]*WorkflowImportCandidate, 0)
+	for i := range items {
+		deploy := &items[i]
+		if managedDeploys[deploy.Namespace+"/"+deploy.Name] {
+			continue
+		}
+		candidate := discoverWorkflow(deploy, svcs.Items, ings.Items, managedSvcs)
+		//workflow名全局唯一，不同命名空间下的同名deployment使用"名称-命名空间"
+		if names[candidate.Name] {
+			candidate.Name = fmt.Sprintf("%s-%s", deploy.Name, deploy.Namespace)
+		}
+		candidate.Conflict = candidate.resourceConflict
+		if candidate.Conflict == "" {
+			candidate.Conflict = checkImportNames(candidate)
+		}
+		if candidate.Conflict == "" && names[candidate.Name] {
+			candidate.Conflict = "Workflow名已被使用: " + candidate.Name
+		}
+		names[candidate.Name] = true
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+// 根据发现的结果导入workflow，逐个导入，单个失败不影响其他deployment
+// 导入只新增数据库记录，不修改集群中的资源，已有的资源名原样保留
+func (w *workflow) ImportWorkflows(ctx context.Context, items []*WorkflowImportItem) (results []*WorkflowImportResult, err error) {
+	if len(items) == 0 {
+		return nil, errcode.InvalidParam.New("导入的Deployment不能为空")
+	}
+	//同一命名空间只发现一次
+	discovered := make(map[string][]*WorkflowImportCandidate)
+	results = make([]*WorkflowImportResult, 0, len(items))
+	for _, item := range items {
+		result := &WorkflowImportResult{
+			Namespace:  item.Namespace,
+			Deployment: item.Deployment,
+			Name:       item.Name,
+		}
+		results = append(results, result)
+
+		candidates, ok := discovered[item.Namespace]
+		if !ok {
+			if item.Namespace == "" {
+				result.Error = "命名空间不能为空"
+				continue
+			}
+			candidates, err = w.DiscoverWorkflows(ctx, item.Namespace)
+			if err != nil {
+				return nil, err
+			}
+			discovered[item.Namespace] = candidates
+		}
+		workflow, err := w.importWorkflow(ctx, item, candidates)
+		if err != nil {
+			utils.Log(ctx).Error().Stack().Err(errors.New("导入Workflow失败, ")).Msg(err.Error())
+			result.Error = err.Error()
+			continue
+		}
+		result.Name = workflow.Name
+		result.ID = workflow.ID
+	}
+	return results, nil
+}
+
+// 导入单个deployment
+// 以集群中资源的当前状态生成workflow参数，新增状态为Running的记录，并保存导入版本
+func (w *workflow) importWorkflow(ctx context.Context, item *WorkflowImportItem, candidates []*WorkflowImportCandidate) (*model.Workflow, error) {
+	var candidate *WorkflowImportCandidate
+	for _, c := range candidates {
+		if c.Deployment == item.Deployment {
+			candidate = c
+			break
+		}
+	}
+	if candidate == nil {
+		return nil, errcode.NotFound.Newf("Deployment不存在或已被Workflow管理: %s/%s", item.Namespace, item.Deployment)
+	}
+	if candidate.resourceConflict != "" {
+		return nil, errcode.Conflict.New(candidate.resourceConflict)
+	}
+	if item.Name != "" && item.Name != candidate.Name {
+		candidate.Name = item.Name
+		if conflict := checkImportNames(candidate); conflict != "" {
+			return nil, errcode.InvalidParam.New(conflict)
+		}
+	} else if candidate.Conflict != "" {
+		return nil, errcode.Conflict.New(candidate.Conflict)
+	}
+
+	if err := w.reserveName(ctx, candidate.Name); err != nil {
+		return nil, err
+	}
+	workflow := &model.Workflow{
+		Name:       candidate.Name,
+		Namespace:  candidate.Namespace,
+		Deployment: candidate.Deployment,
+		Service:    candidate.Service,
+		Ingress:    candidate.Ingress,
+		Type:       candidate.Type,
+	}
+	spec, err := currentWorkflowSpec(ctx, workflow)
+	if err != nil {
+		return nil, err
+	}
+	if spec.ContainerPort == 0 {
+		spec.ContainerPort, err = resolveContainerPort(ctx, spec)
+		if err != nil {
+			return nil, err
+		}
+	}
+	setIngressBackends(spec)
+	if err = validateWorkflowSpec(spec); err != nil {
+		return nil, err
+	}
+	revision, err := newWorkflowRevision(ctx, spec, model.RevisionImport, "导入已有资源")
+	if err != nil {
+		return nil, err
+	}
+
+	setWorkflowSpec(workflow, spec)
+	if err = dao.Workflow.Add(ctx, workflow); err != nil {
+		return nil, err
+	}
+	if err = dao.Workflow.SaveWithRevision(ctx, workflow, revision); err != nil {
+		//集群中的资源不是由workflow创建的，保存失败时只删除记录
+		cleanupCtx, cancel := detachedContext(ctx)
+		defer cancel()
+		if purgeErr := dao.Workflow.Purge(cleanupCtx, workflow.ID); purgeErr != nil {
+			utils.Log(ctx).Error().Stack().Err(errors.New("清理Workflow记录失败, ")).Msg(purgeErr.Error())
+		}
+		return nil, err
+	}
+	return workflow, nil
+}
+
+// 为deployment找到匹配的service和ingress，生成建议的workflow记录
+func discoverWorkflow(deploy *appsv1.Deployment, svcs []corev1.Service, ings []nwv1.Ingress, managedSvcs map[string]bool) *WorkflowImportCandidate {
+	candidate := &WorkflowImportCandidate{
+		Name:       deploy.Name,
+		Namespace:  deploy.Namespace,
+		Deployment: deploy.Name,
+	}
+	if deploy.Spec.Replicas != nil {
+		candidate.Replicas = *deploy.Spec.Replicas
+	}
+	if len(deploy.Spec.Template.Spec.Containers) == 0 {
+		candidate.resourceConflict = "Deployment中没有容器"
+		return candidate
+	}
+	candidate.Image = deploy.Spec.Template.Spec.Containers[0].Image
+
+	svc := matchWorkflowService(deploy, svcs)
+	if svc == nil {
+		candidate.resourceConflict = "没有selector匹配的Service"
+		return candidate
+	}
+	candidate.Service = svc.Name
+	candidate.Type = string(svc.Spec.Type)
+	switch {
+	case managedSvcs[svc.Namespace+"/"+svc.Name]:
+		candidate.resourceConflict = "Service已被其他Workflow管理: " + svc.Name
+		return candidate
+	case svc.Spec.Type != corev1.ServiceTypeClusterIP && svc.Spec.Type != corev1.ServiceTypeNodePort:
+		candidate.resourceConflict = "不支持的Service类型: " + candidate.Type
+		return candidate
+	case len(svc.Spec.Ports) == 0:
+		candidate.resourceConflict = "Service没有端口: " + svc.Name
+		return candidate
+	}
+
+	//ClusterIP类型的service有ingress指向时，作为Ingress类型的workflow导入
+	if svc.Spec.Type != corev1.ServiceTypeClusterIP {
+		return candidate
+	}
+	ing := matchWorkflowIngress(svc, ings)
+	if ing == nil {
+		return candidate
+	}
+	candidate.Ingress = ing.Name
+	candidate.Type = "Ingress"
+	for _, name := range ingressBackendServices(ing) {
+		if name != svc.Name {
+			candidate.resourceConflict = "Ingress中包含其他Service的后端: " + ing.Name
+			break
+		}
+	}
+	return candidate
+}
+
+// selector是deployment的pod标签子集的service，有多个时取selector最长的，再按名称排序
+func matchWorkflowService(deploy *appsv1.Deployment, svcs []corev1.Service) *corev1.Service {
+	var matched *corev1.Service
+	for i := range svcs {
+		svc := &svcs[i]
+		if svc.Namespace != deploy.Namespace || len(svc.Spec.Selector) == 0 {
+			continue
+		}
+		if !selectorMatches(svc.Spec.Selector, deploy.Spec.Template.Labels) {
+			continue
+		}
+		if matched == nil ||
+			len(svc.Spec.Selector) > len(matched.Spec.Selector) ||
+			(len(svc.Spec.Selector) == len(matched.Spec.Selector) && svc.Name < matched.Name) {
+			matched = svc
+		}
+	}
+	return matched
+}
+
+// 后端指向service的ingress，有多个时按名称排序取第一个
+func matchWorkflowIngress(svc *corev1.Service, ings []nwv1.Ingress) *nwv1.Ingress {
+	var matched *nwv1.Ingress
+	for i := range ings {
+		ing := &ings[i]
+		if ing.Namespace != svc.Namespace {
+			continue
+		}
+		for _, name := range ingressBackendServices(ing) {
+			if name == svc.Name && (matched == nil || ing.Name < matched.Name) {
+				matched = ing
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// ingress中所有后端的service名
+func ingressBackendServices(ing *nwv1.Ingress) (names []string) {
+	if backend := ing.Spec.DefaultBackend; backend != nil && backend.Service != nil {
+		names = append(names, backend.Service.Name)
+	}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil {
+				names = append(names, path.Backend.Service.Name)
+			}
+		}
+	}
+	return names
+}
+
+// selector中的标签是否都在labels中
+func selectorMatches(selector, labels map[string]string) bool {
+	for k, v := range selector {
+		if value, ok := labels[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// 检查workflow名和资源名的长度，超过数据库字段长度的不能导入
+func checkImportNames(candidate *WorkflowImportCandidate) string {
+	if candidate.Name == "" {
+		return "Workflow名不能为空"
+	}
+	for _, name := range []string{candidate.Name, candidate.Deployment, candidate.Service, candidate.Ingress} {
+		if len(name) > workflowNameMaxLen {
+			return fmt.Sprintf("名称超过%d个字符: %s", workflowNameMaxLen, name)
+		}
+	}
+	return ""
+}
+
+// service的targetPort为端口名或未设置时，从deployment的第一个容器中解析出容器端口
+func resolveContainerPort(ctx context.Context, spec *WorkflowCreate) (int32, error) {
+	svc, err := Servicev1.GetServicetDetail(ctx, spec.serviceName(), spec.Namespace)
+	if err != nil {
+		return 0, err
+	}
+	deploy, err := Deployment.GetDeploymentDetail(ctx, spec.deploymentName(), spec.Namespace)
+	if err != nil {
+		return 0, err
+	}
+	port := svc.Spec.Ports[0]
+	if port.TargetPort.Type == intstr.Int && port.TargetPort.IntVal == 0 {
+		//未设置targetPort时与port相同
+		return port.Port, nil
+	}
+	for _, containerPort := range deploy.Spec.Template.Spec.Containers[0].Ports {
+		if containerPort.Name == port.TargetPort.StrVal {
+			return containerPort.ContainerPort, nil
+		}
+	}
+	return 0, errcode.Invalid.Newf("无法解析Service的targetPort: %s", port.TargetPort.StrVal)
+}
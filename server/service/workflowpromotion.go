@@ -0,0 +1,345 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8s-server/dao"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// workflow的环境提升
+// 将workflow当前的版本复制到下一个环境(按顺序排列的下一个命名空间)，应用目标环境的覆盖参数，
+// 目标workflow名为去掉源环境后缀的名称加上目标环境名，k8s资源名同样去掉源环境后缀，各环境中的资源同名
+// 目标环境配置了审批角色时，提升需要拥有该角色的用户审批后才执行
+var WorkflowPromotion workflowPromotion
+
+type workflowPromotion struct{}
+
+// 获取提升记录列表，id为0时查询所有workflow，status为空时查询所有状态
+func (p *workflowPromotion) GetList(ctx context.Context, id int, status string, page, limit int) (data *dao.WorkflowPromotionResp, err error) {
+	var workflowID uint
+	if id != 0 {
+		workflow, err := getWorkflow(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		workflowID = workflow.ID
+	}
+	return dao.WorkflowPromotion.GetList(ctx, workflowID, status, page, limit)
+}
+
+// 获取单条提升记录，包含应用覆盖参数后的完整参数，secret的内容被隐藏
+func (p *workflowPromotion) GetDetail(ctx context.Context, id int) (*model.WorkflowPromotion, error) {
+	promotion, err := p.get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	redacted := *promotion
+	redacted.Spec = redactWorkflowSpec(promotion.Spec)
+	return &redacted, nil
+}
+
+// 获取单条提升记录，审批后按其中的完整参数执行
+func (p *workflowPromotion) get(ctx context.Context, id int) (*model.WorkflowPromotion, error) {
+	promotion, err := dao.WorkflowPromotion.GetById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if promotion == nil {
+		return nil, errcode.NotFound.Newf("提升记录不存在: %d", id)
+	}
+	return promotion, nil
+}
+
+// 将workflow当前的版本提升到下一个环境
+// 目标环境不需要审批时直接执行，否则保存为等待审批的提升记录
+func (p *workflowPromotion) Promote(ctx context.Context, id int) (*model.WorkflowPromotion, error) {
+	workflow, err := getWorkflow(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if workflow.Status != model.WorkflowRunning && workflow.Status != model.WorkflowDegraded {
+		return nil, errcode.Conflict.Newf("Workflow状态为%s, 不能提升", workflow.Status)
+	}
+	from, to, err := nextEnvironment(ctx, workflow.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	//发起人为空时任何审批人都不等于发起人，需要审批的环境必须登录才能发起提升
+	operator := utils.UserFromContext(ctx)
+	if to.ApproverRole != "" && operator == "" {
+		return nil, errcode.Unauthorized.Newf("提升到%s环境需要审批, 发起提升需要登录", to.Name)
+	}
+	revs, err := dao.WorkflowRevision.GetList(ctx, workflow.ID, 1, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(revs.Items) == 0 {
+		return nil, errcode.Invalid.New("Workflow没有历史版本: " + workflow.Name)
+	}
+	rev, err := WorkflowRevision.get(ctx, workflow, revs.Items[0].Revision)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := decodeRevisionSpec(rev)
+	if err != nil {
+		return nil, err
+	}
+	setSpecNames(spec, workflow)
+
+	//目标workflow名为去掉源环境后缀的名称加上目标环境名，例如shop-staging提升到prod为shop-prod
+	baseName := strings.TrimSuffix(workflow.Name, "-"+from.Name)
+	targetName := baseName + "-" + to.Name
+	if len(targetName) > workflowNameMaxLen {
+		return nil, errcode.Invalid.Newf("目标Workflow名超过%d个字符: %s", workflowNameMaxLen, targetName)
+	}
+	applyEnvironment(spec, baseName, targetName, to)
+	if err = validateWorkflowSpec(spec); err != nil {
+		return nil, err
+	}
+	if err = normalizeWorkflowResources(spec.Namespace, spec.Resources); err != nil {
+		return nil, err
+	}
+	pending, err := dao.WorkflowPromotion.GetPending(ctx, targetName)
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		return nil, errcode.Conflict.Newf("%s已有等待审批的提升: %d", targetName, pending.ID)
+	}
+
+	promotion := &model.WorkflowPromotion{
+		WorkflowID:   workflow.ID,
+		WorkflowName: workflow.Name,
+		Revision:     rev.Revision,
+		FromEnv:      from.Name,
+		ToEnv:        to.Name,
+		TargetName:   targetName,
+		Spec:         encodeWorkflowSpec(spec),
+		ApproverRole: to.ApproverRole,
+		Status:       model.PromotionApproved,
+		Operator:     operator,
+	}
+	if to.ApproverRole != "" {
+		promotion.Status = model.PromotionPending
+		promotion.Message = fmt.Sprintf("等待拥有%s角色的用户审批", to.ApproverRole)
+	}
+	if err = dao.WorkflowPromotion.Save(ctx, promotion); err != nil {
+		return nil, err
+	}
+	if promotion.Status == model.PromotionPending {
+		utils.Log(ctx).Info().Str("workflow", workflow.Name).Str("to", to.Name).Msg("Workflow提升等待审批")
+		return promotion, nil
+	}
+	return promotion, p.execute(ctx, promotion)
+}
+
+// 审批通过并执行提升，审批人需要拥有目标环境配置的角色，且不能是发起人
+func (p *workflowPromotion) Approve(ctx context.Context, id int) (*model.WorkflowPromotion, error) {
+	promotion, approver, err := p.decide(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := dao.WorkflowPromotion.UpdateStatusIf(ctx, promotion.ID, model.PromotionPending, model.PromotionApproved, approver, "")
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, errcode.Conflict.Newf("提升记录已被处理: %d", promotion.ID)
+	}
+	promotion.Status = model.PromotionApproved
+	promotion.Approver = approver
+	promotion.Message = ""
+	return promotion, p.execute(ctx, promotion)
+}
+
+// 拒绝提升
+func (p *workflowPromotion) Reject(ctx context.Context, id int, reason string) error {
+	promotion, approver, err := p.decide(ctx, id)
+	if err != nil {
+		return err
+	}
+	updated, err := dao.WorkflowPromotion.UpdateStatusIf(ctx, promotion.ID, model.PromotionPending, model.PromotionRejected, approver, reason)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return errcode.Conflict.Newf("提升记录已被处理: %d", promotion.ID)
+	}
+	return nil
+}
+
+// 获取等待审批的提升记录，并校验当前用户是否拥有审批角色，且不是提升的发起人
+func (p *workflowPromotion) decide(ctx context.Context, id int) (*model.WorkflowPromotion, string, error) {
+	promotion, err := p.get(ctx, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if promotion.Status != model.PromotionPending {
+		return nil, "", errcode.Conflict.Newf("提升记录状态为%s, 不能审批", promotion.Status)
+	}
+	approver := utils.UserFromContext(ctx)
+	if approver == "" {
+		return nil, "", errcode.Unauthorized.New("审批需要登录")
+	}
+	if approver == promotion.Operator {
+		return nil, "", errcode.Forbidden.New("不能审批自己发起的提升")
+	}
+	if !Login.HasRole(approver, promotion.ApproverRole) {
+		return nil, "", errcode.Forbidden.Newf("需要%s角色才能审批", promotion.ApproverRole)
+	}
+	return promotion, approver, nil
+}
+
+// 执行提升，目标workflow不存在时创建，已存在时修改为提升的参数并保存一个历史版本，结果记录在提升记录中
+func (p *workflowPromotion) execute(ctx context.Context, promotion *model.WorkflowPromotion) (err error) {
+	defer func() {
+		promotion.Status = model.PromotionSucceeded
+		promotion.Message = ""
+		if err != nil {
+			promotion.Status = model.PromotionFailed
+			promotion.Message = err.Error()
+		}
+		saveCtx, cancel := detachedContext(ctx)
+		defer cancel()
+		if saveErr := dao.WorkflowPromotion.Save(saveCtx, promotion); saveErr != nil && err == nil {
+			err = saveErr
+		}
+	}()
+
+	spec := &WorkflowCreate{}
+	if err = json.Unmarshal([]byte(promotion.Spec), spec); err != nil {
+		return errors.Wrap(err, "解析提升参数失败")
+	}
+	message := fmt.Sprintf("从%s环境提升%s的版本%d", promotion.FromEnv, promotion.WorkflowName, promotion.Revision)
+	target, err := dao.Workflow.GetByName(ctx, promotion.TargetName)
+	if err != nil {
+		return err
+	}
+	if target == nil || target.DeletedAt != nil || target.Status == model.WorkflowFailed {
+		if err = Workflow.create(ctx, spec, model.RevisionPromote, message, "", 0); err != nil {
+			return err
+		}
+		if target, err = dao.Workflow.GetByName(ctx, promotion.TargetName); err != nil {
+			return err
+		}
+	} else if err = promoteWorkflow(ctx, target, spec, message); err != nil {
+		return err
+	}
+	if target != nil {
+		promotion.TargetID = target.ID
+	}
+	utils.Log(ctx).Info().Str("workflow", promotion.WorkflowName).Str("target", promotion.TargetName).
+		Str("to", promotion.ToEnv).Msg("Workflow提升成功")
+	return nil
+}
+
+// 将已存在的目标workflow修改为提升的参数，失败时恢复为修改前的状态
+func promoteWorkflow(ctx context.Context, target *model.Workflow, spec *WorkflowCreate, message string) error {
+	if target.Status == model.WorkflowCreating || target.Status == model.WorkflowDeleting ||
+		target.Status == model.WorkflowReleasing {
+		return errcode.Conflict.Newf("目标Workflow状态为%s, 不能提升", target.Status)
+	}
+	setSpecNames(spec, target)
+	if err := validateWorkflowSpec(spec); err != nil {
+		return err
+	}
+	if err := normalizeWorkflowResources(spec.Namespace, spec.Resources); err != nil {
+		return err
+	}
+	revision, err := newWorkflowRevision(ctx, spec, model.RevisionPromote, message)
+	if err != nil {
+		return err
+	}
+	current, err := currentWorkflowSpec(ctx, target)
+	if err != nil {
+		return err
+	}
+	if err = applyWorkflowSpec(ctx, spec); err != nil {
+		return restoreWorkflowRes(ctx, current, err)
+	}
+	setWorkflowSpec(target, spec)
+	if err = dao.Workflow.SaveWithRevision(ctx, target, revision); err != nil {
+		return restoreWorkflowRes(ctx, current, err)
+	}
+	return nil
+}
+
+// 获取命名空间所属的环境和下一个环境
+func nextEnvironment(ctx context.Context, namespace string) (from, to *model.Environment, err error) {
+	environments, err := dao.Environment.GetList(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, env := range environments {
+		if env.Namespace != namespace {
+			continue
+		}
+		if i == len(environments)-1 {
+			return nil, nil, errcode.Invalid.New("已经是最后一个环境: " + env.Name)
+		}
+		return env, environments[i+1], nil
+	}
+	return nil, nil, errcode.Invalid.Newf("命名空间%s不属于任何环境", namespace)
+}
+
+// 将参数转换到目标环境并应用目标环境的覆盖参数
+// 资源名以源workflow名开头时换成去掉环境后缀的名称，例如shop-staging-svc在目标环境中为shop-svc，导入时沿用的其他资源名保持不变
+func applyEnvironment(spec *WorkflowCreate, baseName, targetName string, env *model.Environment) {
+	rebase := func(name string) string {
+		if strings.HasPrefix(name, spec.Name) {
+			return baseName + strings.TrimPrefix(name, spec.Name)
+		}
+		return name
+	}
+	spec.DeploymentName = rebase(spec.deploymentName())
+	spec.ServiceName = rebase(spec.serviceName())
+	spec.IngressName = rebase(spec.ingressName())
+	spec.Name = targetName
+	spec.Namespace = env.Namespace
+	for _, obj := range spec.Resources {
+		obj.SetNamespace("")
+	}
+	if env.Replicas != nil {
+		spec.Replicas = *env.Replicas
+	}
+	if env.Cpu != "" {
+		spec.Cpu = env.Cpu
+	}
+	if env.Memory != "" {
+		spec.Memory = env.Memory
+	}
+
+	//覆盖域名时，每个域名使用源workflow所有域名下的路径
+	var hosts []string
+	_ = json.Unmarshal([]byte(env.Hosts), &hosts)
+	if spec.Type == "Ingress" && len(hosts) > 0 {
+		sourceHosts := make([]string, 0, len(spec.Hosts))
+		for host := range spec.Hosts {
+			sourceHosts = append(sourceHosts, host)
+		}
+		sort.Strings(sourceHosts)
+		var paths []*HttpPath
+		seen := make(map[string]bool)
+		for _, host := range sourceHosts {
+			for _, path := range spec.Hosts[host] {
+				if !seen[path.Path] {
+					seen[path.Path] = true
+					paths = append(paths, path)
+				}
+			}
+		}
+		spec.Hosts = make(map[string][]*HttpPath, len(hosts))
+		for _, host := range hosts {
+			spec.Hosts[strings.ReplaceAll(host, "{name}", baseName)] = paths
+		}
+	}
+	setIngressBackends(spec)
+}
@@ -0,0 +1,869 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8s-server/config"
+	"k8s-server/dao"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	nwv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// workflow的金丝雀发布和蓝绿发布
+// 新版本以第二个deployment运行，发布期间workflow的service在selector中加上旧版本ReplicaSet的pod-template-hash，只选择旧版本的pod
+// 金丝雀发布为新版本创建单独的service和带ingress-nginx canary注解的ingress，按配置的比例逐步调整流量，
+// 蓝绿发布在新版本就绪后将service的selector切换到新版本的pod
+// 后台定时检查新版本的就绪状态和pod重启次数，超过阈值时自动回退，流量全部切换后等待确认(promote)或放弃(abort)
+var WorkflowReleaser = &workflowReleaser{}
+
+type workflowReleaser struct {
+	once sync.Once
+	//后台推进发布与确认、放弃发布互斥执行
+	mu sync.Mutex
+}
+
+// 区分新旧版本pod的标签，以及ingress-nginx的canary注解
+const (
+	releaseTrackLabel         = "workflow-track"
+	releaseCanaryTrack        = "canary"
+	canaryAnnotation          = "nginx.ingress.kubernetes.io/canary"
+	canaryWeightAnnotation    = "nginx.ingress.kubernetes.io/canary-weight"
+	deploymentRevisionAnnoKey = "deployment.kubernetes.io/revision"
+)
+
+// 定义WorkflowReleaseCreate结构体，用于开始一次发布，为空的字段使用配置文件中的默认值
+// Replicas为新版本的副本数，金丝雀发布默认为1，蓝绿发布默认与当前版本相同
+// Steps为金丝雀发布每一步的流量比例(百分比)，StepInterval、ReadyTimeout的单位为秒
+type WorkflowReleaseCreate struct {
+	ID           int    `json:"id"`
+	Strategy     string `json:"strategy"`
+	Image        string `json:"image"`
+	Replicas     *int32 `json:"replicas"`
+	Steps        []int  `json:"steps"`
+	StepInterval int    `json:"step_interval"`
+	ReadyTimeout int    `json:"ready_timeout"`
+	MaxRestarts  *int32 `json:"max_restarts"`
+}
+
+// 定义WorkflowReleaseDetail结构体，Steps为解析后的流量比例，ReadyReplicas和Restarts为新版本的实时状态
+type WorkflowReleaseDetail struct {
+	*model.WorkflowRelease
+	Steps         []int  `json:"steps"`
+	ReadyReplicas int32  `json:"ready_replicas"`
+	Restarts      int32  `json:"restarts"`
+	Error         string `json:"error,omitempty"`
+}
+
+// 获取workflow的发布记录列表
+func (r *workflowReleaser) GetList(ctx context.Context, id int, page, limit int) (data *dao.WorkflowReleaseResp, err error) {
+	workflow, err := getWorkflow(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return dao.WorkflowRelease.GetList(ctx, workflow.ID, page, limit)
+}
+
+// 获取workflow最近一次发布，进行中的发布附带新版本的实时状态
+func (r *workflowReleaser) GetDetail(ctx context.Context, id int) (*WorkflowReleaseDetail, error) {
+	workflow, err := getWorkflow(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	release, err := dao.WorkflowRelease.GetLatest(ctx, workflow.ID)
+	if err != nil {
+		return nil, err
+	}
+	if release == nil {
+		return nil, errcode.NotFound.New("Workflow没有发布记录: " + workflow.Name)
+	}
+	detail := &WorkflowReleaseDetail{WorkflowRelease: release}
+	_ = json.Unmarshal([]byte(release.Steps), &detail.Steps)
+	if !releaseActive(release) {
+		return detail, nil
+	}
+	deploy, err := K8sClientSet.AppsV1().Deployments(workflow.Namespace).Get(ctx, release.Deployment, metav1.GetOptions{})
+	if err != nil {
+		detail.Error = err.Error()
+		return detail, nil
+	}
+	detail.ReadyReplicas = deploy.Status.ReadyReplicas
+	if detail.Restarts, err = releaseRestarts(ctx, deploy); err != nil {
+		detail.Error = err.Error()
+	}
+	return detail, nil
+}
+
+// 开始一次发布
+// 创建新版本的deployment，金丝雀发布还会创建新版本的service和权重为0的canary ingress，
+// 之后由后台根据新版本的状态推进，任一步骤失败时删除已创建的资源并恢复workflow的状态
+func (r *workflowReleaser) Create(ctx context.Context, data *WorkflowReleaseCreate) (release *model.WorkflowRelease, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	workflow, err := getWorkflow(ctx, data.ID)
+	if err != nil {
+		return nil, err
+	}
+	if workflow.Status != model.WorkflowRunning && workflow.Status != model.WorkflowDegraded {
+		return nil, errcode.Conflict.Newf("Workflow状态为%s, 不能发布", workflow.Status)
+	}
+	stable, err := Deployment.GetDeploymentDetail(ctx, recordDeploymentName(workflow), workflow.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	release, err = newWorkflowRelease(ctx, workflow, stable, data)
+	if err != nil {
+		return nil, err
+	}
+	svc, err := Servicev1.GetServicetDetail(ctx, recordServiceName(workflow), workflow.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(svc.Spec.Selector) == 0 {
+		return nil, errcode.Invalid.New("Service没有selector: " + svc.Name)
+	}
+	selector, _ := json.Marshal(svc.Spec.Selector)
+	release.ServiceSelector = string(selector)
+	var ing *nwv1.Ingress
+	if release.Strategy == model.ReleaseCanary {
+		ing, err = K8sClientSet.NetworkingV1().Ingresses(workflow.Namespace).Get(ctx, recordIngressName(workflow), metav1.GetOptions{})
+		if err != nil {
+			utils.Log(ctx).Error().Stack().Err(errors.New("获取Ingress详情失败")).Msg(err.Error())
+			return nil, errors.Wrap(err, "获取Ingress详情失败")
+		}
+	}
+
+	updated, err := dao.Workflow.UpdateStatusIf(ctx, workflow.ID, workflow.Status, model.WorkflowReleasing, "")
+	if err != nil {
+		return nil, err
+	}
+	if !updated {
+		return nil, errcode.Conflict.New("Workflow状态已变化, 请重试")
+	}
+	defer func() {
+		if err != nil {
+			cleanupCtx, cancel := detachedContext(ctx)
+			defer cancel()
+			if statusErr := dao.Workflow.UpdateStatus(cleanupCtx, workflow.ID, workflow.Status, workflow.Message); statusErr != nil {
+				utils.Log(ctx).Error().Stack().Err(errors.New("恢复Workflow状态失败, ")).Msg(statusErr.Error())
+			}
+		}
+	}()
+
+	if err = startRelease(ctx, release, stable, svc, ing); err != nil {
+		return nil, err
+	}
+	if err = dao.WorkflowRelease.Add(ctx, release); err != nil {
+		cleanupCtx, cancel := detachedContext(ctx)
+		defer cancel()
+		if revertErr := revertReleaseRes(cleanupCtx, workflow, release); revertErr != nil {
+			utils.Log(ctx).Error().Stack().Err(errors.New("删除发布资源失败, ")).Msg(revertErr.Error())
+		}
+		return nil, err
+	}
+	utils.Log(ctx).Info().Str("workflow", workflow.Name).Str("strategy", release.Strategy).
+		Str("image", release.Image).Msg("Workflow开始发布")
+	return release, nil
+}
+
+// 确认发布
+// 金丝雀发布将当前版本的deployment更新为新镜像，删除新版本的资源；蓝绿发布删除旧版本的deployment，workflow改为管理新版本的deployment
+// 确认后保存一个历史版本，失败时发布保持Promoting状态，可以重试
+func (r *workflowReleaser) Promote(ctx context.Context, id int) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	workflow, release, err := activeRelease(ctx, id)
+	if err != nil {
+		return err
+	}
+	if release.Strategy == model.ReleaseBlueGreen && release.Status == model.ReleaseProgressing {
+		return errcode.Conflict.New("新版本尚未就绪, 不能确认发布")
+	}
+	if release.Status != model.ReleasePromoting {
+		release.Status = model.ReleasePromoting
+		if err = dao.WorkflowRelease.Save(ctx, release); err != nil {
+			return err
+		}
+	}
+
+	namespace := workflow.Namespace
+	switch release.Strategy {
+	case model.ReleaseCanary:
+		//先恢复service的selector，当前版本滚动更新出的新pod才能接收流量
+		if err = setServiceSelector(ctx, namespace, recordServiceName(workflow), releaseSelector(release)); err != nil {
+			return err
+		}
+		if err = setDeploymentImage(ctx, namespace, release.StableDeployment, release.Image); err != nil {
+			return err
+		}
+		if err = ignoreNotFound(Ingress.DeleteIngress(withoutTrash(ctx), release.Ingress, namespace)); err != nil {
+			return err
+		}
+		if err = ignoreNotFound(Servicev1.DeleteService(withoutTrash(ctx), release.Service, namespace)); err != nil {
+			return err
+		}
+		if err = ignoreNotFound(Deployment.DeleteDeployment(withoutTrash(ctx), release.Deployment, namespace)); err != nil {
+			return err
+		}
+	case model.ReleaseBlueGreen:
+		if err = ignoreNotFound(Deployment.DeleteDeployment(withoutTrash(ctx), release.StableDeployment, namespace)); err != nil {
+			return err
+		}
+		workflow.Deployment = release.Deployment
+	}
+
+	spec, err := currentWorkflowSpec(ctx, workflow)
+	if err != nil {
+		return err
+	}
+	revision, err := newWorkflowRevision(ctx, spec, model.RevisionRelease, fmt.Sprintf("%s发布%s", releaseStrategyName(release.Strategy), release.Image))
+	if err != nil {
+		return err
+	}
+	setWorkflowSpec(workflow, spec)
+	if err = dao.Workflow.SaveWithRevision(ctx, workflow, revision); err != nil {
+		return err
+	}
+
+	release.Status = model.ReleasePromoted
+	release.Weight = 100
+	release.Message = "已确认发布"
+	if err = dao.WorkflowRelease.Save(ctx, release); err != nil {
+		return err
+	}
+	utils.Log(ctx).Info().Str("workflow", workflow.Name).Str("image", release.Image).Msg("Workflow发布已确认")
+	return nil
+}
+
+// 放弃发布，流量切回当前版本并删除新版本的资源
+func (r *workflowReleaser) Abort(ctx context.Context, id int) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	workflow, release, err := activeRelease(ctx, id)
+	if err != nil {
+		return err
+	}
+	if release.Status == model.ReleasePromoting {
+		return errcode.Conflict.New("正在确认发布, 不能放弃")
+	}
+	return finishRelease(ctx, workflow, release, model.ReleaseAborted, "已放弃发布")
+}
+
+// 启动后台推进发布
+func (r *workflowReleaser) Start() {
+	r.once.Do(func() {
+		go r.run()
+		utils.Logger.Info().Dur("interval", r.interval()).Msg("Workflow发布控制已启动")
+	})
+}
+
+// 检查发布进度的间隔
+func (r *workflowReleaser) interval() time.Duration {
+	interval := config.Config.GetInt("Workflow.releaseinterval")
+	if interval <= 0 {
+		interval = 10
+	}
+	return time.Duration(interval) * time.Second
+}
+
+func (r *workflowReleaser) run() {
+	ticker := time.NewTicker(r.interval())
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), r.interval())
+		r.progressAll(ctx)
+		cancel()
+	}
+}
+
+// 推进所有进行中的发布
+func (r *workflowReleaser) progressAll(ctx context.Context) {
+	releases, err := dao.WorkflowRelease.GetByStatus(ctx, model.ReleaseProgressing, model.ReleasePaused)
+	if err != nil {
+		return
+	}
+	for _, release := range releases {
+		if err = r.progress(ctx, release.WorkflowID, release.ID); err != nil {
+			utils.Log(ctx).Warn().Str("workflow", release.WorkflowName).Msg("推进Workflow发布失败, " + err.Error())
+		}
+	}
+}
+
+// 推进单个发布
+// 新版本pod重启次数超过上限，或者持续未就绪超过ReadyTimeout时自动回退；
+// 新版本就绪时，金丝雀发布每隔StepInterval调整到下一步的流量比例，蓝绿发布切换service的selector，流量全部切换后等待确认
+func (r *workflowReleaser) progress(ctx context.Context, workflowID, releaseID uint) (err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	//获取锁期间发布可能已被确认或放弃，重新查询
+	release, err := dao.WorkflowRelease.GetLatest(ctx, workflowID)
+	if err != nil {
+		return err
+	}
+	if release == nil || release.ID != releaseID ||
+		(release.Status != model.ReleaseProgressing && release.Status != model.ReleasePaused) {
+		return nil
+	}
+	workflow, err := getWorkflow(ctx, int(workflowID))
+	if err != nil {
+		return err
+	}
+
+	deploy, err := K8sClientSet.AppsV1().Deployments(workflow.Namespace).Get(ctx, release.Deployment, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return finishRelease(ctx, workflow, release, model.ReleaseRolledBack, "新版本的Deployment已被删除")
+	}
+	if err != nil {
+		return errors.Wrap(err, "获取Deployment详情失败")
+	}
+	restarts, err := releaseRestarts(ctx, deploy)
+	if err != nil {
+		return err
+	}
+	if restarts > release.MaxRestarts {
+		return finishRelease(ctx, workflow, release, model.ReleaseRolledBack,
+			fmt.Sprintf("新版本的Pod重启%d次, 超过上限%d", restarts, release.MaxRestarts))
+	}
+
+	now := time.Now()
+	if !releaseDeploymentReady(deploy) {
+		if release.UnreadySince == nil {
+			release.UnreadySince = &now
+			return dao.WorkflowRelease.Save(ctx, release)
+		}
+		if now.Sub(*release.UnreadySince) > time.Duration(release.ReadyTimeout)*time.Second {
+			return finishRelease(ctx, workflow, release, model.ReleaseRolledBack,
+				fmt.Sprintf("新版本超过%d秒未就绪", release.ReadyTimeout))
+		}
+		return nil
+	}
+	changed := release.UnreadySince != nil
+	release.UnreadySince = nil
+
+	switch {
+	case release.Status == model.ReleasePaused:
+	case release.Strategy == model.ReleaseCanary:
+		var steps []int
+		if err = json.Unmarshal([]byte(release.Steps), &steps); err != nil {
+			return errors.Wrap(err, "解析发布步骤失败")
+		}
+		if release.Step >= 0 && release.StepStartedAt != nil &&
+			now.Sub(*release.StepStartedAt) < time.Duration(release.StepInterval)*time.Second {
+			break
+		}
+		next := release.Step + 1
+		if err = setCanaryWeight(ctx, workflow.Namespace, release.Ingress, steps[next]); err != nil {
+			return err
+		}
+		release.Step = next
+		release.Weight = steps[next]
+		release.StepStartedAt = &now
+		release.Message = fmt.Sprintf("流量已调整到%d%%", release.Weight)
+		if next == len(steps)-1 {
+			release.Status = model.ReleasePaused
+			release.Message += ", 等待确认发布"
+		}
+		changed = true
+	case release.Strategy == model.ReleaseBlueGreen:
+		selector := withLabel(releaseSelector(release), releaseTrackLabel, releaseTrack(release))
+		if err = setServiceSelector(ctx, workflow.Namespace, recordServiceName(workflow), selector); err != nil {
+			return err
+		}
+		release.Weight = 100
+		release.StepStartedAt = &now
+		release.Status = model.ReleasePaused
+		release.Message = "流量已切换到新版本, 等待确认发布"
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if err = dao.WorkflowRelease.Save(ctx, release); err != nil {
+		return err
+	}
+	utils.Log(ctx).Info().Str("workflow", workflow.Name).Str("status", release.Status).Msg("Workflow发布进度, " + release.Message)
+	return nil
+}
+
+// 校验发布参数并填充默认值，生成发布记录
+func newWorkflowRelease(ctx context.Context, workflow *model.Workflow, stable *appsv1.Deployment, data *WorkflowReleaseCreate) (*model.WorkflowRelease, error) {
+	if data.Image == "" {
+		return nil, errcode.InvalidParam.New("镜像不能为空")
+	}
+	if len(stable.Spec.Template.Spec.Containers) == 0 {
+		return nil, errcode.Invalid.New("Deployment中没有容器: " + stable.Name)
+	}
+	//当前版本的pod-template-hash，deployment正在滚动更新时有多个版本的pod，不能开始发布
+	hash, err := stableTemplateHash(ctx, stable)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	release := &model.WorkflowRelease{
+		WorkflowID:       workflow.ID,
+		WorkflowName:     workflow.Name,
+		Strategy:         data.Strategy,
+		Image:            data.Image,
+		StableDeployment: stable.Name,
+		StableHash:       hash,
+		Steps:            "[]",
+		Step:             -1,
+		StepInterval:     data.StepInterval,
+		ReadyTimeout:     data.ReadyTimeout,
+		Status:           model.ReleaseProgressing,
+		Message:          "等待新版本就绪",
+		Operator:         utils.UserFromContext(ctx),
+		StepStartedAt:    &now,
+	}
+
+	switch data.Strategy {
+	case model.ReleaseCanary:
+		if workflow.Type != "Ingress" {
+			return nil, errcode.Invalid.New("金丝雀发布需要Ingress类型的Workflow")
+		}
+		release.Deployment = stable.Name + "-" + releaseCanaryTrack
+		release.Service = recordServiceName(workflow) + "-" + releaseCanaryTrack
+		release.Ingress = recordIngressName(workflow) + "-" + releaseCanaryTrack
+		release.Replicas = 1
+		steps := data.Steps
+		if len(steps) == 0 {
+			steps = config.Config.GetIntSlice("Workflow.releasesteps")
+		}
+		if len(steps) == 0 {
+			steps = []int{10, 30, 60, 100}
+		}
+		for i, step := range steps {
+			if step < 1 || step > 100 || (i > 0 && step <= steps[i-1]) {
+				return nil, errcode.InvalidParam.New("流量比例必须在1到100之间并且递增")
+			}
+		}
+		encoded, _ := json.Marshal(steps)
+		release.Steps = string(encoded)
+	case model.ReleaseBlueGreen:
+		//蓝绿发布后workflow改为管理新的deployment，附加的hpa仍然指向旧的deployment
+		resources, err := workflowResources(workflow)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range resources {
+			if isPostDeployResource(obj) {
+				return nil, errcode.Invalid.New("蓝绿发布会替换Deployment, 不支持带HPA的Workflow")
+			}
+		}
+		color := "blue"
+		if strings.HasSuffix(stable.Name, "-blue") {
+			color = "green"
+		}
+		release.Deployment = workflow.Name + "-" + color
+		if len(release.Deployment) > workflowNameMaxLen {
+			return nil, errcode.Invalid.Newf("新版本的Deployment名超过%d个字符: %s", workflowNameMaxLen, release.Deployment)
+		}
+		release.Replicas = 1
+		if stable.Spec.Replicas != nil && *stable.Spec.Replicas > 0 {
+			release.Replicas = *stable.Spec.Replicas
+		}
+	default:
+		return nil, errcode.InvalidParam.New("不支持的发布策略: " + data.Strategy)
+	}
+
+	if data.Replicas != nil {
+		release.Replicas = *data.Replicas
+	}
+	if release.Replicas < 1 {
+		return nil, errcode.InvalidParam.New("新版本的副本数不能小于1")
+	}
+	if release.StepInterval == 0 {
+		release.StepInterval = config.Config.GetInt("Workflow.releasestepinterval")
+	}
+	if release.ReadyTimeout == 0 {
+		release.ReadyTimeout = config.Config.GetInt("Workflow.releasereadytimeout")
+	}
+	if release.StepInterval <= 0 || release.ReadyTimeout <= 0 {
+		return nil, errcode.InvalidParam.New("每一步的持续时间和就绪超时时间必须大于0")
+	}
+	release.MaxRestarts = int32(config.Config.GetInt("Workflow.releasemaxrestarts"))
+	if data.MaxRestarts != nil {
+		release.MaxRestarts = *data.MaxRestarts
+	}
+	if release.MaxRestarts < 0 {
+		return nil, errcode.InvalidParam.New("重启次数上限不能小于0")
+	}
+	return release, nil
+}
+
+// 创建新版本的资源，service只选择当前版本的pod，任一步骤失败时删除已创建的资源
+func startRelease(ctx context.Context, release *model.WorkflowRelease, stable *appsv1.Deployment, svc *corev1.Service, ing *nwv1.Ingress) (err error) {
+	namespace := stable.Namespace
+	var rollbacks []func(ctx context.Context) error
+	defer func() {
+		if err == nil {
+			return
+		}
+		cleanupCtx, cancel := detachedContext(ctx)
+		defer cancel()
+		for i := len(rollbacks) - 1; i >= 0; i-- {
+			if rollbackErr := rollbacks[i](cleanupCtx); rollbackErr != nil {
+				utils.Log(ctx).Error().Stack().Err(errors.New("删除发布资源失败, ")).Msg(rollbackErr.Error())
+			}
+		}
+	}()
+
+	selector := svc.Spec.Selector
+	if err = setServiceSelector(ctx, namespace, svc.Name, withLabel(selector, appsv1.DefaultDeploymentUniqueLabelKey, release.StableHash)); err != nil {
+		return err
+	}
+	rollbacks = append(rollbacks, func(ctx context.Context) error {
+		return setServiceSelector(ctx, namespace, svc.Name, selector)
+	})
+
+	_, err = K8sClientSet.AppsV1().Deployments(namespace).Create(ctx, buildReleaseDeployment(stable, release), metav1.CreateOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("创建Deployment失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "创建Deployment失败")
+	}
+	rollbacks = append(rollbacks, func(ctx context.Context) error {
+		return ignoreNotFound(Deployment.DeleteDeployment(withoutTrash(ctx), release.Deployment, namespace))
+	})
+	if release.Strategy != model.ReleaseCanary {
+		return nil
+	}
+
+	canarySvc := buildCanaryService(svc, release, withLabel(selector, releaseTrackLabel, releaseCanaryTrack))
+	_, err = K8sClientSet.CoreV1().Services(namespace).Create(ctx, canarySvc, metav1.CreateOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("创建Service失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "创建Service失败")
+	}
+	rollbacks = append(rollbacks, func(ctx context.Context) error {
+		return ignoreNotFound(Servicev1.DeleteService(withoutTrash(ctx), release.Service, namespace))
+	})
+
+	_, err = K8sClientSet.NetworkingV1().Ingresses(namespace).Create(ctx, buildCanaryIngress(ing, svc.Name, release), metav1.CreateOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("创建Ingress失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "创建Ingress失败")
+	}
+	return nil
+}
+
+// 结束发布，删除新版本的资源并记录结果，workflow恢复为Running，由后台巡检重新计算状态
+func finishRelease(ctx context.Context, workflow *model.Workflow, release *model.WorkflowRelease, status, message string) (err error) {
+	if err = revertReleaseRes(ctx, workflow, release); err != nil {
+		return err
+	}
+	release.Status = status
+	release.Weight = 0
+	release.Message = message
+	if err = dao.WorkflowRelease.Save(ctx, release); err != nil {
+		return err
+	}
+	workflowMessage := ""
+	if status == model.ReleaseRolledBack {
+		workflowMessage = "发布已自动回退: " + message
+	}
+	if err = dao.Workflow.UpdateStatus(ctx, workflow.ID, model.WorkflowRunning, workflowMessage); err != nil {
+		return err
+	}
+	utils.Log(ctx).Info().Str("workflow", workflow.Name).Str("status", status).Msg("Workflow发布结束, " + message)
+	return nil
+}
+
+// 将流量切回当前版本并删除新版本的资源，已经不存在的资源会被忽略，失败时可以重试
+// 发布前的selector同样能选中新版本的pod，所以先固定到当前版本，删除新版本后再恢复
+func revertReleaseRes(ctx context.Context, workflow *model.Workflow, release *model.WorkflowRelease) (err error) {
+	namespace := workflow.Namespace
+	serviceName := recordServiceName(workflow)
+	selector := releaseSelector(release)
+	if release.Strategy == model.ReleaseCanary {
+		if err = ignoreNotFound(Ingress.DeleteIngress(withoutTrash(ctx), release.Ingress, namespace)); err != nil {
+			return err
+		}
+	}
+	err = setServiceSelector(ctx, namespace, serviceName, withLabel(selector, appsv1.DefaultDeploymentUniqueLabelKey, release.StableHash))
+	if err != nil {
+		return err
+	}
+	if err = ignoreNotFound(Deployment.DeleteDeployment(withoutTrash(ctx), release.Deployment, namespace)); err != nil {
+		return err
+	}
+	if release.Strategy == model.ReleaseCanary {
+		if err = ignoreNotFound(Servicev1.DeleteService(withoutTrash(ctx), release.Service, namespace)); err != nil {
+			return err
+		}
+	}
+	return setServiceSelector(ctx, namespace, serviceName, selector)
+}
+
+// 获取workflow进行中的发布
+func activeRelease(ctx context.Context, id int) (*model.Workflow, *model.WorkflowRelease, error) {
+	workflow, err := getWorkflow(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	release, err := dao.WorkflowRelease.GetLatest(ctx, workflow.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if release == nil || !releaseActive(release) {
+		return nil, nil, errcode.Conflict.New("Workflow没有进行中的发布: " + workflow.Name)
+	}
+	return workflow, release, nil
+}
+
+// 发布是否还在进行中
+func releaseActive(release *model.WorkflowRelease) bool {
+	switch release.Status {
+	case model.ReleaseProgressing, model.ReleasePaused, model.ReleasePromoting:
+		return true
+	}
+	return false
+}
+
+// 发布策略的中文名
+func releaseStrategyName(strategy string) string {
+	if strategy == model.ReleaseCanary {
+		return "金丝雀"
+	}
+	return "蓝绿"
+}
+
+// 新版本pod的track标签值，金丝雀发布为canary，蓝绿发布为blue或green
+func releaseTrack(release *model.WorkflowRelease) string {
+	if release.Strategy == model.ReleaseCanary {
+		return releaseCanaryTrack
+	}
+	return release.Deployment[strings.LastIndex(release.Deployment, "-")+1:]
+}
+
+// 发布前service的selector
+func releaseSelector(release *model.WorkflowRelease) map[string]string {
+	selector := make(map[string]string)
+	_ = json.Unmarshal([]byte(release.ServiceSelector), &selector)
+	return selector
+}
+
+// 复制标签并设置一个标签
+func withLabel(labels map[string]string, key, value string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		result[k] = v
+	}
+	result[key] = value
+	return result
+}
+
+// 当前版本ReplicaSet的pod-template-hash，deployment正在滚动更新时返回错误
+func stableTemplateHash(ctx context.Context, stable *appsv1.Deployment) (string, error) {
+	replicas := int32(1)
+	if stable.Spec.Replicas != nil {
+		replicas = *stable.Spec.Replicas
+	}
+	if stable.Status.ObservedGeneration < stable.Generation || stable.Status.UpdatedReplicas < replicas ||
+		stable.Status.Replicas > stable.Status.UpdatedReplicas {
+		return "", errcode.Conflict.New("Deployment正在滚动更新, 不能开始发布: " + stable.Name)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(stable.Spec.Selector)
+	if err != nil {
+		return "", errors.Wrap(err, "解析Deployment的selector失败")
+	}
+	rsList, err := K8sClientSet.AppsV1().ReplicaSets(stable.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取ReplicaSet列表失败, ")).Msg(err.Error())
+		return "", errors.Wrap(err, "获取ReplicaSet列表失败")
+	}
+	for i := range rsList.Items {
+		rs := &rsList.Items[i]
+		if metav1.IsControlledBy(rs, stable) && rs.Annotations[deploymentRevisionAnnoKey] == stable.Annotations[deploymentRevisionAnnoKey] {
+			if hash := rs.Labels[appsv1.DefaultDeploymentUniqueLabelKey]; hash != "" {
+				return hash, nil
+			}
+		}
+	}
+	return "", errcode.Invalid.New("找不到Deployment当前的ReplicaSet: " + stable.Name)
+}
+
+// 以当前版本为模板生成新版本的deployment，保留环境变量、探针、资源限制等配置，只替换第一个容器的镜像
+// pod和selector加上track标签，与当前版本的pod区分
+func buildReleaseDeployment(stable *appsv1.Deployment, release *model.WorkflowRelease) *appsv1.Deployment {
+	track := releaseTrack(release)
+	spec := stable.Spec.DeepCopy()
+	spec.Replicas = &release.Replicas
+	spec.Selector.MatchLabels = withLabel(spec.Selector.MatchLabels, releaseTrackLabel, track)
+	spec.Template.Labels = withLabel(spec.Template.Labels, releaseTrackLabel, track)
+	spec.Template.Spec.Containers[0].Image = release.Image
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      release.Deployment,
+			Namespace: stable.Namespace,
+			Labels:    stable.Labels,
+		},
+		Spec: *spec,
+	}
+}
+
+// 金丝雀版本的service，端口与workflow的service相同，只选择新版本的pod
+func buildCanaryService(stable *corev1.Service, release *model.WorkflowRelease, selector map[string]string) *corev1.Service {
+	ports := make([]corev1.ServicePort, 0, len(stable.Spec.Ports))
+	for _, port := range stable.Spec.Ports {
+		ports = append(ports, corev1.ServicePort{
+			Name:       port.Name,
+			Protocol:   port.Protocol,
+			Port:       port.Port,
+			TargetPort: port.TargetPort,
+		})
+	}
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      release.Service,
+			Namespace: stable.Namespace,
+			Labels:    stable.Labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeClusterIP,
+			Ports:    ports,
+			Selector: selector,
+		},
+	}
+}
+
+// 金丝雀版本的ingress，规则与workflow的ingress相同，后端指向金丝雀版本的service，初始权重为0
+// 只设置canary注解，tls等配置沿用workflow的ingress
+func buildCanaryIngress(stable *nwv1.Ingress, serviceName string, release *model.WorkflowRelease) *nwv1.Ingress {
+	spec := stable.Spec.DeepCopy()
+	spec.TLS = nil
+	if backend := spec.DefaultBackend; backend != nil && backend.Service != nil && backend.Service.Name == serviceName {
+		backend.Service.Name = release.Service
+	}
+	for _, rule := range spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for i := range rule.HTTP.Paths {
+			if backend := rule.HTTP.Paths[i].Backend.Service; backend != nil && backend.Name == serviceName {
+				backend.Name = release.Service
+			}
+		}
+	}
+	return &nwv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      release.Ingress,
+			Namespace: stable.Namespace,
+			Labels:    stable.Labels,
+			Annotations: map[string]string{
+				canaryAnnotation:       "true",
+				canaryWeightAnnotation: "0",
+			},
+		},
+		Spec: *spec,
+	}
+}
+
+// 修改service的selector
+func setServiceSelector(ctx context.Context, namespace, name string, selector map[string]string) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		svc, err := K8sClientSet.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		svc.Spec.Selector = selector
+		_, err = K8sClientSet.CoreV1().Services(namespace).Update(ctx, svc, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Service的selector失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "更新Service的selector失败")
+	}
+	return nil
+}
+
+// 修改金丝雀ingress的流量比例
+func setCanaryWeight(ctx context.Context, namespace, name string, weight int) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		ing, err := K8sClientSet.NetworkingV1().Ingresses(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if ing.Annotations == nil {
+			ing.Annotations = make(map[string]string)
+		}
+		ing.Annotations[canaryAnnotation] = "true"
+		ing.Annotations[canaryWeightAnnotation] = strconv.Itoa(weight)
+		_, err = K8sClientSet.NetworkingV1().Ingresses(namespace).Update(ctx, ing, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新金丝雀流量比例失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "更新金丝雀流量比例失败")
+	}
+	return nil
+}
+
+// 修改deployment第一个容器的镜像
+func setDeploymentImage(ctx context.Context, namespace, name, image string) error {
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		deploy, err := K8sClientSet.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if len(deploy.Spec.Template.Spec.Containers) == 0 {
+			return errcode.Invalid.New("Deployment中没有容器: " + name)
+		}
+		deploy.Spec.Template.Spec.Containers[0].Image = image
+		_, err = K8sClientSet.AppsV1().Deployments(namespace).Update(ctx, deploy, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Deployment镜像失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "更新Deployment镜像失败")
+	}
+	return nil
+}
+
+// 新版本是否全部就绪
+func releaseDeploymentReady(deploy *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if deploy.Spec.Replicas != nil {
+		replicas = *deploy.Spec.Replicas
+	}
+	return deploy.Status.ObservedGeneration >= deploy.Generation &&
+		deploy.Status.UpdatedReplicas >= replicas &&
+		deploy.Status.ReadyReplicas >= replicas
+}
+
+// 新版本所有pod的容器重启次数之和
+func releaseRestarts(ctx context.Context, deploy *appsv1.Deployment) (int32, error) {
+	selector, err := metav1.LabelSelectorAsSelector(deploy.Spec.Selector)
+	if err != nil {
+		return 0, errors.Wrap(err, "解析Deployment的selector失败")
+	}
+	pods, err := K8sClientSet.CoreV1().Pods(deploy.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Pod列表失败, ")).Msg(err.Error())
+		return 0, errors.Wrap(err, "获取Pod列表失败")
+	}
+	var restarts int32
+	for _, pod := range pods.Items {
+		for _, status := range pod.Status.ContainerStatuses {
+			restarts += status.RestartCount
+		}
+	}
+	return restarts, nil
+}
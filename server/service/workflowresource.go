@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// workflow的附加资源，例如configmap、secret、pvc和hpa，随workflow一起创建和删除
+// 附加资源的namespace固定为workflow的namespace，使用typed client操作，只支持下面列出的类型
+
+// 定义workflowResourceClient结构体，封装一种附加资源的操作
+type workflowResourceClient struct {
+	apiVersion string
+	newObject  func() runtime.Object
+	create     func(ctx context.Context, namespace string, obj runtime.Object) error
+	get        func(ctx context.Context, namespace, name string) error
+	delete     func(ctx context.Context, namespace, name string) error
+}
+
+// 支持的附加资源类型，key为kind
+var workflowResourceClients = map[string]*workflowResourceClient{
+	"ConfigMap": {
+		apiVersion: "v1",
+		newObject:  func() runtime.Object { return &corev1.ConfigMap{} },
+		create: func(ctx context.Context, namespace string, obj runtime.Object) error {
+			_, err := K8sClientSet.CoreV1().ConfigMaps(namespace).Create(ctx, obj.(*corev1.ConfigMap), metav1.CreateOptions{})
+			return err
+		},
+		get: func(ctx context.Context, namespace, name string) error {
+			_, err := K8sClientSet.CoreV1().ConfigMaps(namespace).Get(ctx, name, metav1.GetOptions{})
+			return err
+		},
+		delete: func(ctx context.Context, namespace, name string) error {
+			return K8sClientSet.CoreV1().ConfigMaps(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	},
+	"Secret": {
+		apiVersion: "v1",
+		newObject:  func() runtime.Object { return &corev1.Secret{} },
+		create: func(ctx context.Context, namespace string, obj runtime.Object) error {
+			_, err := K8sClientSet.CoreV1().Secrets(namespace).Create(ctx, obj.(*corev1.Secret), metav1.CreateOptions{})
+			return err
+		},
+		get: func(ctx context.Context, namespace, name string) error {
+			_, err := K8sClientSet.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+			return err
+		},
+		delete: func(ctx context.Context, namespace, name string) error {
+			return K8sClientSet.CoreV1().Secrets(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	},
+	"PersistentVolumeClaim": {
+		apiVersion: "v1",
+		newObject:  func() runtime.Object { return &corev1.PersistentVolumeClaim{} },
+		create: func(ctx context.Context, namespace string, obj runtime.Object) error {
+			_, err := K8sClientSet.CoreV1().PersistentVolumeClaims(namespace).Create(ctx, obj.(*corev1.PersistentVolumeClaim), metav1.CreateOptions{})
+			return err
+		},
+		get: func(ctx context.Context, namespace, name string) error {
+			_, err := K8sClientSet.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, metav1.GetOptions{})
+			return err
+		},
+		delete: func(ctx context.Context, namespace, name string) error {
+			return K8sClientSet.CoreV1().PersistentVolumeClaims(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	},
+	"HorizontalPodAutoscaler": {
+		apiVersion: "autoscaling/v2",
+		newObject:  func() runtime.Object { return &autoscalingv2.HorizontalPodAutoscaler{} },
+		create: func(ctx context.Context, namespace string, obj runtime.Object) error {
+			_, err := K8sClientSet.AutoscalingV2().HorizontalPodAutoscalers(namespace).Create(ctx, obj.(*autoscalingv2.HorizontalPodAutoscaler), metav1.CreateOptions{})
+			return err
+		},
+		get: func(ctx context.Context, namespace, name string) error {
+			_, err := K8sClientSet.AutoscalingV2().HorizontalPodAutoscalers(namespace).Get(ctx, name, metav1.GetOptions{})
+			return err
+		},
+		delete: func(ctx context.Context, namespace, name string) error {
+			return K8sClientSet.AutoscalingV2().HorizontalPodAutoscalers(namespace).Delete(ctx, name, metav1.DeleteOptions{})
+		},
+	},
+}
+
+// 校验附加资源，并将namespace设置为workflow的namespace
+func normalizeWorkflowResources(namespace string, resources []*unstructured.Unstructured) error {
+	seen := map[string]bool{}
+	for _, obj := range resources {
+		if obj == nil {
+			return errcode.InvalidParam.New("附加资源不能为空")
+		}
+		kind, name := obj.GetKind(), obj.GetName()
+		client, ok := workflowResourceClients[kind]
+		if !ok {
+			return errcode.InvalidParam.New("不支持的附加资源类型: " + kind)
+		}
+		if obj.GetAPIVersion() != client.apiVersion {
+			return errcode.InvalidParam.Newf("附加资源%s的apiVersion必须为%s", kind, client.apiVersion)
+		}
+		if name == "" {
+			return errcode.InvalidParam.New("附加资源的名称不能为空: " + kind)
+		}
+		if ns := obj.GetNamespace(); ns != "" && ns != namespace {
+			return errcode.InvalidParam.Newf("附加资源%s/%s的namespace必须与Workflow一致", kind, name)
+		}
+		obj.SetNamespace(namespace)
+		if seen[kind+"/"+name] {
+			return errcode.InvalidParam.Newf("附加资源%s/%s重复", kind, name)
+		}
+		seen[kind+"/"+name] = true
+		if _, err := typedWorkflowResource(obj); err != nil {
+			return errcode.InvalidParam.Wrapf(err, "附加资源%s/%s格式不正确", kind, name)
+		}
+	}
+	return nil
+}
+
+// hpa依赖deployment，在deployment之后创建、之前删除，其他附加资源可能被pod引用，在deployment之前创建
+func isPostDeployResource(obj *unstructured.Unstructured) bool {
+	return obj.GetKind() == "HorizontalPodAutoscaler"
+}
+
+// 将附加资源转换为对应的typed对象
+func typedWorkflowResource(obj *unstructured.Unstructured) (runtime.Object, error) {
+	client, ok := workflowResourceClients[obj.GetKind()]
+	if !ok {
+		return nil, errcode.InvalidParam.New("不支持的附加资源类型: " + obj.GetKind())
+	}
+	typed := client.newObject()
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructuredWithValidation(obj.Object, typed, true); err != nil {
+		return nil, err
+	}
+	return typed, nil
+}
+
+// 创建附加资源
+func createWorkflowResource(ctx context.Context, obj *unstructured.Unstructured) error {
+	typed, err := typedWorkflowResource(obj)
+	if err != nil {
+		return err
+	}
+	if err = workflowResourceClients[obj.GetKind()].create(ctx, obj.GetNamespace(), typed); err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("创建附加资源失败")).Str("kind", obj.GetKind()).Str("name", obj.GetName()).Msg(err.Error())
+		return errors.Wrapf(err, "创建%s %s失败", obj.GetKind(), obj.GetName())
+	}
+	return nil
+}
+
+// 附加资源不存在时创建，已存在时不修改
+func ensureWorkflowResource(ctx context.Context, obj *unstructured.Unstructured) error {
+	err := workflowResourceClients[obj.GetKind()].get(ctx, obj.GetNamespace(), obj.GetName())
+	if apierrors.IsNotFound(err) {
+		return createWorkflowResource(ctx, obj)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "获取%s %s失败", obj.GetKind(), obj.GetName())
+	}
+	return nil
+}
+
+// 删除附加资源，资源已经不存在时视为删除成功
+func deleteWorkflowResource(ctx context.Context, obj *unstructured.Unstructured) error {
+	client, ok := workflowResourceClients[obj.GetKind()]
+	if !ok {
+		return nil
+	}
+	if err := ignoreNotFound(client.delete(ctx, obj.GetNamespace(), obj.GetName())); err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("删除附加资源失败")).Str("kind", obj.GetKind()).Str("name", obj.GetName()).Msg(err.Error())
+		return errors.Wrapf(err, "删除%s %s失败", obj.GetKind(), obj.GetName())
+	}
+	return nil
+}
+
+// 从workflow记录保存的参数中获取附加资源
+func workflowResources(workflow *model.Workflow) ([]*unstructured.Unstructured, error) {
+	if workflow.Spec == "" {
+		return nil, nil
+	}
+	spec := &WorkflowCreate{}
+	if err := json.Unmarshal([]byte(workflow.Spec), spec); err != nil {
+		return nil, errors.Wrap(err, "解析Workflow参数失败")
+	}
+	for _, obj := range spec.Resources {
+		obj.SetNamespace(workflow.Namespace)
+	}
+	return spec.Resources, nil
+}
+
+// 接口返回的附加资源中secret的内容替换为该值，只保留key
+const redactedSecretValue = "******"
+
+// 将secret的data和stringData的值替换为redactedSecretValue，obj不是secret时不修改
+func redactSecret(obj map[string]interface{}) {
+	if kind, _ := obj["kind"].(string); kind != "Secret" {
+		return
+	}
+	for _, field := range []string{"data", "stringData"} {
+		values, ok := obj[field].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key := range values {
+			values[key] = redactedSecretValue
+		}
+	}
+}
+
+// 返回隐藏了secret内容的workflow参数，用于接口返回，数据库中保存的参数不变
+func redactWorkflowSpec(spec string) string {
+	if spec == "" {
+		return spec
+	}
+	data := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(spec), &data); err != nil {
+		return ""
+	}
+	resources, _ := data["resources"].([]interface{})
+	for _, res := range resources {
+		if obj, ok := res.(map[string]interface{}); ok {
+			redactSecret(obj)
+		}
+	}
+	redacted, _ := json.Marshal(data)
+	return string(redacted)
+}
+
+// 返回隐藏了secret内容的多文档yaml资源清单，只重新生成secret的文档，其他文档保持原样
+func redactManifests(manifests string) string {
+	docs := strings.Split(manifests, "---\n")
+	for i, doc := range docs {
+		obj := make(map[string]interface{})
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil || obj["kind"] != "Secret" {
+			continue
+		}
+		redactSecret(obj)
+		data, _ := yaml.Marshal(obj)
+		docs[i] = string(data)
+	}
+	return strings.Join(docs, "---\n")
+}
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"k8s-server/dao"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"sigs.k8s.io/yaml"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// workflow的历史版本
+// 每次创建、更新、以集群为准同步以及回滚workflow都会保存一个版本，包含完整参数和生成的k8s资源
+// 回滚时使用历史版本的参数重新修改集群中的资源，并生成一个新的版本
+var WorkflowRevision workflowRevision
+
+type workflowRevision struct{}
+
+// 定义WorkflowRevisionDiff结构体，两个版本的参数和k8s资源的unified diff
+// To为0表示与集群中的当前状态对比
+type WorkflowRevisionDiff struct {
+	From      int    `json:"from"`
+	To        int    `json:"to"`
+	Spec      string `json:"spec"`
+	Manifests string `json:"manifests"`
+}
+
+// 获取workflow的历史版本列表
+func (r *workflowRevision) GetList(ctx context.Context, id int, page, limit int) (data *dao.WorkflowRevisionResp, err error) {
+	workflow, err := getWorkflow(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return dao.WorkflowRevision.GetList(ctx, workflow.ID, page, limit)
+}
+
+// 获取workflow的指定版本，参数和资源清单中secret的内容被隐藏
+func (r *workflowRevision) GetDetail(ctx context.Context, id, revision int) (data *model.WorkflowRevision, err error) {
+	workflow, err := getWorkflow(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := r.get(ctx, workflow, revision)
+	if err != nil {
+		return nil, err
+	}
+	redacted := *rev
+	redacted.Spec = redactWorkflowSpec(rev.Spec)
+	redacted.Manifests = redactManifests(rev.Manifests)
+	return &redacted, nil
+}
+
+// 对比两个版本，to为0时与集群中的当前状态对比
+func (r *workflowRevision) Diff(ctx context.Context, id, from, to int) (data *WorkflowRevisionDiff, err error) {
+	workflow, err := getWorkflow(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	fromRev, err := r.get(ctx, workflow, from)
+	if err != nil {
+		return nil, err
+	}
+	fromSpec, err := decodeRevisionSpec(fromRev)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		toSpec      *WorkflowCreate
+		toManifests string
+		toName      = fmt.Sprintf("revision-%d", to)
+	)
+	if to > 0 {
+		toRev, err := r.get(ctx, workflow, to)
+		if err != nil {
+			return nil, err
+		}
+		if toSpec, err = decodeRevisionSpec(toRev); err != nil {
+			return nil, err
+		}
+		toManifests = toRev.Manifests
+	} else {
+		toName = "live"
+		if toSpec, err = currentWorkflowSpec(ctx, workflow); err != nil {
+			return nil, err
+		}
+		if toManifests, err = workflowManifests(toSpec); err != nil {
+			return nil, err
+		}
+	}
+
+	fromName := fmt.Sprintf("revision-%d", from)
+	data = &WorkflowRevisionDiff{From: from, To: to}
+	if data.Spec, err = unifiedDiff(specYAML(fromSpec), specYAML(toSpec), fromName, toName); err != nil {
+		return nil, err
+	}
+	if data.Manifests, err = unifiedDiff(redactManifests(fromRev.Manifests), redactManifests(toManifests), fromName, toName); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// 回滚到指定版本，使用该版本的参数修改集群中的资源，失败时恢复为回滚前的状态
+func (r *workflowRevision) Rollback(ctx context.Context, id, revision int) (err error) {
+	workflow, err := getWorkflow(ctx, id)
+	if err != nil {
+		return err
+	}
+	if workflow.Status == model.WorkflowCreating || workflow.Status == model.WorkflowDeleting ||
+		workflow.Status == model.WorkflowReleasing {
+		return errcode.Conflict.Newf("Workflow状态为%s, 不能回滚", workflow.Status)
+	}
+	rev, err := r.get(ctx, workflow, revision)
+	if err != nil {
+		return err
+	}
+	target, err := decodeRevisionSpec(rev)
+	if err != nil {
+		return err
+	}
+	setSpecNames(target, workflow)
+	if err = validateWorkflowSpec(target); err != nil {
+		return err
+	}
+	if err = normalizeWorkflowResources(target.Namespace, target.Resources); err != nil {
+		return err
+	}
+	newRev, err := newWorkflowRevision(ctx, target, model.RevisionRollback, fmt.Sprintf("回滚到版本%d", rev.Revision))
+	if err != nil {
+		return err
+	}
+
+	current, err := currentWorkflowSpec(ctx, workflow)
+	if err != nil {
+		return err
+	}
+	if err = applyWorkflowSpec(ctx, target); err != nil {
+		return restoreWorkflowRes(ctx, current, err)
+	}
+	setWorkflowSpec(workflow, target)
+	workflow.Template = rev.Template
+	workflow.TemplateVersion = rev.TemplateVersion
+	if err = dao.Workflow.SaveWithRevision(ctx, workflow, newRev); err != nil {
+		return restoreWorkflowRes(ctx, current, err)
+	}
+	utils.Log(ctx).Info().Str("workflow", workflow.Name).Str("namespace", workflow.Namespace).Int("revision", rev.Revision).Msg("回滚Workflow成功")
+	return nil
+}
+
+// 获取workflow的指定版本，不存在时返回NotFound
+func (r *workflowRevision) get(ctx context.Context, workflow *model.Workflow, revision int) (*model.WorkflowRevision, error) {
+	rev, err := dao.WorkflowRevision.Get(ctx, workflow.ID, revision)
+	if err != nil {
+		return nil, err
+	}
+	if rev == nil {
+		return nil, errcode.NotFound.Newf("Workflow %s的版本%d不存在", workflow.Name, revision)
+	}
+	return rev, nil
+}
+
+// 获取workflow，不存在时返回NotFound
+func getWorkflow(ctx context.Context, id int) (*model.Workflow, error) {
+	workflow, err := dao.Workflow.GetById(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if workflow.ID == 0 {
+		return nil, errcode.NotFound.Newf("Workflow不存在: %d", id)
+	}
+	return workflow, nil
+}
+
+// 生成一个历史版本，workflow相关的字段和版本号在保存时填充
+func newWorkflowRevision(ctx context.Context, spec *WorkflowCreate, action, message string) (*model.WorkflowRevision, error) {
+	manifests, err := workflowManifests(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &model.WorkflowRevision{
+		Action:    action,
+		Manifests: manifests,
+		Operator:  utils.UserFromContext(ctx),
+		Message:   message,
+	}, nil
+}
+
+// 根据参数生成workflow的k8s资源，顺序与创建顺序一致，多文档yaml格式
+func workflowManifests(spec *WorkflowCreate) (string, error) {
+	var objects []runtime.Object
+	for _, obj := range spec.Resources {
+		if !isPostDeployResource(obj) {
+			typed, err := typedWorkflowResource(obj)
+			if err != nil {
+				return "", err
+			}
+			objects = append(objects, typed)
+		}
+	}
+	deployment := buildDeployment(workflowDeployCreate(spec))
+	deployment.TypeMeta = metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"}
+	service := buildService(workflowServiceCreate(spec))
+	service.TypeMeta = metav1.TypeMeta{APIVersion: "v1", Kind: "Service"}
+	objects = append(objects, deployment, service)
+	if spec.Type == "Ingress" {
+		ingress := buildWorkflowIngress(spec)
+		ingress.TypeMeta = metav1.TypeMeta{APIVersion: "networking.k8s.io/v1", Kind: "Ingress"}
+		objects = append(objects, ingress)
+	}
+	for _, obj := range spec.Resources {
+		if isPostDeployResource(obj) {
+			typed, err := typedWorkflowResource(obj)
+			if err != nil {
+				return "", err
+			}
+			objects = append(objects, typed)
+		}
+	}
+
+	docs := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return "", errors.Wrap(err, "生成Workflow资源清单失败")
+		}
+		docs = append(docs, string(data))
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+// 解析历史版本的参数
+func decodeRevisionSpec(rev *model.WorkflowRevision) (*WorkflowCreate, error) {
+	spec := &WorkflowCreate{}
+	if err := json.Unmarshal([]byte(rev.Spec), spec); err != nil {
+		return nil, errors.Wrapf(err, "解析版本%d的参数失败", rev.Revision)
+	}
+	return spec, nil
+}
+
+// 参数转换为yaml，便于对比，附加资源中secret的内容被隐藏(会修改spec)
+func specYAML(spec *WorkflowCreate) string {
+	for _, obj := range spec.Resources {
+		redactSecret(obj.Object)
+	}
+	data, _ := yaml.Marshal(spec)
+	return string(data)
+}
+
+// 生成unified diff，内容相同时返回空字符串
+func unifiedDiff(a, b, fromName, toName string) (string, error) {
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(a),
+		B:        difflib.SplitLines(b),
+		FromFile: fromName,
+		ToFile:   toName,
+		Context:  3,
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "生成差异失败")
+	}
+	return diff, nil
+}
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"k8s-server/config"
+	"k8s-server/dao"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// workflow的实时状态
+// 查询workflow时从集群获取各资源的当前状态并与数据库记录对比，后台巡检根据对比结果更新workflow的状态
+var WorkflowReconciler = &workflowReconciler{}
+
+type workflowReconciler struct {
+	once sync.Once
+}
+
+// workflow资源的健康状态
+const (
+	WorkflowHealthy  = "Healthy"
+	WorkflowDegraded = "Degraded"
+	WorkflowMissing  = "Missing"
+)
+
+// 定义WorkflowLive结构体，workflow各资源在集群中的当前状态
+// Type为根据service类型和ingress是否存在推断出的workflow类型
+type WorkflowLive struct {
+	Deployment     bool           `json:"deployment"`
+	Service        bool           `json:"service"`
+	Ingress        bool           `json:"ingress"`
+	Replicas       int32          `json:"replicas"`
+	ReadyReplicas  int32          `json:"ready_replicas"`
+	Image          string         `json:"image"`
+	Type           string         `json:"type"`
+	Endpoints      int            `json:"endpoints"`
+	IngressAddress []string       `json:"ingress_address"`
+	Drift          *WorkflowDrift `json:"drift"`
+	Health         string         `json:"health"`
+	Reasons        []string       `json:"reasons"`
+	//获取集群状态失败时的错误，此时其他字段不可信
+	Error string `json:"error,omitempty"`
+}
+
+// 定义WorkflowDrift结构体，集群中的资源与数据库记录不一致的字段
+type WorkflowDrift struct {
+	Replicas bool `json:"replicas"`
+	Image    bool `json:"image"`
+	Type     bool `json:"type"`
+}
+
+// 定义WorkflowDetail结构体，数据库记录加上实时状态，已删除的workflow没有实时状态
+// Spec为隐藏了secret内容的参数，覆盖model.Workflow中的Spec
+type WorkflowDetail struct {
+	*model.Workflow
+	Spec string        `json:"spec"`
+	Live *WorkflowLive `json:"live"`
+}
+
+// 定义列表的返回内容
+type WorkflowsResp struct {
+	Items []*WorkflowDetail `json:"items"`
+	Total int               `json:"total"`
+}
+
+// 获取workflow各资源在集群中的当前状态，并与数据库记录对比
+func workflowLive(ctx context.Context, workflow *model.Workflow) *WorkflowLive {
+	live := &WorkflowLive{Drift: &WorkflowDrift{}, IngressAddress: []string{}, Reasons: []string{}}
+	if err := fillWorkflowLive(ctx, workflow, live); err != nil {
+		live.Error = err.Error()
+		return live
+	}
+
+	if !live.Deployment {
+		live.Reasons = append(live.Reasons, "Deployment不存在")
+	}
+	if !live.Service {
+		live.Reasons = append(live.Reasons, "Service不存在")
+	}
+	if workflow.Type == "Ingress" && !live.Ingress {
+		live.Reasons = append(live.Reasons, "Ingress不存在")
+	}
+	if len(live.Reasons) > 0 {
+		live.Health = WorkflowMissing
+		return live
+	}
+
+	//副本数由HPA控制时与记录不一致是正常的，不比较
+	live.Drift.Replicas = !hasAutoscaler(workflow) && live.Replicas != workflow.Replicas
+	//旧数据没有记录镜像，不比较
+	live.Drift.Image = workflow.Image != "" && live.Image != workflow.Image
+	live.Drift.Type = live.Type != workflow.Type
+	if live.Drift.Replicas {
+		live.Reasons = append(live.Reasons, fmt.Sprintf("副本数为%d, 记录为%d", live.Replicas, workflow.Replicas))
+	}
+	if live.Drift.Image {
+		live.Reasons = append(live.Reasons, fmt.Sprintf("镜像为%s, 记录为%s", live.Image, workflow.Image))
+	}
+	if live.Drift.Type {
+		live.Reasons = append(live.Reasons, fmt.Sprintf("类型为%s, 记录为%s", live.Type, workflow.Type))
+	}
+	if live.ReadyReplicas < live.Replicas {
+		live.Reasons = append(live.Reasons, fmt.Sprintf("就绪副本数%d/%d", live.ReadyReplicas, live.Replicas))
+	}
+	live.Health = WorkflowHealthy
+	if len(live.Reasons) > 0 {
+		live.Health = WorkflowDegraded
+	}
+	return live
+}
+
+// workflow的附加资源中是否有HPA，参数解析失败时按没有处理
+func hasAutoscaler(workflow *model.Workflow) bool {
+	resources, _ := workflowResources(workflow)
+	for _, obj := range resources {
+		if isPostDeployResource(obj) {
+			return true
+		}
+	}
+	return false
+}
+
+// 从集群获取deployment、service、endpoints和ingress，资源不存在不是错误
+func fillWorkflowLive(ctx context.Context, workflow *model.Workflow, live *WorkflowLive) error {
+	deploy, err := K8sClientSet.AppsV1().Deployments(workflow.Namespace).Get(ctx, recordDeploymentName(workflow), metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return errors.Wrap(err, "获取Deployment详情失败")
+	default:
+		live.Deployment = true
+		if deploy.Spec.Replicas != nil {
+			live.Replicas = *deploy.Spec.Replicas
+		}
+		live.ReadyReplicas = deploy.Status.ReadyReplicas
+		if len(deploy.Spec.Template.Spec.Containers) > 0 {
+			live.Image = deploy.Spec.Template.Spec.Containers[0].Image
+		}
+	}
+
+	serviceName := recordServiceName(workflow)
+	svc, err := K8sClientSet.CoreV1().Services(workflow.Namespace).Get(ctx, serviceName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return errors.Wrap(err, "获取Service详情失败")
+	default:
+		live.Service = true
+		live.Type = string(svc.Spec.Type)
+	}
+
+	endpoints, err := K8sClientSet.CoreV1().Endpoints(workflow.Namespace).Get(ctx, serviceName, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return errors.Wrap(err, "获取Endpoints详情失败")
+	default:
+		for _, subset := range endpoints.Subsets {
+			live.Endpoints += len(subset.Addresses)
+		}
+	}
+
+	ing, err := K8sClientSet.NetworkingV1().Ingresses(workflow.Namespace).Get(ctx, recordIngressName(workflow), metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		return errors.Wrap(err, "获取Ingress详情失败")
+	default:
+		live.Ingress = true
+		for _, lb := range ing.Status.LoadBalancer.Ingress {
+			if lb.IP != "" {
+				live.IngressAddress = append(live.IngressAddress, lb.IP)
+			} else if lb.Hostname != "" {
+				live.IngressAddress = append(live.IngressAddress, lb.Hostname)
+			}
+		}
+		//ClusterIP类型的service加上ingress即为Ingress类型的workflow
+		if live.Type == "ClusterIP" {
+			live.Type = "Ingress"
+		}
+	}
+	return nil
+}
+
+// 为workflow列表并发获取实时状态，已删除的workflow跳过
+func workflowDetails(ctx context.Context, workflows []*model.Workflow) []*WorkflowDetail {
+	details := make([]*WorkflowDetail, len(workflows))
+	var wg sync.WaitGroup
+	for i, workflow := range workflows {
+		details[i] = &WorkflowDetail{Workflow: workflow, Spec: redactWorkflowSpec(workflow.Spec)}
+		if workflow.DeletedAt != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(detail *WorkflowDetail) {
+			defer wg.Done()
+			detail.Live = workflowLive(ctx, detail.Workflow)
+		}(details[i])
+	}
+	wg.Wait()
+	return details
+}
+
+// 启动后台巡检，配置中未开启时不启动
+func (r *workflowReconciler) Start() {
+	if !config.Config.GetBool("Workflow.reconcile") {
+		return
+	}
+	r.once.Do(func() {
+		go r.run()
+		utils.Logger.Info().Dur("interval", r.interval()).Msg("Workflow巡检已启动")
+	})
+}
+
+// 巡检间隔
+func (r *workflowReconciler) interval() time.Duration {
+	interval := config.Config.GetInt("Workflow.reconcileinterval")
+	if interval <= 0 {
+		interval = 60
+	}
+	return time.Duration(interval) * time.Second
+}
+
+func (r *workflowReconciler) run() {
+	ticker := time.NewTicker(r.interval())
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), r.interval())
+		r.reconcile(ctx)
+		cancel()
+	}
+}
+
+// 巡检所有workflow，根据集群中资源的状态更新为Running、Degraded或Missing
+// 正在创建、删除以及创建失败的workflow由对应的操作维护状态，不参与巡检
+func (r *workflowReconciler) reconcile(ctx context.Context) {
+	workflows, err := dao.Workflow.GetAll(ctx)
+	if err != nil {
+		return
+	}
+	for _, workflow := range workflows {
+		switch workflow.Status {
+		case model.WorkflowRunning, model.WorkflowDegraded, model.WorkflowMissing:
+		default:
+			continue
+		}
+		live := workflowLive(ctx, workflow)
+		if live.Error != "" {
+			utils.Log(ctx).Warn().Str("workflow", workflow.Name).Msg("获取Workflow实时状态失败, " + live.Error)
+			continue
+		}
+		status := model.WorkflowRunning
+		switch live.Health {
+		case WorkflowDegraded:
+			status = model.WorkflowDegraded
+		case WorkflowMissing:
+			status = model.WorkflowMissing
+		}
+		message := strings.Join(live.Reasons, "; ")
+		if status == workflow.Status && message == workflow.Message {
+			continue
+		}
+		updated, err := dao.Workflow.UpdateStatusIf(ctx, workflow.ID, workflow.Status, status, message)
+		if err != nil || !updated {
+			continue
+		}
+		utils.Log(ctx).Info().Str("workflow", workflow.Name).Str("namespace", workflow.Namespace).
+			Str("from", workflow.Status).Str("to", status).Msg("Workflow状态变化, " + message)
+	}
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"k8s-server/dao"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// workflow模板
+// 模板内容是go template语法的yaml，渲染后的结构与WorkflowCreate一致，可以通过resources字段附加configmap等资源
+// 同名模板每次保存都生成一个新版本，通过模板创建的workflow会记录模板名和版本
+var WorkflowTemplate workflowTemplate
+
+type workflowTemplate struct{}
+
+// 模板变量名，与go template的字段名规则一致
+var templateVariableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// 定义TemplateVariable结构体，模板变量的定义，没有默认值的必填变量在实例化时必须传入
+type TemplateVariable struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     string `json:"default"`
+	Required    bool   `json:"required"`
+}
+
+// 定义WorkflowTemplateCreate结构体，用于保存模板的新版本
+type WorkflowTemplateCreate struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Variables   []*TemplateVariable `json:"variables"`
+	Content     string              `json:"content"`
+}
+
+// 定义WorkflowTemplateDetail结构体，返回模板时将变量定义解析为列表
+type WorkflowTemplateDetail struct {
+	*model.WorkflowTemplate
+	Variables []*TemplateVariable `json:"variables"`
+}
+
+// 定义WorkflowTemplateInstantiate结构体，用于通过模板创建workflow
+// Version为0时使用最新版本，DryRun为true时只渲染和校验，不创建
+type WorkflowTemplateInstantiate struct {
+	Name      string            `json:"name"`
+	Version   int               `json:"version"`
+	Variables map[string]string `json:"variables"`
+	DryRun    bool              `json:"dry_run"`
+}
+
+// 获取模板列表
+func (t *workflowTemplate) GetList(ctx context.Context, name string, page, limit int) (data *dao.WorkflowTemplateResp, err error) {
+	return dao.WorkflowTemplate.GetList(ctx, name, page, limit)
+}
+
+// 获取模板的指定版本，version为0时返回最新版本
+func (t *workflowTemplate) GetDetail(ctx context.Context, name string, version int) (data *WorkflowTemplateDetail, err error) {
+	tpl, err := t.get(ctx, name, version)
+	if err != nil {
+		return nil, err
+	}
+	variables, err := decodeTemplateVariables(tpl)
+	if err != nil {
+		return nil, err
+	}
+	return &WorkflowTemplateDetail{WorkflowTemplate: tpl, Variables: variables}, nil
+}
+
+// 保存模板，模板不存在时版本为1，否则在最大版本号上加1
+func (t *workflowTemplate) Create(ctx context.Context, data *WorkflowTemplateCreate) (tpl *model.WorkflowTemplate, err error) {
+	if data.Name == "" {
+		return nil, errcode.InvalidParam.New("模板名不能为空")
+	}
+	seen := map[string]bool{}
+	for _, v := range data.Variables {
+		if v == nil || !templateVariableName.MatchString(v.Name) {
+			return nil, errcode.InvalidParam.New("模板变量名不合法")
+		}
+		if seen[v.Name] {
+			return nil, errcode.InvalidParam.New("模板变量重复: " + v.Name)
+		}
+		seen[v.Name] = true
+	}
+	if _, err = parseWorkflowTemplate(data.Name, data.Content); err != nil {
+		return nil, err
+	}
+	variables, err := json.Marshal(data.Variables)
+	if err != nil {
+		return nil, errors.Wrap(err, "序列化模板变量失败")
+	}
+	version, err := dao.WorkflowTemplate.MaxVersion(ctx, data.Name)
+	if err != nil {
+		return nil, err
+	}
+	tpl = &model.WorkflowTemplate{
+		Name:        data.Name,
+		Version:     version + 1,
+		Description: data.Description,
+		Variables:   string(variables),
+		Content:     data.Content,
+	}
+	if err = dao.WorkflowTemplate.Add(ctx, tpl); err != nil {
+		return nil, err
+	}
+	utils.Log(ctx).Info().Str("template", tpl.Name).Int("version", tpl.Version).Msg("保存Workflow模板成功")
+	return tpl, nil
+}
+
+// 删除模板的指定版本
+func (t *workflowTemplate) Delete(ctx context.Context, name string, version int) (err error) {
+	if version <= 0 {
+		return errcode.InvalidParam.New("需要指定模板版本")
+	}
+	if _, err = t.get(ctx, name, version); err != nil {
+		return err
+	}
+	return dao.WorkflowTemplate.Delete(ctx, name, version)
+}
+
+// 渲染模板并创建workflow，返回渲染后的参数
+func (t *workflowTemplate) Instantiate(ctx context.Context, data *WorkflowTemplateInstantiate) (spec *WorkflowCreate, err error) {
+	tpl, err := t.get(ctx, data.Name, data.Version)
+	if err != nil {
+		return nil, err
+	}
+	spec, err = renderWorkflowTemplate(tpl, data.Variables)
+	if err != nil {
+		return nil, err
+	}
+	setIngressBackends(spec)
+	if err = validateWorkflowSpec(spec); err != nil {
+		return nil, err
+	}
+	if err = normalizeWorkflowResources(spec.Namespace, spec.Resources); err != nil {
+		return nil, err
+	}
+	if data.DryRun {
+		return spec, nil
+	}
+	if err = Workflow.create(ctx, spec, model.RevisionCreate, "", tpl.Name, tpl.Version); err != nil {
+		return nil, err
+	}
+	utils.Log(ctx).Info().Str("template", tpl.Name).Int("version", tpl.Version).Str("workflow", spec.Name).Msg("通过模板创建Workflow成功")
+	return spec, nil
+}
+
+// 获取模板，不存在时返回NotFound
+func (t *workflowTemplate) get(ctx context.Context, name string, version int) (*model.WorkflowTemplate, error) {
+	tpl, err := dao.WorkflowTemplate.Get(ctx, name, version)
+	if err != nil {
+		return nil, err
+	}
+	if tpl == nil {
+		if version > 0 {
+			return nil, errcode.NotFound.Newf("Workflow模板不存在: %s, 版本%d", name, version)
+		}
+		return nil, errcode.NotFound.New("Workflow模板不存在: " + name)
+	}
+	return tpl, nil
+}
+
+// 解析模板内容，引用未定义的变量时渲染报错
+func parseWorkflowTemplate(name, content string) (*template.Template, error) {
+	if strings.TrimSpace(content) == "" {
+		return nil, errcode.InvalidParam.New("模板内容不能为空")
+	}
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(content)
+	if err != nil {
+		return nil, errcode.InvalidParam.Wrapf(err, "模板语法错误")
+	}
+	return tmpl, nil
+}
+
+// 解析模板的变量定义
+func decodeTemplateVariables(tpl *model.WorkflowTemplate) ([]*TemplateVariable, error) {
+	variables := []*TemplateVariable{}
+	if tpl.Variables == "" {
+		return variables, nil
+	}
+	if err := json.Unmarshal([]byte(tpl.Variables), &variables); err != nil {
+		return nil, errors.Wrap(err, "解析模板变量失败")
+	}
+	return variables, nil
+}
+
+// 合并变量的默认值和传入值后渲染模板，渲染结果按yaml解析为WorkflowCreate
+func renderWorkflowTemplate(tpl *model.WorkflowTemplate, values map[string]string) (*WorkflowCreate, error) {
+	variables, err := decodeTemplateVariables(tpl)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{}
+	defined := map[string]bool{}
+	var missing []string
+	for _, v := range variables {
+		defined[v.Name] = true
+		value, ok := values[v.Name]
+		if !ok {
+			value = v.Default
+		}
+		if value == "" && v.Required {
+			missing = append(missing, v.Name)
+		}
+		data[v.Name] = value
+	}
+	if len(missing) > 0 {
+		return nil, errcode.InvalidParam.New("缺少必填的模板变量: " + strings.Join(missing, ", "))
+	}
+	var unknown []string
+	for name := range values {
+		if !defined[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return nil, errcode.InvalidParam.New("模板中未定义的变量: " + strings.Join(unknown, ", "))
+	}
+
+	tmpl, err := parseWorkflowTemplate(tpl.Name, tpl.Content)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, data); err != nil {
+		return nil, errcode.InvalidParam.Wrapf(err, "渲染模板失败")
+	}
+	spec := &WorkflowCreate{}
+	if err = yaml.UnmarshalStrict(buf.Bytes(), spec); err != nil {
+		return nil, errcode.InvalidParam.Wrapf(err, "模板渲染结果不是合法的Workflow参数")
+	}
+	return spec, nil
+}
@@ -0,0 +1,504 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"k8s-server/dao"
+	"k8s-server/errcode"
+	"k8s-server/model"
+	"k8s-server/utils"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	corev1 "k8s.io/api/core/v1"
+	nwv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// 定义WorkflowUpdate结构体，用于更新workflow，只修改不为空的字段
+// 标签是deployment的selector，创建后不能修改，所以不支持更新
+type WorkflowUpdate struct {
+	ID            int                    `json:"id"`
+	Replicas      *int32                 `json:"replicas"`
+	Image         *string                `json:"image"`
+	ContainerPort *int32                 `json:"container_port"`
+	Type          *string                `json:"type"`
+	Port          *int32                 `json:"port"`
+	NodePort      *int32                 `json:"node_port"`
+	Hosts         map[string][]*HttpPath `json:"hosts"`
+}
+
+// 更新workflow
+// 以集群中资源的当前状态为基础合并要修改的字段，依次更新deployment、service和ingress，
+// 类型在Ingress和其他类型之间切换时会创建或删除ingress，任一步骤失败时将资源恢复为更新前的状态
+func (w *workflow) UpdateWorkflow(ctx context.Context, data *WorkflowUpdate) (err error) {
+	workflow, err := dao.Workflow.GetById(ctx, data.ID)
+	if err != nil {
+		return err
+	}
+	if workflow.ID == 0 {
+		return errcode.NotFound.Newf("Workflow不存在: %d", data.ID)
+	}
+	if workflow.Status == model.WorkflowCreating || workflow.Status == model.WorkflowDeleting ||
+		workflow.Status == model.WorkflowReleasing {
+		return errcode.Conflict.Newf("Workflow状态为%s, 不能更新", workflow.Status)
+	}
+
+	current, err := currentWorkflowSpec(ctx, workflow)
+	if err != nil {
+		return err
+	}
+	desired := mergeWorkflowSpec(current, data)
+	if err = validateWorkflowSpec(desired); err != nil {
+		return err
+	}
+
+	revision, err := newWorkflowRevision(ctx, desired, model.RevisionUpdate, "")
+	if err != nil {
+		return err
+	}
+
+	if err = applyWorkflowSpec(ctx, desired); err != nil {
+		return restoreWorkflowRes(ctx, current, err)
+	}
+
+	setWorkflowSpec(workflow, desired)
+	//数据库更新失败时同样恢复k8s资源，保证集群与数据库一致
+	if err = dao.Workflow.SaveWithRevision(ctx, workflow, revision); err != nil {
+		return restoreWorkflowRes(ctx, current, err)
+	}
+	utils.Log(ctx).Info().Str("workflow", workflow.Name).Str("namespace", workflow.Namespace).Msg("更新Workflow成功")
+	return nil
+}
+
+// workflow同步的方向
+const (
+	//以集群为准更新数据库记录
+	WorkflowSyncToDB = "db"
+	//以数据库记录为准修改集群中的资源，缺失的资源会重新创建
+	WorkflowSyncToCluster = "cluster"
+)
+
+// 同步workflow的数据库记录和集群中的资源，用于处理巡检发现的Degraded和Missing状态
+func (w *workflow) SyncWorkflow(ctx context.Context, id int, direction string) (err error) {
+	workflow, err := dao.Workflow.GetById(ctx, id)
+	if err != nil {
+		return err
+	}
+	if workflow.ID == 0 {
+		return errcode.NotFound.Newf("Workflow不存在: %d", id)
+	}
+	if workflow.Status == model.WorkflowCreating || workflow.Status == model.WorkflowDeleting ||
+		workflow.Status == model.WorkflowReleasing {
+		return errcode.Conflict.Newf("Workflow状态为%s, 不能同步", workflow.Status)
+	}
+	switch direction {
+	case WorkflowSyncToDB:
+		err = w.syncToDB(ctx, workflow)
+	case WorkflowSyncToCluster:
+		err = w.syncToCluster(ctx, workflow)
+	default:
+		return errcode.InvalidParam.New("不支持的同步方向: " + direction)
+	}
+	if err != nil {
+		return err
+	}
+	utils.Log(ctx).Info().Str("workflow", workflow.Name).Str("namespace", workflow.Namespace).Str("direction", direction).Msg("同步Workflow成功")
+	return nil
+}
+
+// 以集群为准更新数据库记录，deployment或service不存在时无法还原参数
+func (w *workflow) syncToDB(ctx context.Context, workflow *model.Workflow) (err error) {
+	live := workflowLive(ctx, workflow)
+	if live.Error != "" {
+		return errors.New(live.Error)
+	}
+	if !live.Deployment || !live.Service {
+		return errcode.NotFound.New("Deployment或Service不存在, 只能以数据库记录为准同步到集群")
+	}
+	//类型以集群为准，这样还原参数时才会读取(或忽略)ingress
+	workflow.Type = live.Type
+	spec, err := currentWorkflowSpec(ctx, workflow)
+	if err != nil {
+		return err
+	}
+	revision, err := newWorkflowRevision(ctx, spec, model.RevisionSync, "以集群为准同步到数据库")
+	if err != nil {
+		return err
+	}
+	setWorkflowSpec(workflow, spec)
+	return dao.Workflow.SaveWithRevision(ctx, workflow, revision)
+}
+
+// 以数据库记录为准修改集群中的资源，需要记录中保存了创建或更新时的参数
+func (w *workflow) syncToCluster(ctx context.Context, workflow *model.Workflow) (err error) {
+	if workflow.Spec == "" {
+		return errcode.Invalid.New("Workflow没有保存参数, 请先以集群为准同步到数据库")
+	}
+	spec := &WorkflowCreate{}
+	if err = json.Unmarshal([]byte(workflow.Spec), spec); err != nil {
+		return errors.Wrap(err, "解析Workflow参数失败")
+	}
+	setSpecNames(spec, workflow)
+	spec.Replicas = workflow.Replicas
+	spec.Type = workflow.Type
+	if workflow.Image != "" {
+		spec.Image = workflow.Image
+	}
+	if err = validateWorkflowSpec(spec); err != nil {
+		return err
+	}
+
+	//先补齐缺失的deployment和service，再统一修改为记录中的状态
+	_, err = K8sClientSet.AppsV1().Deployments(spec.Namespace).Get(ctx, spec.deploymentName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		err = Deployment.CreateDeployment(ctx, workflowDeployCreate(spec))
+	}
+	if err != nil {
+		return errors.Wrap(err, "同步Deployment失败")
+	}
+	_, err = K8sClientSet.CoreV1().Services(spec.Namespace).Get(ctx, spec.serviceName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		err = Servicev1.CreateService(ctx, workflowServiceCreate(spec))
+	}
+	if err != nil {
+		return errors.Wrap(err, "同步Service失败")
+	}
+	if err = applyWorkflowSpec(ctx, spec); err != nil {
+		return err
+	}
+	return dao.Workflow.UpdateStatus(ctx, workflow.ID, model.WorkflowRunning, "")
+}
+
+// 根据参数设置workflow记录中的字段，并将状态恢复为Running
+func setWorkflowSpec(workflow *model.Workflow, spec *WorkflowCreate) {
+	workflow.Name = spec.Name
+	workflow.Namespace = spec.Namespace
+	workflow.Replicas = spec.Replicas
+	workflow.Deployment = spec.deploymentName()
+	workflow.Service = spec.serviceName()
+	workflow.Type = spec.Type
+	workflow.Image = spec.Image
+	workflow.Ingress = ""
+	if spec.Type == "Ingress" {
+		workflow.Ingress = spec.ingressName()
+	}
+	workflow.Spec = encodeWorkflowSpec(spec)
+	workflow.Status = model.WorkflowRunning
+	workflow.Message = ""
+}
+
+// 根据数据库中的记录和集群中资源的当前状态，还原出workflow当前的参数
+// 副本数、镜像、端口以集群为准，避免覆盖在集群中直接做的修改(例如扩缩容)
+func currentWorkflowSpec(ctx context.Context, workflow *model.Workflow) (spec *WorkflowCreate, err error) {
+	spec = &WorkflowCreate{}
+	if workflow.Spec != "" {
+		if err = json.Unmarshal([]byte(workflow.Spec), spec); err != nil {
+			utils.Log(ctx).Error().Stack().Err(errors.New("解析Workflow参数失败")).Msg(err.Error())
+			return nil, errors.Wrap(err, "解析Workflow参数失败")
+		}
+	}
+	setSpecNames(spec, workflow)
+	spec.Type = workflow.Type
+
+	deploy, err := Deployment.GetDeploymentDetail(ctx, spec.deploymentName(), workflow.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if deploy.Spec.Replicas != nil {
+		spec.Replicas = *deploy.Spec.Replicas
+	}
+	if deploy.Spec.Selector != nil {
+		spec.Label = deploy.Spec.Selector.MatchLabels
+	}
+	if len(deploy.Spec.Template.Spec.Containers) > 0 {
+		container := &deploy.Spec.Template.Spec.Containers[0]
+		spec.Image = container.Image
+		spec.Cpu = containerResource(container, corev1.ResourceCPU)
+		spec.Memory = containerResource(container, corev1.ResourceMemory)
+	}
+
+	svc, err := Servicev1.GetServicetDetail(ctx, spec.serviceName(), workflow.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	if len(svc.Spec.Ports) > 0 {
+		spec.Port = svc.Spec.Ports[0].Port
+		spec.ContainerPort = svc.Spec.Ports[0].TargetPort.IntVal
+		spec.NodePort = svc.Spec.Ports[0].NodePort
+	}
+
+	if workflow.Type == "Ingress" {
+		ing, err := K8sClientSet.NetworkingV1().Ingresses(workflow.Namespace).Get(ctx, spec.ingressName(), metav1.GetOptions{})
+		switch {
+		case apierrors.IsNotFound(err):
+			//ingress已被删除时使用记录中的hosts，更新时会重新创建
+		case err != nil:
+			utils.Log(ctx).Error().Stack().Err(errors.New("获取Ingress详情失败")).Msg(err.Error())
+			return nil, errors.Wrap(err, "获取Ingress详情失败")
+		default:
+			spec.Hosts = hostsFromIngress(ing)
+		}
+	}
+	return spec, nil
+}
+
+// 将要修改的字段合并到当前参数中
+func mergeWorkflowSpec(current *WorkflowCreate, data *WorkflowUpdate) *WorkflowCreate {
+	desired := *current
+	if data.Replicas != nil {
+		desired.Replicas = *data.Replicas
+	}
+	if data.Image != nil {
+		desired.Image = *data.Image
+	}
+	if data.ContainerPort != nil {
+		desired.ContainerPort = *data.ContainerPort
+	}
+	if data.Type != nil {
+		desired.Type = *data.Type
+	}
+	if data.Port != nil {
+		desired.Port = *data.Port
+	}
+	if data.NodePort != nil {
+		desired.NodePort = *data.NodePort
+	}
+	if data.Hosts != nil {
+		desired.Hosts = data.Hosts
+	}
+	setIngressBackends(&desired)
+	return &desired
+}
+
+// workflow的ingress只能指向自己的service，未指定端口时使用service端口
+func setIngressBackends(spec *WorkflowCreate) {
+	for _, paths := range spec.Hosts {
+		for _, p := range paths {
+			p.ServiceName = spec.Name
+			if p.ServicePort == 0 {
+				p.ServicePort = spec.Port
+			}
+		}
+	}
+}
+
+// 校验更新后的参数
+func validateWorkflowSpec(spec *WorkflowCreate) error {
+	switch {
+	case spec.Name == "" || spec.Namespace == "":
+		return errcode.InvalidParam.New("名称和命名空间不能为空")
+	case spec.Replicas < 0:
+		return errcode.InvalidParam.New("副本数不能小于0")
+	case spec.Image == "":
+		return errcode.InvalidParam.New("镜像不能为空")
+	case spec.Port <= 0 || spec.Port > 65535:
+		return errcode.InvalidParam.Newf("端口不合法: %d", spec.Port)
+	case spec.ContainerPort <= 0 || spec.ContainerPort > 65535:
+		return errcode.InvalidParam.Newf("容器端口不合法: %d", spec.ContainerPort)
+	}
+	if err := validateResources(spec.Cpu, spec.Memory); err != nil {
+		return err
+	}
+	switch spec.Type {
+	case "ClusterIP", "NodePort":
+	case "Ingress":
+		if len(spec.Hosts) == 0 {
+			return errcode.InvalidParam.New("Ingress类型的Workflow需要配置hosts")
+		}
+	default:
+		return errcode.InvalidParam.New("不支持的Workflow类型: " + spec.Type)
+	}
+	return nil
+}
+
+// 将集群中workflow的资源修改为spec描述的状态，可以重复执行
+// 缺失的附加资源会重新创建，已存在的附加资源不修改
+func applyWorkflowSpec(ctx context.Context, spec *WorkflowCreate) (err error) {
+	for _, obj := range spec.Resources {
+		if !isPostDeployResource(obj) {
+			if err = ensureWorkflowResource(ctx, obj); err != nil {
+				return err
+			}
+		}
+	}
+	if err = applyWorkflowDeployment(ctx, spec); err != nil {
+		return err
+	}
+	if err = applyWorkflowService(ctx, spec); err != nil {
+		return err
+	}
+	if err = applyWorkflowIngress(ctx, spec); err != nil {
+		return err
+	}
+	for _, obj := range spec.Resources {
+		if isPostDeployResource(obj) {
+			if err = ensureWorkflowResource(ctx, obj); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 更新deployment的副本数、镜像、资源和容器端口，没有变化时不更新，避免触发滚动更新
+func applyWorkflowDeployment(ctx context.Context, spec *WorkflowCreate) (err error) {
+	deploy, err := Deployment.GetDeploymentDetail(ctx, spec.deploymentName(), spec.Namespace)
+	if err != nil {
+		return err
+	}
+	containers := deploy.Spec.Template.Spec.Containers
+	if len(containers) == 0 {
+		return errcode.Invalid.New("Deployment中没有容器: " + spec.deploymentName())
+	}
+	//cpu和内存为空时保留容器原有的设置
+	changed := setContainerResource(&containers[0], corev1.ResourceCPU, spec.Cpu)
+	changed = setContainerResource(&containers[0], corev1.ResourceMemory, spec.Memory) || changed
+	//容器端口与service的targetPort保持一致
+	changed = setContainerPort(&containers[0], spec.ContainerPort) || changed
+	if !changed && deploy.Spec.Replicas != nil && *deploy.Spec.Replicas == spec.Replicas && containers[0].Image == spec.Image {
+		return nil
+	}
+	replicas := spec.Replicas
+	deploy.Spec.Replicas = &replicas
+	containers[0].Image = spec.Image
+	_, err = K8sClientSet.AppsV1().Deployments(spec.Namespace).Update(ctx, deploy, metav1.UpdateOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Deployment失败")).Msg(err.Error())
+		return errors.Wrap(err, "更新Deployment失败")
+	}
+	return nil
+}
+
+// 更新service的类型和端口，Ingress类型的workflow使用ClusterIP类型的service
+func applyWorkflowService(ctx context.Context, spec *WorkflowCreate) (err error) {
+	svc, err := Servicev1.GetServicetDetail(ctx, spec.serviceName(), spec.Namespace)
+	if err != nil {
+		return err
+	}
+	if len(svc.Spec.Ports) == 0 {
+		svc.Spec.Ports = []corev1.ServicePort{{Name: "http", Protocol: corev1.ProtocolTCP}}
+	}
+	serviceType := corev1.ServiceTypeClusterIP
+	if spec.Type == "NodePort" {
+		serviceType = corev1.ServiceTypeNodePort
+	}
+	svc.Spec.Type = serviceType
+	port := &svc.Spec.Ports[0]
+	port.Port = spec.Port
+	port.TargetPort = intstr.FromInt32(spec.ContainerPort)
+	if serviceType == corev1.ServiceTypeNodePort {
+		//nodePort为0时保留已分配的端口，没有时由apiserver自动分配
+		if spec.NodePort != 0 {
+			port.NodePort = spec.NodePort
+		}
+	} else {
+		//ClusterIP类型的service不能设置nodePort和externalTrafficPolicy
+		port.NodePort = 0
+		svc.Spec.ExternalTrafficPolicy = ""
+	}
+	_, err = K8sClientSet.CoreV1().Services(spec.Namespace).Update(ctx, svc, metav1.UpdateOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Service失败")).Msg(err.Error())
+		return errors.Wrap(err, "更新Service失败")
+	}
+	return nil
+}
+
+// Ingress类型的workflow创建或更新ingress，其他类型删除ingress
+func applyWorkflowIngress(ctx context.Context, spec *WorkflowCreate) (err error) {
+	ingressName := spec.ingressName()
+	if spec.Type != "Ingress" {
+		return ignoreNotFound(Ingress.DeleteIngress(withoutTrash(ctx), ingressName, spec.Namespace))
+	}
+	ing, err := K8sClientSet.NetworkingV1().Ingresses(spec.Namespace).Get(ctx, ingressName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return createWorkflowIngress(ctx, spec)
+	}
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("获取Ingress详情失败")).Msg(err.Error())
+		return errors.Wrap(err, "获取Ingress详情失败")
+	}
+	//只修改规则，保留导入的ingress已有的注解、tls等配置
+	ing.Spec.Rules = buildWorkflowIngress(spec).Spec.Rules
+	_, err = K8sClientSet.NetworkingV1().Ingresses(spec.Namespace).Update(ctx, ing, metav1.UpdateOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("更新Ingress失败")).Msg(err.Error())
+		return errors.Wrap(err, "更新Ingress失败")
+	}
+	return nil
+}
+
+// 组装workflow的ingress，后端指向workflow的service
+// ingress的通用创建方法会给后端的service名加上-svc后缀，导入的service不一定符合这个约定，所以在这里覆盖
+func buildWorkflowIngress(spec *WorkflowCreate) *nwv1.Ingress {
+	ingress := buildIngress(&IngressCreate{
+		Name:      spec.ingressName(),
+		Namespace: spec.Namespace,
+		Label:     spec.Label,
+		Hosts:     spec.Hosts,
+	})
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for i := range rule.HTTP.Paths {
+			if backend := rule.HTTP.Paths[i].Backend.Service; backend != nil {
+				backend.Name = spec.serviceName()
+			}
+		}
+	}
+	return ingress
+}
+
+// 创建workflow的ingress
+func createWorkflowIngress(ctx context.Context, spec *WorkflowCreate) (err error) {
+	_, err = K8sClientSet.NetworkingV1().Ingresses(spec.Namespace).Create(ctx, buildWorkflowIngress(spec), metav1.CreateOptions{})
+	if err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("创建Ingress失败, ")).Msg(err.Error())
+		return errors.Wrap(err, "创建Ingress失败")
+	}
+	return nil
+}
+
+// 更新失败时将k8s资源恢复为更新前的状态，恢复失败的原因追加到返回的错误中
+func restoreWorkflowRes(ctx context.Context, previous *WorkflowCreate, cause error) error {
+	cleanupCtx, cancel := detachedContext(ctx)
+	defer cancel()
+	if err := applyWorkflowSpec(cleanupCtx, previous); err != nil {
+		utils.Log(ctx).Error().Stack().Err(errors.New("恢复Workflow资源失败")).Msg(err.Error())
+		return errors.Wrapf(cause, "恢复Workflow资源失败, %s", err.Error())
+	}
+	return cause
+}
+
+// 从ingress的规则中还原workflow的hosts
+func hostsFromIngress(ing *nwv1.Ingress) map[string][]*HttpPath {
+	hosts := map[string][]*HttpPath{}
+	for _, rule := range ing.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, p := range rule.HTTP.Paths {
+			hp := &HttpPath{Path: p.Path}
+			if p.PathType != nil {
+				hp.PathType = *p.PathType
+			}
+			if p.Backend.Service != nil {
+				hp.ServiceName = strings.TrimSuffix(p.Backend.Service.Name, "-svc")
+				hp.ServicePort = p.Backend.Service.Port.Number
+			}
+			hosts[rule.Host] = append(hosts[rule.Host], hp)
+		}
+	}
+	return hosts
+}
+
+// 将workflow的参数序列化后保存到数据库
+func encodeWorkflowSpec(spec *WorkflowCreate) string {
+	data, _ := json.Marshal(spec)
+	return string(data)
+}
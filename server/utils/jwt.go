@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"context"
+	"k8s-server/config"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/pkg/errors"
@@ -22,6 +25,43 @@ const (
 	SECRET = "adoodevops"
 )
 
+// context中保存当前用户名的key
+type userContextKey struct{}
+
+// 将当前用户名放入context，用于审计和操作记录
+func WithUser(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, userContextKey{}, username)
+}
+
+// 获取context中的当前用户名，请求未携带有效token时返回空字符串
+func UserFromContext(ctx context.Context) string {
+	username, _ := ctx.Value(userContextKey{}).(string)
+	return username
+}
+
+// 生成token，有效期由配置文件中的User.tokenexpire决定，单位小时
+func (*jwtToken) GenToken(username string) (tokenString string, err error) {
+	expire := config.Config.GetInt("User.tokenexpire")
+	if expire <= 0 {
+		expire = 24
+	}
+	claims := CustomClaims{
+		UserName: username,
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Duration(expire) * time.Hour).Unix(),
+			IssuedAt:  time.Now().Unix(),
+			Issuer:    config.Config.GetString("Server.project"),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, err = token.SignedString([]byte(SECRET))
+	if err != nil {
+		Logger.Error().Stack().Err(errors.New("生成token失败")).Msg(err.Error())
+		return "", errors.New("生成token失败, " + err.Error())
+	}
+	return tokenString, nil
+}
+
 // 解析token
 func (*jwtToken) ParseToken(tokenString string) (claims *CustomClaims, err error) {
 	//使用jwt.ParseWithClaims方法解析token，这个token是前端传给我们的,获得一个*Token类型的对象
@@ -10,6 +10,7 @@ package utils
 // LogInit 完成Zero 日志的初始化
 
 import (
+	"context"
 	"k8s-server/config"
 	"os"
 	"strings"
@@ -71,4 +72,11 @@ func LogInit() {
 		// log.Logger = log.Output(multi)
 		Logger = zerolog.New(output).With().Timestamp().Caller().Logger()
 	}	
+	// context中没有logger时使用全局Logger
+	zerolog.DefaultContextLogger = &Logger
+}
+
+// Log 返回context中的logger，请求的logger带有request_id字段，由middleware.RequestContext写入
+func Log(ctx context.Context) *zerolog.Logger {
+	return zerolog.Ctx(ctx)
 }
@@ -5,6 +5,8 @@ export default {
     k8sWorkflowDetail: 'http://host.docker.internal:9090/api/k8s/workflow/detail',
     k8sWorkflowList: 'http://host.docker.internal:9090/api/k8s/workflows',
     k8sWorkflowDel: 'http://host.docker.internal:9090/api/k8s/workflow/del',
+    k8sWorkflowUpdate: 'http://host.docker.internal:9090/api/k8s/workflow/update',
+    k8sWorkflowSync: 'http://host.docker.internal:9090/api/k8s/workflow/sync',
     k8sDeploymentList: 'http://host.docker.internal:9090/api/k8s/deployments',
     k8sDeploymentDetail: 'http://host.docker.internal:9090/api/k8s/deployment/detail',
     k8sDeploymentUpdate: 'http://host.docker.internal:9090/api/k8s/deployment/update',
@@ -57,7 +59,7 @@ export default {
     k8sNamespaceDel: 'http://host.docker.internal:9090/api/k8s/namespace/del',
     k8sPvList: 'http://host.docker.internal:9090/api/k8s/pvs',
     k8sPvDetail: 'http://host.docker.internal:9090/api/k8s/pv/detail',
-    k8sTerminalWs: 'ws://host.docker.internal:8082/ws',
+    k8sTerminalWs: 'ws://host.docker.internal:9090/api/k8s/terminal/ws',
     //编辑器配置
     cmOptions: {
         // 语言及语法模式